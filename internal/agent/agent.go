@@ -0,0 +1,250 @@
+// Package agent runs the long-lived monitoring daemon. It records the
+// metrics history, evaluates alert rules and serves the latest statistics
+// on a unix socket.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/alert"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/service"
+)
+
+// logFile is the agent log under the log directory
+const logFile = "agent.log"
+
+// Agent collects statistics every monitoring.check_interval
+type Agent struct {
+	// Reload reads the configuration again on SIGHUP
+	Reload func() error
+
+	log       *Logger
+	collector *monitoring.Collector
+	store     *monitoring.MetricsStore
+	engine    *alert.Engine
+	interval  time.Duration
+	prev      *monitoring.Snapshot
+	services  map[string]string
+
+	mu       sync.RWMutex
+	latest   *monitoring.SystemStats
+	latestAt time.Time
+	started  time.Time
+}
+
+// New returns an agent using the current configuration
+func New(reload func() error) *Agent {
+	return &Agent{Reload: reload}
+}
+
+// Interval returns the collection interval of the configuration
+func Interval() time.Duration {
+	interval := time.Duration(config.GetMonitoringConfig().CheckInterval) * time.Second
+	if interval <= 0 {
+		return time.Minute
+	}
+	return interval
+}
+
+// setup builds the collector, store and alert engine from the current
+// configuration
+func (a *Agent) setup() error {
+	engine, err := alert.NewEngine()
+	if err != nil {
+		return err
+	}
+
+	collector := monitoring.NewCollector()
+	collector.Paths = config.GetManagedDirectories()
+	collector.Services = service.Monitored()
+
+	cfg := config.GetMonitoringConfig()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.collector = collector
+	a.store = monitoring.NewMetricsStore(config.GetMetricsDir(), cfg.LogRetentionDays)
+	a.engine = engine
+	a.interval = Interval()
+	a.prev = nil
+	return nil
+}
+
+// Run collects until SIGTERM or SIGINT. The socket API is served for as
+// long as the agent runs.
+func (a *Agent) Run() error {
+	var err error
+	if a.log, err = NewLogger(filepath.Join(config.GetLogDir(), logFile)); err != nil {
+		return fmt.Errorf("failed to open agent log: %v", err)
+	}
+	defer a.log.Close()
+
+	if err := a.setup(); err != nil {
+		return err
+	}
+	a.started = time.Now()
+
+	socket := config.GetMonitoringConfig().AgentSocket
+	listener, err := listen(socket)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Printf("socket API stopped: %v", err)
+		}
+	}()
+	defer func() {
+		server.Close()
+		os.Remove(socket)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	a.log.Printf("agent started, collecting every %s, serving %s", a.interval, socket)
+
+	// The first sample comes after a short delay so rates are available
+	// right away
+	timer := time.NewTimer(monitoring.DefaultSampleInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				a.log.Printf("received %s, shutting down", sig)
+				return nil
+			}
+			a.reload()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(monitoring.DefaultSampleInterval)
+		case <-timer.C:
+			a.collect()
+			timer.Reset(a.interval)
+		}
+	}
+}
+
+// reload applies a changed configuration. The previous settings stay in
+// use when the new ones are invalid. The socket and log paths are only read
+// at start.
+func (a *Agent) reload() {
+	if a.Reload != nil {
+		if err := a.Reload(); err != nil {
+			a.log.Printf("reload failed: %v", err)
+			return
+		}
+	}
+
+	// setup only replaces the running settings when it succeeds
+	if err := a.setup(); err != nil {
+		a.log.Printf("reload failed: %v", err)
+		return
+	}
+	a.log.Printf("configuration reloaded, collecting every %s with %d alert rules", a.interval, len(a.engine.Rules))
+}
+
+// collect takes one sample, records it and evaluates the alert rules
+func (a *Agent) collect() {
+	cur, err := a.collector.Snapshot()
+	if err != nil {
+		a.log.Printf("failed to collect: %v", err)
+		return
+	}
+	if a.prev == nil {
+		a.prev = cur
+		time.Sleep(a.collector.Interval)
+		if cur, err = a.collector.Snapshot(); err != nil {
+			a.log.Printf("failed to collect: %v", err)
+			return
+		}
+	}
+
+	stats, err := a.collector.CollectBetween(a.prev, cur)
+	a.prev = cur
+	if err != nil {
+		a.log.Printf("failed to collect: %v", err)
+		return
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	a.latest, a.latestAt = stats, now
+	a.mu.Unlock()
+
+	if err := a.store.Append(monitoring.NewMetricSample(now, stats)); err != nil {
+		a.log.Printf("failed to record metrics: %v", err)
+	}
+	if removed, err := a.store.Prune(now); err != nil {
+		a.log.Printf("failed to prune metrics history: %v", err)
+	} else if removed > 0 {
+		a.log.Printf("removed %d expired metrics files", removed)
+	}
+
+	// Service changes are logged once, not on every sample
+	if a.services == nil {
+		a.services = make(map[string]string)
+	}
+	for service, status := range stats.Services {
+		if last, ok := a.services[service]; status != last && (ok || status != "active") {
+			a.log.Printf("service %s is %s", service, status)
+		}
+		a.services[service] = status
+	}
+
+	if len(a.engine.Rules) == 0 {
+		return
+	}
+	in, err := a.engine.InputFor(stats)
+	if err == nil {
+		err = a.engine.Evaluate(in)
+	}
+	if err != nil {
+		a.log.Printf("failed to evaluate alert rules: %v", err)
+	}
+}
+
+// listen opens the unix socket, replacing a socket left behind by an agent
+// that did not shut down cleanly
+func listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another agent is already serving %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", socket, err)
+	}
+	// The API exposes nothing secret, but only root manages the panel
+	if err := os.Chmod(socket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+// StatusResponse is returned by GET /v1/status
+type StatusResponse struct {
+	Started     time.Time               `json:"started"`
+	Interval    time.Duration           `json:"interval"`
+	CollectedAt time.Time               `json:"collected_at"`
+	Stats       *monitoring.SystemStats `json:"stats"`
+}
+
+// handler serves the socket API:
+//
+//	GET /v1/status                 latest statistics
+//	GET /v1/history?since=<unix>   recorded metrics since a time
+func (a *Agent) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		resp := StatusResponse{
+			Started:     a.started,
+			Interval:    a.interval,
+			CollectedAt: a.latestAt,
+			Stats:       a.latest,
+		}
+		a.mu.RUnlock()
+
+		if resp.Stats == nil {
+			http.Error(w, "no statistics collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, resp)
+	})
+
+	mux.HandleFunc("/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			http.Error(w, "since must be a unix time", http.StatusBadRequest)
+			return
+		}
+
+		a.mu.RLock()
+		store := a.store
+		a.mu.RUnlock()
+
+		samples, err := store.Load(time.Unix(since, 0))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, samples)
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client queries a running agent over its socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the agent listening on socket
+func NewClient(socket string) *Client {
+	dialer := &net.Dialer{Timeout: time.Second}
+	return &Client{
+		http: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// get decodes the JSON response of an API path
+func (c *Client) get(path string, v interface{}) error {
+	resp, err := c.http.Get("http://agent" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Status returns the latest statistics collected by the agent
+func (c *Client) Status() (*StatusResponse, error) {
+	var resp StatusResponse
+	if err := c.get("/v1/status", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// History returns the metrics recorded since a point in time
+func (c *Client) History(since time.Time) ([]monitoring.MetricSample, error) {
+	var samples []monitoring.MetricSample
+	err := c.get("/v1/history?since="+url.QueryEscape(strconv.FormatInt(since.Unix(), 10)), &samples)
+	return samples, err
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+)
+
+// ServiceName is the systemd unit running the agent
+const ServiceName = "webpanel-agent"
+
+func unitPath() string {
+	return filepath.Join(scheduler.DefaultUnitDir, ServiceName+".service")
+}
+
+// Install writes the systemd unit running command as the agent and
+// enables it
+func Install(command []string) error {
+	unit := fmt.Sprintf(`[Unit]
+Description=webpanel monitoring agent
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, scheduler.QuoteArgs(command))
+
+	if err := fileutil.WriteFile(unitPath(), []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write unit file: %v", err)
+	}
+	if err := scheduler.Systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return scheduler.Systemctl("enable", "--now", ServiceName+".service")
+}
+
+// Uninstall stops the agent and removes its unit
+func Uninstall() error {
+	if _, err := os.Stat(unitPath()); os.IsNotExist(err) {
+		return nil
+	}
+	if err := scheduler.Systemctl("disable", "--now", ServiceName+".service"); err != nil {
+		return err
+	}
+	if err := fileutil.Remove(unitPath()); err != nil {
+		return fmt.Errorf("failed to remove unit file: %v", err)
+	}
+	return scheduler.Systemctl("daemon-reload")
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/logs"
+)
+
+// Logger writes timestamped lines to the agent log, rotating it when it
+// grows beyond MaxSize. A log rotated by someone else is reopened.
+type Logger struct {
+	Path     string
+	MaxSize  int64 // bytes
+	MaxFiles int   // rotated logs kept, as agent.log.1 and so on
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewLogger opens the log at path for appending, with the limits of the
+// logging configuration
+func NewLogger(path string) (*Logger, error) {
+	cfg := config.GetLoggingConfig()
+	l := &Logger{Path: path, MaxSize: int64(cfg.MaxSize) << 20, MaxFiles: cfg.MaxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Printf writes one line to the log. Errors writing the log are reported
+// on stderr, which systemd keeps in the journal.
+func (l *Logger) Printf(format string, args ...interface{}) {
+	line := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.moved() {
+		l.file.Close()
+		if err := l.open(); err != nil {
+			l.file = nil
+			fmt.Fprintf(os.Stderr, "failed to reopen %s: %v\n", l.Path, err)
+		}
+	}
+	if l.MaxSize > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate %s: %v\n", l.Path, err)
+		}
+	}
+	if l.file == nil {
+		fmt.Fprint(os.Stderr, line)
+		return
+	}
+
+	n, err := l.file.WriteString(line)
+	l.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", l.Path, err)
+	}
+}
+
+// moved reports whether the open file is no longer at Path, e.g. after
+// "webpanel logs rotate" or logrotate
+func (l *Logger) moved() bool {
+	if l.file == nil {
+		return false
+	}
+	current, err := l.file.Stat()
+	if err != nil {
+		return false
+	}
+	latest, err := os.Stat(l.Path)
+	return err != nil || !os.SameFile(current, latest)
+}
+
+// rotate shifts agent.log to agent.log.1 and so on, dropping the oldest
+func (l *Logger) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	if _, err := logs.Rotate(l.Path, l.MaxFiles); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,248 @@
+package alert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/notify"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// Engine evaluates the configured rules and sends notifications when
+// alerts fire, repeat and resolve
+type Engine struct {
+	Rules          []*Rule
+	RepeatInterval time.Duration
+	Notifiers      []notify.Notifier
+
+	collector *monitoring.Collector
+	logs      *monitoring.AccessLogWatcher
+	prev      *monitoring.Snapshot
+}
+
+// LoadRules parses the rules of the configuration
+func LoadRules(cfg config.AlertsConfig) ([]*Rule, error) {
+	seen := make(map[string]bool)
+	var rules []*Rule
+	for _, rc := range cfg.Rules {
+		rule, err := ParseRule(rc.Name, rc.Expr, rc.Severity)
+		if err != nil {
+			return nil, err
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule name: %s", rule.Name)
+		}
+		seen[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NewEngine returns an engine for the configured rules and notification
+// channels
+func NewEngine() (*Engine, error) {
+	cfg := config.GetAlertsConfig()
+	rules, err := LoadRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := monitoring.NewCollector()
+	collector.Paths = config.GetManagedDirectories()
+	collector.Services = service.Monitored()
+
+	return &Engine{
+		Rules:          rules,
+		RepeatInterval: cfg.RepeatInterval,
+		Notifiers:      notify.FromConfig(config.GetNotificationConfig()),
+		collector:      collector,
+		logs:           monitoring.NewAccessLogWatcher(nil),
+	}, nil
+}
+
+// Gather collects the statistics the rules need. Rates cover the time
+// since the previous call, the first call samples over the collector
+// interval.
+func (e *Engine) Gather() (*Input, error) {
+	if e.prev == nil {
+		prev, err := e.collector.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		e.prev = prev
+		time.Sleep(e.collector.Interval)
+	}
+
+	cur, err := e.collector.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := e.collector.CollectBetween(e.prev, cur)
+	e.prev = cur
+	if err != nil {
+		return nil, err
+	}
+
+	return e.InputFor(stats)
+}
+
+// InputFor completes system statistics collected elsewhere with the site
+// and backup data the rules need
+func (e *Engine) InputFor(stats *monitoring.SystemStats) (*Input, error) {
+	in := &Input{Now: time.Now(), Stats: stats}
+
+	needSites, needBackups := false, false
+	for _, rule := range e.Rules {
+		needSites = needSites || rule.NeedsSites()
+		needBackups = needBackups || rule.NeedsBackups()
+	}
+
+	if needSites {
+		domains, err := site.List()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sites: %v", err)
+		}
+		for _, domain := range domains {
+			e.logs.Add(domain, filepath.Join(config.GetSiteLogDirectory(domain), "access.log"))
+		}
+		in.Sites = e.logs.Poll()
+	}
+
+	if needBackups {
+		var err error
+		if in.Backups, err = backup.Status(in.Now); err != nil {
+			return nil, fmt.Errorf("failed to get backup status: %v", err)
+		}
+	}
+
+	return in, nil
+}
+
+// Evaluate applies every rule to the input, updates the persisted state
+// and sends the notifications that are due
+func (e *Engine) Evaluate(in *Input) error {
+	unlock, err := LockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("failed to read alert state: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, rule := range e.Rules {
+		for _, result := range rule.Evaluate(in) {
+			key := alertKey(rule.Name, result.Instance)
+			if result.Active {
+				seen[key] = true
+				e.activate(state, rule, result, in.Now)
+			}
+		}
+	}
+
+	// Alerts whose condition cleared, or whose rule was removed
+	for key, a := range state.Alerts {
+		if seen[key] {
+			continue
+		}
+		delete(state.Alerts, key)
+		if a.State == StateFiring && !a.NotifiedAt.IsZero() && !state.Silenced(a.Rule, in.Now) {
+			e.send("alert_resolved", a, in.Now)
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("failed to save alert state: %v", err)
+	}
+	return nil
+}
+
+// activate records an active condition and notifies when the alert starts
+// firing or is due for a repeat
+func (e *Engine) activate(state *State, rule *Rule, result Result, now time.Time) {
+	key := alertKey(rule.Name, result.Instance)
+	a, ok := state.Alerts[key]
+	if !ok {
+		a = &Alert{
+			Rule:        rule.Name,
+			Instance:    result.Instance,
+			State:       StatePending,
+			ActiveSince: now,
+		}
+		state.Alerts[key] = a
+	}
+	a.Severity = rule.Severity
+	a.Value = result.Value
+
+	if a.State == StatePending && now.Sub(a.ActiveSince) >= rule.For {
+		a.State = StateFiring
+		a.FiredAt = now
+	}
+	if a.State != StateFiring || state.Silenced(rule.Name, now) {
+		return
+	}
+
+	due := a.NotifiedAt.IsZero()
+	if !due && e.RepeatInterval > 0 && now.Sub(a.NotifiedAt) >= e.RepeatInterval {
+		due = true
+	}
+	if due && e.send("alert_firing", a, now) {
+		a.NotifiedAt = now
+	}
+}
+
+// send notifies about an alert and reports whether any channel accepted it
+func (e *Engine) send(event string, a *Alert, now time.Time) bool {
+	if len(e.Notifiers) == 0 {
+		return false
+	}
+
+	name := a.Rule
+	if a.Instance != "" {
+		name += " (" + a.Instance + ")"
+	}
+
+	var subject, body string
+	if event == "alert_resolved" {
+		subject = fmt.Sprintf("RESOLVED: %s", name)
+		body = fmt.Sprintf("Alert %s resolved after %s.", name, now.Sub(a.ActiveSince).Round(time.Second))
+	} else {
+		subject = fmt.Sprintf("FIRING [%s]: %s", a.Severity, name)
+		body = fmt.Sprintf("Alert %s is firing since %s, current value %s.",
+			name, a.FiredAt.Format("2006-01-02 15:04:05"), a.Value)
+	}
+
+	msg := notify.NewMessage(event, subject, body)
+	msg.Fields["rule"] = a.Rule
+	msg.Fields["severity"] = a.Severity
+	msg.Fields["value"] = a.Value
+	if a.Instance != "" {
+		msg.Fields["instance"] = a.Instance
+	}
+	for _, rule := range e.Rules {
+		if rule.Name == a.Rule {
+			msg.Fields["expr"] = rule.Expr
+		}
+	}
+
+	// A channel that failed is not retried on its own, delivery through
+	// any channel counts
+	delivered := false
+	for _, n := range e.Notifiers {
+		if err := n.Send(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to send %s notification: %v\n", n.Name(), err)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
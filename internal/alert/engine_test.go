@@ -0,0 +1,209 @@
+package alert
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/notify"
+)
+
+// fakeNotifier records the messages it is sent
+type fakeNotifier struct {
+	sent []notify.Message
+	err  error
+}
+
+func (f *fakeNotifier) Name() string { return "fake" }
+
+func (f *fakeNotifier) Send(msg notify.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// events returns the events sent since the previous call
+func (f *fakeNotifier) events() []string {
+	var events []string
+	for _, msg := range f.sent {
+		events = append(events, msg.Event)
+	}
+	f.sent = nil
+	return events
+}
+
+// testEngine returns an engine with one rule and the alert state in a
+// temporary directory
+func testEngine(t *testing.T, expr string) (*Engine, *fakeNotifier) {
+	t.Helper()
+	root := t.TempDir()
+	cfg := &config.Config{
+		ConfigDir: filepath.Join(root, "etc"),
+		LogDir:    filepath.Join(root, "log"),
+	}
+	config.SetConfig(cfg)
+
+	rule, err := ParseRule("caddy-down", expr, "critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{}
+	return &Engine{
+		Rules:          []*Rule{rule},
+		RepeatInterval: 30 * time.Minute,
+		Notifiers:      []notify.Notifier{n},
+	}, n
+}
+
+// evaluate runs one round with the given status of caddy
+func evaluate(t *testing.T, e *Engine, now time.Time, status string) *State {
+	t.Helper()
+	in := &Input{Now: now, Stats: &monitoring.SystemStats{Services: map[string]string{"caddy": status}}}
+	if err := e.Evaluate(in); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func sameEvents(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEngineTransitions(t *testing.T) {
+	e, n := testEngine(t, "service caddy != active for 5m")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		after  time.Duration
+		status string
+		state  string // "" when no alert is active
+		events []string
+	}{
+		{0, "failed", StatePending, nil},
+		{2 * time.Minute, "failed", StatePending, nil},
+		{5 * time.Minute, "failed", StateFiring, []string{"alert_firing"}},
+		// Deduplicated until the repeat interval passed
+		{20 * time.Minute, "failed", StateFiring, nil},
+		{35 * time.Minute, "failed", StateFiring, []string{"alert_firing"}},
+		{40 * time.Minute, "active", "", []string{"alert_resolved"}},
+		// A pending alert that clears resolves silently
+		{50 * time.Minute, "failed", StatePending, nil},
+		{51 * time.Minute, "active", "", nil},
+	}
+	for _, step := range steps {
+		state := evaluate(t, e, start.Add(step.after), step.status)
+
+		a := state.Alerts["caddy-down"]
+		switch {
+		case step.state == "" && a != nil:
+			t.Errorf("+%s: alert still %s", step.after, a.State)
+		case step.state != "" && a == nil:
+			t.Errorf("+%s: no alert, want %s", step.after, step.state)
+		case a != nil && a.State != step.state:
+			t.Errorf("+%s: alert %s, want %s", step.after, a.State, step.state)
+		}
+		if events := n.events(); !sameEvents(events, step.events...) {
+			t.Errorf("+%s: sent %v, want %v", step.after, events, step.events)
+		}
+	}
+}
+
+func TestEngineFiresWithoutFor(t *testing.T) {
+	e, n := testEngine(t, "service caddy != active")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	state := evaluate(t, e, now, "inactive")
+	if a := state.Alerts["caddy-down"]; a == nil || a.State != StateFiring || a.Value != "inactive" {
+		t.Fatalf("alert = %+v, want firing", a)
+	}
+	sent := n.sent
+	if !sameEvents(n.events(), "alert_firing") {
+		t.Fatalf("sent %v", sent)
+	}
+	msg := sent[0]
+	if msg.Subject != "FIRING [critical]: caddy-down" || msg.Fields["expr"] != "service caddy != active" || msg.Fields["value"] != "inactive" {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestEngineSilence(t *testing.T) {
+	e, n := testEngine(t, "service caddy != active")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	state, err := LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Silence("caddy-down", now.Add(time.Hour), "maintenance", now)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Silenced alerts still fire, without notifications
+	state = evaluate(t, e, now, "failed")
+	if a := state.Alerts["caddy-down"]; a == nil || a.State != StateFiring {
+		t.Errorf("alert = %+v, want firing", a)
+	}
+	if events := n.events(); len(events) != 0 {
+		t.Errorf("sent %v while silenced", events)
+	}
+
+	// Notified once the silence expires
+	evaluate(t, e, now.Add(61*time.Minute), "failed")
+	if events := n.events(); !sameEvents(events, "alert_firing") {
+		t.Errorf("sent %v after the silence", events)
+	}
+}
+
+func TestEngineRetriesFailedDelivery(t *testing.T) {
+	e, n := testEngine(t, "service caddy != active")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	n.err = errors.New("connection refused")
+	state := evaluate(t, e, now, "failed")
+
+	if a := state.Alerts["caddy-down"]; a == nil || !a.NotifiedAt.IsZero() {
+		t.Fatalf("alert = %+v, want firing without a notification", a)
+	}
+
+	// The next round sends the notification, not waiting for the repeat
+	n.err = nil
+	evaluate(t, e, now.Add(time.Minute), "failed")
+	if events := n.events(); !sameEvents(events, "alert_firing") {
+		t.Errorf("sent %v, want a retry", events)
+	}
+}
+
+func TestEngineRemovedRule(t *testing.T) {
+	e, n := testEngine(t, "service caddy != active")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	evaluate(t, e, now, "failed")
+	n.events()
+
+	// Alerts of a rule removed from the configuration resolve
+	e.Rules = nil
+	state := evaluate(t, e, now.Add(time.Minute), "failed")
+	if len(state.Alerts) != 0 {
+		t.Errorf("alerts = %v", state.Alerts)
+	}
+	if events := n.events(); !sameEvents(events, "alert_resolved") {
+		t.Errorf("sent %v", events)
+	}
+}
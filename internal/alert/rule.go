@@ -0,0 +1,305 @@
+// Package alert evaluates threshold rules against the system, site and
+// backup statistics and notifies when alerts fire and resolve.
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+// Rule kinds, chosen by the first word of the expression
+const (
+	kindSystem  = "system"
+	kindService = "service"
+	kindSite    = "site"
+	kindBackup  = "backup"
+)
+
+// systemMetrics are the metrics accepted in "<metric> <op> <value>" rules
+var systemMetrics = map[string]bool{
+	"cpu.usage_perc":    true,
+	"memory.usage_perc": true,
+	"swap.usage_perc":   true,
+	"load.1m":           true,
+	"load.5m":           true,
+	"load.15m":          true,
+	"disk.usage_perc":   true, // per watched mount
+	"disk.inode_perc":   true, // per watched mount
+	"tcp.established":   true,
+}
+
+// siteMetrics are the metrics accepted in "site <domain> <metric> ..." rules
+var siteMetrics = map[string]bool{
+	"requests_sec": true,
+	"5xx_sec":      true,
+	"5xx_rate":     true, // share of requests in percent
+}
+
+// Rule is a parsed alert rule. Expressions take one of these forms, each
+// optionally followed by "for <duration>":
+//
+//	<metric> <op> <number>              disk.usage_perc > 90
+//	service <name> <== | !=> <state>    service caddy != active
+//	site <domain | *> <metric> <op> <number>  site example.com 5xx_rate > 5%
+//	backup [target] age <op> <duration> backup age > 36h
+type Rule struct {
+	Name     string
+	Expr     string
+	Severity string
+	For      time.Duration
+
+	kind      string
+	target    string
+	metric    string
+	op        string
+	threshold float64
+	state     string
+}
+
+// Input holds the statistics a round of rules is evaluated against
+type Input struct {
+	Now     time.Time
+	Stats   *monitoring.SystemStats
+	Sites   []monitoring.SiteRequestRate // nil when no site rule is configured
+	Backups []backup.TargetStatus        // nil when no backup rule is configured
+}
+
+// Result is the outcome of a rule for one instance, e.g. one mount
+type Result struct {
+	Instance string
+	Value    string
+	Active   bool
+}
+
+// ParseRule parses the expression of a rule
+func ParseRule(name, expr, severity string) (*Rule, error) {
+	if name == "" {
+		return nil, fmt.Errorf("alert rule %q has no name", expr)
+	}
+	if severity == "" {
+		severity = "warning"
+	}
+	r := &Rule{Name: name, Expr: expr, Severity: severity}
+
+	fields := strings.Fields(expr)
+	if n := len(fields); n >= 2 && fields[n-2] == "for" {
+		d, err := time.ParseDuration(fields[n-1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("alert rule %s: invalid duration %q", name, fields[n-1])
+		}
+		r.For = d
+		fields = fields[:n-2]
+	}
+
+	invalid := func() (*Rule, error) {
+		return nil, fmt.Errorf("alert rule %s: cannot parse %q", name, expr)
+	}
+
+	if len(fields) == 0 {
+		return invalid()
+	}
+
+	switch fields[0] {
+	case kindService:
+		if len(fields) != 4 || (fields[2] != "==" && fields[2] != "!=") {
+			return invalid()
+		}
+		r.kind, r.target, r.op, r.state = kindService, fields[1], fields[2], fields[3]
+		return r, nil
+
+	case kindSite:
+		if len(fields) != 5 || !siteMetrics[fields[2]] {
+			return invalid()
+		}
+		r.kind, r.target, r.metric = kindSite, fields[1], fields[2]
+		return r, r.parseComparison(fields[3], fields[4], false)
+
+	case kindBackup:
+		// The target is optional: "backup age > 36h" covers every target
+		if len(fields) == 5 {
+			r.target = fields[1]
+			fields = append(fields[:1], fields[2:]...)
+		}
+		if len(fields) != 4 || fields[1] != "age" {
+			return invalid()
+		}
+		r.kind, r.metric = kindBackup, "age"
+		return r, r.parseComparison(fields[2], fields[3], true)
+
+	default:
+		if len(fields) != 3 || !systemMetrics[fields[0]] {
+			return invalid()
+		}
+		r.kind, r.metric = kindSystem, fields[0]
+		return r, r.parseComparison(fields[1], fields[2], false)
+	}
+}
+
+// parseComparison parses the operator and threshold of a rule. Thresholds
+// are numbers with an optional "%" or, for durations, Go durations.
+func (r *Rule) parseComparison(op, value string, duration bool) error {
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		r.op = op
+	default:
+		return fmt.Errorf("alert rule %s: invalid operator %q", r.Name, op)
+	}
+
+	if duration {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("alert rule %s: invalid duration %q", r.Name, value)
+		}
+		r.threshold = d.Seconds()
+		return nil
+	}
+
+	threshold, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return fmt.Errorf("alert rule %s: invalid number %q", r.Name, value)
+	}
+	r.threshold = threshold
+	return nil
+}
+
+// NeedsSites reports whether the rule reads site request rates
+func (r *Rule) NeedsSites() bool {
+	return r.kind == kindSite
+}
+
+// NeedsBackups reports whether the rule reads the backup status
+func (r *Rule) NeedsBackups() bool {
+	return r.kind == kindBackup
+}
+
+// compare applies the rule's operator
+func (r *Rule) compare(value float64) bool {
+	switch r.op {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	}
+	return false
+}
+
+// Evaluate returns the result of the rule for every instance it covers
+func (r *Rule) Evaluate(in *Input) []Result {
+	switch r.kind {
+	case kindService:
+		status, ok := in.Stats.Services[r.target]
+		if !ok {
+			status = "unknown"
+		}
+		active := status == r.state
+		if r.op == "!=" {
+			active = !active
+		}
+		return []Result{{Value: status, Active: active}}
+
+	case kindSite:
+		var results []Result
+		for _, s := range in.Sites {
+			if r.target != "*" && r.target != s.Domain {
+				continue
+			}
+			value := s.RequestsSec
+			text := fmt.Sprintf("%.2f/s", value)
+			switch r.metric {
+			case "5xx_sec":
+				value = s.ServerErrorSec
+				text = fmt.Sprintf("%.2f/s", value)
+			case "5xx_rate":
+				value = 0
+				if s.RequestsSec > 0 {
+					value = s.ServerErrorSec / s.RequestsSec * 100
+				}
+				text = fmt.Sprintf("%.1f%%", value)
+			}
+			results = append(results, Result{Instance: s.Domain, Value: text, Active: r.compare(value)})
+		}
+		return results
+
+	case kindBackup:
+		var results []Result
+		for _, b := range in.Backups {
+			if r.target != "" && r.target != b.Target {
+				continue
+			}
+			// Targets that were never backed up and are not scheduled are
+			// not expected to have backups
+			if b.LastSuccess == nil && len(b.Schedules) == 0 {
+				continue
+			}
+			age := b.Age(in.Now)
+			text := age.Round(time.Minute).String()
+			if b.LastSuccess == nil {
+				age = time.Duration(1<<63 - 1)
+				text = "never"
+			}
+			results = append(results, Result{Instance: b.Kind + "/" + b.Target, Value: text, Active: r.compare(age.Seconds())})
+		}
+		return results
+	}
+
+	return r.evaluateSystem(in.Stats)
+}
+
+// evaluateSystem evaluates a "<metric> <op> <value>" rule
+func (r *Rule) evaluateSystem(stats *monitoring.SystemStats) []Result {
+	single := func(value float64, format string) []Result {
+		return []Result{{Value: fmt.Sprintf(format, value), Active: r.compare(value)}}
+	}
+
+	switch r.metric {
+	case "cpu.usage_perc":
+		return single(stats.CPU, "%.1f%%")
+	case "memory.usage_perc":
+		return single(stats.Memory.UsagePerc, "%.1f%%")
+	case "swap.usage_perc":
+		value := 0.0
+		if stats.Memory.SwapTotal > 0 {
+			value = float64(stats.Memory.SwapUsed) / float64(stats.Memory.SwapTotal) * 100
+		}
+		return single(value, "%.1f%%")
+	case "load.1m":
+		return single(stats.Load.Load1, "%.2f")
+	case "load.5m":
+		return single(stats.Load.Load5, "%.2f")
+	case "load.15m":
+		return single(stats.Load.Load15, "%.2f")
+	case "tcp.established":
+		total := 0
+		for _, count := range stats.TCP.Established {
+			total += count
+		}
+		return single(float64(total), "%.0f")
+	}
+
+	var results []Result
+	for _, m := range stats.Mounts {
+		value := m.UsagePerc
+		if r.metric == "disk.inode_perc" {
+			value = m.InodeUsagePerc
+		}
+		results = append(results, Result{
+			Instance: m.MountPoint,
+			Value:    fmt.Sprintf("%.1f%%", value),
+			Active:   r.compare(value),
+		})
+	}
+	return results
+}
@@ -0,0 +1,140 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Rule
+	}{
+		{"disk.usage_perc > 90 for 10m", Rule{For: 10 * time.Minute, kind: kindSystem, metric: "disk.usage_perc", op: ">", threshold: 90}},
+		{"load.5m >= 4.5", Rule{kind: kindSystem, metric: "load.5m", op: ">=", threshold: 4.5}},
+		{"service caddy != active", Rule{kind: kindService, target: "caddy", op: "!=", state: "active"}},
+		{"service mariadb == failed for 1m", Rule{For: time.Minute, kind: kindService, target: "mariadb", op: "==", state: "failed"}},
+		{"site example.com 5xx_rate > 5%", Rule{kind: kindSite, target: "example.com", metric: "5xx_rate", op: ">", threshold: 5}},
+		{"site * requests_sec < 0.1 for 30m", Rule{For: 30 * time.Minute, kind: kindSite, target: "*", metric: "requests_sec", op: "<", threshold: 0.1}},
+		{"backup age > 36h", Rule{kind: kindBackup, metric: "age", op: ">", threshold: (36 * time.Hour).Seconds()}},
+		{"backup example.com age > 2h30m", Rule{kind: kindBackup, target: "example.com", metric: "age", op: ">", threshold: (150 * time.Minute).Seconds()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			rule, err := ParseRule("test", tt.expr, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Name, tt.want.Expr, tt.want.Severity = "test", tt.expr, "warning"
+			if *rule != tt.want {
+				t.Errorf("got %+v, want %+v", *rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"for 10m",
+		"disk.usage_perc > 90 for ten",
+		"disk.usage_perc > 90 for -5m",
+		"disk.usage_perc >> 90",
+		"disk.usage_perc > ninety",
+		"disk.usage > 90",
+		"disk.usage_perc 90",
+		"service caddy active",
+		"service caddy > active",
+		"site example.com 4xx_rate > 5%",
+		"site example.com 5xx_rate > 5% extra",
+		"backup age > 36",
+		"backup age > 36h extra",
+		"backup size > 36h",
+	} {
+		if _, err := ParseRule("test", expr, ""); err == nil {
+			t.Errorf("ParseRule(%q): expected an error", expr)
+		}
+	}
+
+	if _, err := ParseRule("", "load.1m > 1", ""); err == nil {
+		t.Error("expected an error for a rule without a name")
+	}
+}
+
+func TestEvaluateSystem(t *testing.T) {
+	stats := &monitoring.SystemStats{
+		CPU:    95,
+		Memory: monitoring.MemoryStats{SwapTotal: 1000, SwapUsed: 600},
+		Mounts: []monitoring.MountStats{
+			{MountPoint: "/", DiskStats: monitoring.DiskStats{UsagePerc: 50}},
+			{MountPoint: "/apps", DiskStats: monitoring.DiskStats{UsagePerc: 93.25}},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want []Result
+	}{
+		{"cpu.usage_perc > 90", []Result{{Value: "95.0%", Active: true}}},
+		{"swap.usage_perc >= 60", []Result{{Value: "60.0%", Active: true}}},
+		{"load.1m > 1", []Result{{Value: "0.00", Active: false}}},
+		{"disk.usage_perc > 90", []Result{
+			{Instance: "/", Value: "50.0%", Active: false},
+			{Instance: "/apps", Value: "93.2%", Active: true},
+		}},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule("test", tt.expr, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := rule.Evaluate(&Input{Stats: stats})
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.expr, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %+v, want %+v", tt.expr, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestEvaluateSitesAndBackups(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	in := &Input{
+		Now: now,
+		Sites: []monitoring.SiteRequestRate{
+			{Domain: "example.com", RequestsSec: 10, ServerErrorSec: 1},
+			{Domain: "other.org", RequestsSec: 0},
+		},
+		Backups: []backup.TargetStatus{
+			{Kind: backup.KindSite, Target: "example.com", LastSuccess: &backup.RunRecord{FinishedAt: now.Add(-48 * time.Hour)}},
+			{Kind: backup.KindSite, Target: "other.org", LastSuccess: &backup.RunRecord{FinishedAt: now.Add(-time.Hour)}},
+			// Never backed up and not scheduled
+			{Kind: backup.KindSite, Target: "new.net"},
+		},
+	}
+
+	rule, _ := ParseRule("errors", "site example.com 5xx_rate > 5%", "")
+	got := rule.Evaluate(in)
+	if len(got) != 1 || got[0] != (Result{Instance: "example.com", Value: "10.0%", Active: true}) {
+		t.Errorf("5xx_rate: got %+v", got)
+	}
+
+	// Sites without requests have no error rate
+	rule, _ = ParseRule("errors", "site * 5xx_rate > 5%", "")
+	if got := rule.Evaluate(in); len(got) != 2 || got[1].Active {
+		t.Errorf("5xx_rate of every site: got %+v", got)
+	}
+
+	rule, _ = ParseRule("stale", "backup age > 36h", "")
+	got = rule.Evaluate(in)
+	if len(got) != 2 || !got[0].Active || got[1].Active || got[0].Instance != "site/example.com" || got[0].Value != "48h0m0s" {
+		t.Errorf("backup age: got %+v", got)
+	}
+}
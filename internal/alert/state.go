@@ -0,0 +1,146 @@
+package alert
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
+)
+
+// stateFile is the file under the log directory holding active alerts and
+// silences, so notifications are not repeated across restarts
+const stateFile = "alerts.json"
+
+// Alert states
+const (
+	StatePending = "pending" // condition is true, waiting for the rule's "for" duration
+	StateFiring  = "firing"
+)
+
+// Alert is an active instance of a rule
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Instance    string    `json:"instance,omitempty"`
+	Severity    string    `json:"severity"`
+	Value       string    `json:"value"`
+	State       string    `json:"state"`
+	ActiveSince time.Time `json:"active_since"`
+	FiredAt     time.Time `json:"fired_at,omitempty"`
+	NotifiedAt  time.Time `json:"notified_at,omitempty"`
+}
+
+// Silence suppresses the notifications of a rule until a point in time
+type Silence struct {
+	Rule    string    `json:"rule"`
+	Until   time.Time `json:"until"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// State is the persisted alert state
+type State struct {
+	Alerts   map[string]*Alert `json:"alerts"`
+	Silences []Silence         `json:"silences,omitempty"`
+}
+
+// alertKey identifies an alert by rule and instance
+func alertKey(rule, instance string) string {
+	if instance == "" {
+		return rule
+	}
+	return rule + "/" + instance
+}
+
+func statePath() string {
+	return filepath.Join(config.GetLogDir(), stateFile)
+}
+
+// LockState takes the lock guarding the alert state, held from loading the
+// state until it is saved so the monitoring loop and "alerts silence" do
+// not overwrite each other's changes
+func LockState() (func(), error) {
+	l, err := lock.Named("alerts")
+	if err != nil {
+		return nil, err
+	}
+	return l.Release, nil
+}
+
+// LoadState reads the persisted alert state
+func LoadState() (*State, error) {
+	state := &State{Alerts: make(map[string]*Alert)}
+
+	data, err := os.ReadFile(statePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Alerts == nil {
+		state.Alerts = make(map[string]*Alert)
+	}
+	return state, nil
+}
+
+// Save writes the alert state, replacing the previous file atomically
+func (s *State) Save() error {
+	if err := os.MkdirAll(config.GetLogDir(), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFile(statePath(), data, 0644)
+}
+
+// Silenced reports whether notifications of a rule are suppressed at now
+func (s *State) Silenced(rule string, now time.Time) bool {
+	for _, silence := range s.Silences {
+		if silence.Rule == rule && now.Before(silence.Until) {
+			return true
+		}
+	}
+	return false
+}
+
+// Silence suppresses the notifications of a rule until the given time,
+// replacing an earlier silence of the rule. A zero time removes it.
+func (s *State) Silence(rule string, until time.Time, comment string, now time.Time) {
+	silences := s.Silences[:0]
+	for _, silence := range s.Silences {
+		// Expired silences are dropped as well
+		if silence.Rule != rule && now.Before(silence.Until) {
+			silences = append(silences, silence)
+		}
+	}
+	if !until.IsZero() {
+		silences = append(silences, Silence{Rule: rule, Until: until, Comment: comment})
+	}
+	s.Silences = silences
+}
+
+// Sorted returns the active alerts ordered by rule and instance
+func (s *State) Sorted() []*Alert {
+	alerts := make([]*Alert, 0, len(s.Alerts))
+	for _, a := range s.Alerts {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Instance < alerts[j].Instance
+	})
+	return alerts
+}
@@ -0,0 +1,187 @@
+// Package audit records the panel operations that change the server as
+// JSON lines in the webpanel log
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/logs"
+)
+
+// logFile is the audit log under the log directory
+const logFile = "webpanel.log"
+
+// Redacted replaces secrets on recorded command lines
+const Redacted = "[REDACTED]"
+
+// Results of a recorded operation
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// secretFlag matches flags whose value is a secret, e.g. --password or
+// --smtp-token
+var secretFlag = regexp.MustCompile(`(?i)^--?[a-z0-9-]*(password|passwd|secret|token|key)[a-z0-9-]*$`)
+
+// Record is one line of the audit log
+type Record struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	SudoUser  string    `json:"sudo_user,omitempty"`
+	Command   string    `json:"command"` // e.g. "site add"
+	Args      []string  `json:"args"`    // command line with secrets redacted
+	Resources []string  `json:"resources,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration"` // seconds
+}
+
+// Path returns the audit log path
+func Path() string {
+	return filepath.Join(config.GetLogDir(), logFile)
+}
+
+// NewRecord describes an operation that started at start. secrets are
+// argument values redacted from the command line, in addition to the
+// values of flags named like passwords, secrets, tokens and keys.
+func NewRecord(command string, args, resources, secrets []string, start time.Time, err error) *Record {
+	r := &Record{
+		Time:      start,
+		SudoUser:  os.Getenv("SUDO_USER"),
+		Command:   command,
+		Args:      Redact(args, secrets),
+		Resources: resources,
+		Result:    ResultSuccess,
+		Duration:  time.Since(start).Seconds(),
+	}
+	if u, uerr := user.Current(); uerr == nil {
+		r.User = u.Username
+	} else {
+		r.User = os.Getenv("USER")
+	}
+	if err != nil {
+		r.Result = ResultError
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Redact returns a command line with secret values replaced
+func Redact(args, secrets []string) []string {
+	isSecret := make(map[string]bool, len(secrets))
+	for _, s := range secrets {
+		if s != "" {
+			isSecret[s] = true
+		}
+	}
+
+	redacted := make([]string, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch name, _, hasValue := strings.Cut(arg, "="); {
+		case isSecret[arg]:
+			redacted[i] = Redacted
+		case secretFlag.MatchString(name) && hasValue:
+			redacted[i] = name + "=" + Redacted
+		case secretFlag.MatchString(name) && i+1 < len(args):
+			redacted[i] = arg
+			i++
+			redacted[i] = Redacted
+		default:
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
+
+// Write appends a record to the audit log
+func Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.GetLogDir(), 0755); err != nil {
+		return err
+	}
+	// The log names databases and users, only root reads it
+	f, err := os.OpenFile(Path(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Filter selects audit records. Zero fields match everything.
+type Filter struct {
+	Resource string
+	Command  string // prefix, e.g. "site" or "site add"
+	Since    time.Time
+	Failed   bool
+}
+
+// Match reports whether a record passes the filter
+func (f Filter) Match(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if f.Failed && r.Result != ResultError {
+		return false
+	}
+	if f.Command != "" && r.Command != f.Command && !strings.HasPrefix(r.Command, f.Command+" ") {
+		return false
+	}
+	if f.Resource != "" {
+		found := false
+		for _, resource := range r.Resources {
+			found = found || resource == f.Resource
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns the records of the audit log and its rotated files that
+// match the filter, oldest first. Lines that are not audit records are
+// skipped.
+func Query(f Filter) ([]Record, error) {
+	var records []Record
+	for _, path := range logs.RotatedFiles(Path()) {
+		r, err := logs.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			var record Record
+			if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Command == "" {
+				continue
+			}
+			if f.Match(&record) {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
@@ -1,13 +1,15 @@
 package backup
 
 import (
+	"compress/gzip"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
 	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/site"
 )
 
 const (
@@ -15,119 +17,120 @@ const (
 	WeeklyBackup = "weekly"
 )
 
-// BackupSite performs a backup of the specified site
-func BackupSite(domain, backupType string) error {
+// BackupSite performs a backup of the specified site and returns the
+// manifest of the new archive
+func BackupSite(domain, backupType string) (*Manifest, error) {
 	siteDir := config.GetSiteDirectory(domain)
 	if _, err := os.Stat(siteDir); os.IsNotExist(err) {
-		return fmt.Errorf("site directory does not exist: %s", siteDir)
+		return nil, fmt.Errorf("site directory does not exist: %s", siteDir)
 	}
 
 	now := time.Now()
-	backupDir := filepath.Join(config.GetBackupDir(), backupType, domain)
+	backupDir := TargetDir(backupType, domain)
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
-		return fmt.Errorf("failed to create backup directory: %v", err)
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
 	}
 
-	var filename string
-	if backupType == WeeklyBackup {
-		filename = fmt.Sprintf("%s-full.tar.gz", now.Format("2006-01-02"))
-	} else {
-		filename = fmt.Sprintf("%s.tar.gz", now.Format("2006-01-02"))
+	if err := checkSpace(KindSite, domain, backupType, backupDir, siteDir); err != nil {
+		return nil, err
 	}
 
+	filename := archiveName(now, backupType, "tar.gz")
 	backupPath := filepath.Join(backupDir, filename)
+	partialPath := backupPath + partialSuffix
+	manifest := newManifest(KindSite, domain, siteDir, backupType, filename, now)
 
 	// Create tar.gz archive
-	cmd := exec.Command("tar", "-czf", backupPath, "-C", filepath.Dir(siteDir), filepath.Base(siteDir))
+	cmd := lowPriorityCommand("tar", "-czf", partialPath, "-C", filepath.Dir(siteDir), filepath.Base(siteDir))
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to create backup archive: %v", err)
+		os.Remove(partialPath)
+		return nil, fmt.Errorf("failed to create backup archive: %v", err)
+	}
+
+	// Dump the databases linked to the site in the same run
+	meta, err := site.LoadMetadata(domain)
+	if err != nil {
+		os.Remove(partialPath)
+		return nil, err
+	}
+	for _, name := range meta.Databases {
+		dump, err := BackupDatabase(name, backupType)
+		if err != nil {
+			os.Remove(partialPath)
+			return nil, fmt.Errorf("failed to back up linked database %s: %v", name, err)
+		}
+		manifest.Databases = append(manifest.Databases, filepath.Join(backupType, name, dump.Archive))
+	}
+
+	if err := finishArchive(partialPath, backupPath, manifest); err != nil {
+		return nil, err
 	}
 
 	// Clean up old backups
-	if err := cleanOldBackups(backupDir, backupType); err != nil {
-		return fmt.Errorf("failed to clean old backups: %v", err)
+	if _, err := PruneBackups(backupDir, backupType, false); err != nil {
+		return nil, fmt.Errorf("failed to clean old backups: %v", err)
 	}
 
-	return nil
+	return manifest, nil
 }
 
-// EnableSiteBackup enables automatic backups for a site
-func EnableSiteBackup(domain, backupType string) error {
-	cronFile := fmt.Sprintf("/etc/cron.%s/webpanel-backup-%s-%s",
-		backupType, backupType, domain)
+// BackupDatabase performs a backup of the specified database and returns
+// the manifest of the new archive
+func BackupDatabase(name, backupType string) (*Manifest, error) {
+	now := time.Now()
+	backupDir := TargetDir(backupType, name)
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
 
-	var schedule string
-	if backupType == DailyBackup {
-		schedule = "0 1 * * * " // Run at 1 AM daily
-	} else {
-		schedule = "0 2 * * 0 " // Run at 2 AM on Sundays
+	if err := checkSpace(KindDatabase, name, backupType, backupDir, ""); err != nil {
+		return nil, err
 	}
 
-	command := fmt.Sprintf("webpanel backup %s %s\n", backupType, domain)
-	content := schedule + command
+	filename := archiveName(now, backupType, "sql.gz")
+	backupPath := filepath.Join(backupDir, filename)
+	partialPath := backupPath + partialSuffix
+	manifest := newManifest(KindDatabase, name, name, backupType, filename, now)
 
-	if err := os.WriteFile(cronFile, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to create cron job: %v", err)
+	if err := dumpDatabase(name, partialPath); err != nil {
+		os.Remove(partialPath)
+		return nil, fmt.Errorf("failed to create backup: %v", err)
 	}
 
-	return nil
-}
-
-// DisableSiteBackup disables automatic backups for a site
-func DisableSiteBackup(domain, backupType string) error {
-	cronFile := fmt.Sprintf("/etc/cron.%s/webpanel-backup-%s-%s",
-		backupType, backupType, domain)
+	if err := finishArchive(partialPath, backupPath, manifest); err != nil {
+		return nil, err
+	}
 
-	if err := os.Remove(cronFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove cron job: %v", err)
+	// Clean up old backups
+	if _, err := PruneBackups(backupDir, backupType, false); err != nil {
+		return nil, fmt.Errorf("failed to clean old backups: %v", err)
 	}
 
-	return nil
+	return manifest, nil
 }
 
-// cleanOldBackups removes old backups based on retention policy
-func cleanOldBackups(backupDir, backupType string) error {
-	entries, err := os.ReadDir(backupDir)
+// dumpDatabase writes a gzip compressed dump of a database to path
+func dumpDatabase(name, path string) error {
+	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	var maxAge time.Duration
-	if backupType == DailyBackup {
-		maxAge = 7 * 24 * time.Hour // Keep daily backups for 7 days
-	} else {
-		maxAge = 30 * 24 * time.Hour // Keep weekly backups for 30 days
+	gz := gzip.NewWriter(f)
+	if err := database.Dump(name, gz); err != nil {
+		return err
 	}
-
-	now := time.Now()
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		if now.Sub(info.ModTime()) > maxAge {
-			path := filepath.Join(backupDir, entry.Name())
-			if err := os.Remove(path); err != nil {
-				fmt.Printf("Warning: failed to remove old backup %s: %v\n", path, err)
-			}
-		}
+	if err := gz.Close(); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// IsSiteBackupEnabled checks if backup is enabled for a site
-func IsSiteBackupEnabled(domain, backupType string) bool {
-	cronFile := fmt.Sprintf("/etc/cron.%s/webpanel-backup-%s-%s",
-		backupType, backupType, domain)
-	_, err := os.Stat(cronFile)
-	return err == nil
+	return f.Sync()
 }
 
 // ListSiteBackups returns a list of backups for a site
 func ListSiteBackups(domain, backupType string) ([]string, error) {
-	backupDir := filepath.Join(config.GetBackupDir(), backupType, domain)
+	backupDir := TargetDir(backupType, domain)
 	entries, err := os.ReadDir(backupDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -145,3 +148,8 @@ func ListSiteBackups(domain, backupType string) ([]string, error) {
 
 	return backups, nil
 }
+
+// TargetDir returns the directory holding the backups of a site or database
+func TargetDir(backupType, name string) string {
+	return filepath.Join(config.GetBackupDir(), backupType, name)
+}
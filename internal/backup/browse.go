@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry is a file or directory inside a site archive. Paths are
+// relative to the site directory.
+type ArchiveEntry struct {
+	Path     string
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	Linkname string
+}
+
+// IsDir reports whether the entry is a directory
+func (e ArchiveEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ResolveSiteArchive finds the file archive of a site backup. The ID is an
+// archive name as shown by 'backup list' with or without its extension,
+// optionally prefixed with daily/ or weekly/, or a snapshot ID.
+func ResolveSiteArchive(domain, id string) (string, error) {
+	types := []string{DailyBackup, WeeklyBackup}
+	if t, name, ok := strings.Cut(id, "/"); ok {
+		if t != DailyBackup && t != WeeklyBackup {
+			return "", fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", t)
+		}
+		types = []string{t}
+		id = name
+	}
+
+	name := id
+	if !strings.HasSuffix(name, ".tar.gz") {
+		name += ".tar.gz"
+	}
+	// Archive names never hold a path, anything else is not looked up in
+	// the backup directories
+	if _, ok := ParseArchiveName(name); ok {
+		for _, t := range types {
+			archivePath := filepath.Join(TargetDir(t, domain), name)
+			if _, err := os.Stat(archivePath); err == nil {
+				return archivePath, nil
+			}
+		}
+	} else if len(types) == 1 {
+		return "", fmt.Errorf("invalid backup name: %s", id)
+	}
+
+	if snapshot, err := LoadSnapshot(domain, id); err == nil && snapshot.hasComponent("files") {
+		return filepath.Join(snapshot.Path(), snapshotFilesFile), nil
+	}
+
+	return "", fmt.Errorf("backup %s not found for %s", id, domain)
+}
+
+// ListArchiveEntries lists the entries of a site archive below dir. Only
+// direct children are returned unless recursive is set. If dir names a
+// file, that file is returned.
+func ListArchiveEntries(archivePath, dir string, recursive bool) ([]ArchiveEntry, error) {
+	dir = cleanArchivePath(dir)
+
+	var entries []ArchiveEntry
+	found := dir == ""
+	err := walkSiteArchive(archivePath, func(hdr *tar.Header, rel string, r io.Reader) error {
+		if rel == dir {
+			found = true
+			if hdr.Typeflag != tar.TypeDir {
+				entries = append(entries, newArchiveEntry(hdr, rel))
+			}
+			return nil
+		}
+
+		if !isBelow(rel, dir) {
+			return nil
+		}
+		found = true
+		sub := strings.TrimPrefix(rel, dir)
+		sub = strings.TrimPrefix(sub, "/")
+		if !recursive && strings.Contains(sub, "/") {
+			return nil
+		}
+		entries = append(entries, newArchiveEntry(hdr, rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s not found in backup", dir)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// ExtractFromArchive extracts a file or directory tree from a site archive
+// into dest, keeping its path relative to the site directory. It returns
+// the number of entries written.
+func ExtractFromArchive(archivePath, target, dest string) (int, error) {
+	target = cleanArchivePath(target)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create destination directory: %v", err)
+	}
+
+	count := 0
+	err := walkSiteArchive(archivePath, func(hdr *tar.Header, rel string, r io.Reader) error {
+		if rel != target && !isBelow(rel, target) {
+			return nil
+		}
+		if rel == "" {
+			return nil
+		}
+
+		written, err := extractEntry(hdr, r, filepath.Join(dest, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if written {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("%s not found in backup", target)
+	}
+
+	return count, nil
+}
+
+// walkSiteArchive calls fn for every entry of a site archive with its path
+// relative to the site directory
+func walkSiteArchive(archivePath string, fn func(hdr *tar.Header, rel string, r io.Reader) error) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("backup archive is damaged: %v", err)
+		}
+
+		// Archives store the site directory itself as the top level entry
+		name := strings.Trim(path.Clean(hdr.Name), "/")
+		_, rel, _ := strings.Cut(name, "/")
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		if err := fn(hdr, rel, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractEntry writes a single archive entry to target. Entries other than
+// directories, regular files and symlinks are skipped.
+func extractEntry(hdr *tar.Header, r io.Reader, target string) (bool, error) {
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode|0700); err != nil {
+			return false, err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false, err
+		}
+		os.Remove(target)
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false, err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return false, fmt.Errorf("failed to extract %s: %v", target, err)
+		}
+		if err := f.Close(); err != nil {
+			return false, err
+		}
+
+	default:
+		return false, nil
+	}
+
+	os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	return true, nil
+}
+
+// cleanArchivePath normalizes a path inside a site archive. Cleaning it as
+// an absolute path drops any .. that would leave the site directory.
+func cleanArchivePath(p string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// isBelow reports whether rel lies inside dir
+func isBelow(rel, dir string) bool {
+	if dir == "" {
+		return rel != ""
+	}
+	return strings.HasPrefix(rel, dir+"/")
+}
+
+func newArchiveEntry(hdr *tar.Header, rel string) ArchiveEntry {
+	return ArchiveEntry{
+		Path:     rel,
+		Size:     hdr.Size,
+		Mode:     hdr.FileInfo().Mode(),
+		ModTime:  hdr.ModTime,
+		Linkname: hdr.Linkname,
+	}
+}
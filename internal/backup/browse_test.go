@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+func TestResolveSiteArchive(t *testing.T) {
+	dir := t.TempDir()
+	config.SetConfig(&config.Config{BackupDir: filepath.Join(dir, "backup")})
+
+	daily := filepath.Join(TargetDir(DailyBackup, "example.com"), "2025-03-09.tar.gz")
+	weekly := filepath.Join(TargetDir(WeeklyBackup, "example.com"), "2025-03-03-full.tar.gz")
+	outside := filepath.Join(dir, "secret.tar.gz")
+	for _, path := range []string{daily, weekly, outside} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"2025-03-09", daily},
+		{"2025-03-09.tar.gz", daily},
+		{"daily/2025-03-09", daily},
+		{"2025-03-03-full", weekly},
+		{"weekly/2025-03-03-full.tar.gz", weekly},
+		{"weekly/2025-03-09", ""},
+		{"monthly/2025-03-09", ""},
+		{"daily/../../../secret", ""},
+		{"daily/../../../secret.tar.gz", ""},
+		{`daily/..\..\secret`, ""},
+		{"../../secret", ""},
+		{"2025-03-10", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveSiteArchive("example.com", tt.id)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ResolveSiteArchive(%q) = %s, want an error", tt.id, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveSiteArchive(%q) = %s, %v, want %s", tt.id, got, err, tt.want)
+		}
+	}
+}
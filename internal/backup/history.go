@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/notify"
+)
+
+// runHistoryFile is the JSON lines file under the log directory recording
+// every backup run
+const runHistoryFile = "backup-runs.jsonl"
+
+// RunRecord is one entry of the backup run history
+type RunRecord struct {
+	Kind       string    `json:"kind"`
+	Target     string    `json:"target"`
+	Type       string    `json:"type"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Archive    string    `json:"archive,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Succeeded reports whether the run produced a backup
+func (r RunRecord) Succeeded() bool {
+	return r.Error == ""
+}
+
+// Duration returns how long the run took
+func (r RunRecord) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// runHistoryPath returns the path of the run history file
+func runHistoryPath() string {
+	return filepath.Join(config.GetLogDir(), runHistoryFile)
+}
+
+// RunSiteBackup backs up a site, records the run in the history and sends
+// a notification if it fails
+func RunSiteBackup(domain, backupType string) error {
+	return run(KindSite, domain, backupType, BackupSite)
+}
+
+// RunDatabaseBackup backs up a database, records the run in the history and
+// sends a notification if it fails
+func RunDatabaseBackup(name, backupType string) error {
+	return run(KindDatabase, name, backupType, BackupDatabase)
+}
+
+func run(kind, target, backupType string, backupFunc func(string, string) (*Manifest, error)) error {
+	release, err := acquireSlot(target)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	record := RunRecord{
+		Kind:      kind,
+		Target:    target,
+		Type:      backupType,
+		StartedAt: time.Now(),
+	}
+
+	manifest, err := backupFunc(target, backupType)
+	record.FinishedAt = time.Now()
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Archive = manifest.Archive
+		record.Size = manifest.Size
+	}
+
+	if herr := appendRunRecord(record); herr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record backup run: %v\n", herr)
+	}
+
+	if err != nil && config.GetBackupConfig().NotifyOnFailure {
+		if nerr := notifyFailure(record); nerr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", nerr)
+		}
+	}
+
+	return err
+}
+
+// appendRunRecord adds a record to the run history
+func appendRunRecord(record RunRecord) error {
+	if err := os.MkdirAll(config.GetLogDir(), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(runHistoryPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// LoadRunHistory returns every recorded backup run, oldest first. Lines
+// that cannot be parsed are skipped.
+func LoadRunHistory() ([]RunRecord, error) {
+	f, err := os.Open(runHistoryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RunRecord{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// notifyFailure sends a backup failure through the configured channels
+func notifyFailure(record RunRecord) error {
+	notifiers := notify.FromConfig(config.GetNotificationConfig())
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	msg := notify.NewMessage("backup_failed",
+		fmt.Sprintf("%s backup of %s %s failed", record.Type, record.Kind, record.Target),
+		fmt.Sprintf("The %s backup of %s %s failed after %s:\n\n%s",
+			record.Type, record.Kind, record.Target, record.Duration().Round(time.Second), record.Error))
+	msg.Fields["kind"] = record.Kind
+	msg.Fields["target"] = record.Target
+	msg.Fields["type"] = record.Type
+	msg.Fields["error"] = record.Error
+
+	return notify.SendAll(notifiers, msg)
+}
@@ -0,0 +1,310 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/version"
+)
+
+const (
+	KindSite     = "site"
+	KindDatabase = "database"
+
+	// manifestSuffix is appended to an archive path to get its manifest path
+	manifestSuffix = ".manifest.json"
+	// partialSuffix marks an archive that is still being written
+	partialSuffix = ".partial"
+)
+
+// Verification status values
+const (
+	StatusOK         = "ok"
+	StatusCorrupt    = "corrupt"
+	StatusIncomplete = "incomplete"
+)
+
+// Manifest records how a backup archive was produced. It is written next to
+// the archive once the archive is complete.
+type Manifest struct {
+	ID          string        `json:"id"`
+	Kind        string        `json:"kind"`
+	Target      string        `json:"target"`
+	Source      string        `json:"source"`
+	Type        string        `json:"type"`
+	Archive     string        `json:"archive"`
+	StartedAt   time.Time     `json:"started_at"`
+	FinishedAt  time.Time     `json:"finished_at"`
+	Size        int64         `json:"size"`
+	FileCount   int           `json:"file_count"`
+	Compressor  string        `json:"compressor"`
+	Encryption  string        `json:"encryption"`
+	SHA256      string        `json:"sha256"`
+	ToolVersion string        `json:"tool_version"`
+	Databases   []string      `json:"databases,omitempty"` // dumps of linked databases, relative to the backup directory
+	Verified    *Verification `json:"verified,omitempty"`
+}
+
+// Verification records the result of the last `backup verify` run
+type Verification struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Status    string    `json:"status"`
+	Problem   string    `json:"problem,omitempty"`
+}
+
+// BackupInfo pairs an archive with its manifest, if one exists
+type BackupInfo struct {
+	Archive  Archive
+	Manifest *Manifest
+}
+
+// VerifyResult is the outcome of verifying a single archive
+type VerifyResult struct {
+	Path    string
+	Status  string
+	Problem string
+}
+
+// Duration returns how long the backup took
+func (m *Manifest) Duration() time.Duration {
+	return m.FinishedAt.Sub(m.StartedAt)
+}
+
+// newManifest returns a manifest for a backup that is about to start
+func newManifest(kind, target, source, backupType, archive string, started time.Time) *Manifest {
+	return &Manifest{
+		ID:          fmt.Sprintf("%s-%s-%s", target, backupType, started.Format("20060102T150405")),
+		Kind:        kind,
+		Target:      target,
+		Source:      source,
+		Type:        backupType,
+		Archive:     archive,
+		StartedAt:   started,
+		Compressor:  "gzip",
+		Encryption:  "none",
+		ToolVersion: version.Version,
+	}
+}
+
+// archiveName returns the file name for a new archive of the given type
+func archiveName(now time.Time, backupType, ext string) string {
+	if backupType == WeeklyBackup {
+		return fmt.Sprintf("%s-full.%s", now.Format("2006-01-02"), ext)
+	}
+	return fmt.Sprintf("%s.%s", now.Format("2006-01-02"), ext)
+}
+
+// manifestPath returns the manifest path for an archive
+func manifestPath(archivePath string) string {
+	return archivePath + manifestSuffix
+}
+
+// finishArchive moves a completed partial archive into place and writes its
+// manifest
+func finishArchive(partialPath, archivePath string, manifest *Manifest) error {
+	manifest.FinishedAt = time.Now()
+
+	if err := os.Rename(partialPath, archivePath); err != nil {
+		return fmt.Errorf("failed to finalize backup archive: %v", err)
+	}
+
+	info, err := inspectArchive(archivePath)
+	if err != nil {
+		return fmt.Errorf("backup archive is unreadable: %v", err)
+	}
+
+	manifest.Size = info.size
+	manifest.FileCount = info.files
+	manifest.SHA256 = info.sha256
+
+	return WriteManifest(archivePath, manifest)
+}
+
+// ReadManifest loads the manifest of an archive
+func ReadManifest(archivePath string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(archivePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	return &manifest, nil
+}
+
+// WriteManifest stores the manifest of an archive
+func WriteManifest(archivePath string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(manifestPath(archivePath), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	return nil
+}
+
+// ListBackups returns the archives of a site or database together with
+// their manifests, newest first
+func ListBackups(name, backupType string) ([]BackupInfo, error) {
+	archives, err := ListArchives(TargetDir(backupType, name))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(archives))
+	for _, archive := range archives {
+		manifest, err := ReadManifest(archive.Path)
+		if err != nil {
+			manifest = nil
+		}
+		backups = append(backups, BackupInfo{Archive: archive, Manifest: manifest})
+	}
+
+	return backups, nil
+}
+
+// VerifyBackups verifies every archive of a site or database. Archives that
+// were left half-written by an interrupted run are reported as incomplete.
+func VerifyBackups(name, backupType string) ([]VerifyResult, error) {
+	dir := TargetDir(backupType, name)
+	archives, err := ListArchives(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []VerifyResult
+	for _, archive := range archives {
+		results = append(results, VerifyArchive(archive.Path))
+	}
+
+	partials, _ := filepath.Glob(filepath.Join(dir, "*"+partialSuffix))
+	for _, path := range partials {
+		results = append(results, VerifyResult{
+			Path:    path,
+			Status:  StatusIncomplete,
+			Problem: "archive was never finished",
+		})
+	}
+
+	return results, nil
+}
+
+// VerifyArchive re-hashes an archive, checks that it decompresses and
+// compares it with its manifest. The result is recorded in the manifest.
+func VerifyArchive(archivePath string) VerifyResult {
+	result := VerifyResult{Path: archivePath, Status: StatusOK}
+
+	manifest, err := ReadManifest(archivePath)
+	if err != nil {
+		result.Status = StatusIncomplete
+		if os.IsNotExist(err) {
+			result.Problem = "manifest is missing, backup may not have finished"
+		} else {
+			result.Problem = err.Error()
+		}
+		return result
+	}
+
+	info, err := inspectArchive(archivePath)
+	switch {
+	case err != nil:
+		result.Status = StatusCorrupt
+		result.Problem = err.Error()
+	case info.size != manifest.Size:
+		result.Status = StatusCorrupt
+		result.Problem = fmt.Sprintf("size is %d bytes, manifest says %d", info.size, manifest.Size)
+	case info.sha256 != manifest.SHA256:
+		result.Status = StatusCorrupt
+		result.Problem = "SHA-256 checksum does not match manifest"
+	case info.files != manifest.FileCount:
+		result.Status = StatusCorrupt
+		result.Problem = fmt.Sprintf("archive holds %d files, manifest says %d", info.files, manifest.FileCount)
+	}
+
+	manifest.Verified = &Verification{
+		CheckedAt: time.Now(),
+		Status:    result.Status,
+		Problem:   result.Problem,
+	}
+	if err := WriteManifest(archivePath, manifest); err != nil {
+		fmt.Printf("Warning: failed to record verification for %s: %v\n", archivePath, err)
+	}
+
+	return result
+}
+
+type archiveInfo struct {
+	size   int64
+	files  int
+	sha256 string
+}
+
+// inspectArchive hashes an archive while fully decompressing it, counting
+// the regular files in tar archives along the way
+func inspectArchive(path string) (archiveInfo, error) {
+	info := archiveInfo{}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.size = stat.Size()
+
+	hash := sha256.New()
+	source := io.TeeReader(f, hash)
+
+	gz, err := gzip.NewReader(source)
+	if err != nil {
+		return info, fmt.Errorf("not a gzip archive: %v", err)
+	}
+	defer gz.Close()
+
+	if strings.HasSuffix(path, ".tar.gz") {
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return info, fmt.Errorf("tar stream is damaged: %v", err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				info.files++
+			}
+		}
+	}
+
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return info, fmt.Errorf("decompression failed: %v", err)
+	}
+
+	// Hash whatever the decompressor did not need to read
+	if _, err := io.Copy(io.Discard, source); err != nil {
+		return info, err
+	}
+
+	info.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+)
+
+const (
+	// spaceMargin is applied to the estimated archive size to allow for
+	// growth since the last backup
+	spaceMargin = 1.2
+
+	// slotDir holds the lock files limiting concurrent backups
+	slotDir = ".slots"
+
+	slotPollInterval = 5 * time.Second
+)
+
+// FreeSpace returns the number of bytes available in the file system
+// holding dir
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// checkSpace makes sure the backup disk can hold the next archive of a
+// target and still keep the configured minimum free. If space is short,
+// old archives of the target are pruned first when enabled.
+func checkSpace(kind, target, backupType, backupDir, sourceDir string) error {
+	cfg := config.GetBackupConfig()
+	if !cfg.SpaceCheck {
+		return nil
+	}
+
+	estimate := estimateSize(kind, target, sourceDir)
+	need := uint64(float64(estimate)*spaceMargin) + uint64(cfg.MinFreeMB)<<20
+
+	free, err := FreeSpace(backupDir)
+	if err != nil {
+		return fmt.Errorf("failed to check free space: %v", err)
+	}
+	if free >= need {
+		return nil
+	}
+
+	if cfg.PruneOnLowSpace {
+		removed, err := PruneBackups(backupDir, backupType, false)
+		if err != nil {
+			return fmt.Errorf("failed to prune old backups: %v", err)
+		}
+		if len(removed) > 0 {
+			fmt.Printf("Pruned %d old backup(s) of %s to free space\n", len(removed), target)
+			if free, err = FreeSpace(backupDir); err != nil {
+				return fmt.Errorf("failed to check free space: %v", err)
+			}
+			if free >= need {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("not enough free space in %s: %s available, %s needed (estimated archive %s plus %d MB reserve)",
+		config.GetBackupDir(),
+		monitoring.FormatBytes(free),
+		monitoring.FormatBytes(need),
+		monitoring.FormatBytes(uint64(estimate)),
+		cfg.MinFreeMB)
+}
+
+// estimateSize estimates the size of the next archive of a target from its
+// newest backup. Without a previous backup the uncompressed size of the
+// source directory is used, which overestimates on purpose.
+func estimateSize(kind, target, sourceDir string) int64 {
+	var newest *Manifest
+	for _, backupType := range []string{DailyBackup, WeeklyBackup} {
+		backups, err := ListBackups(target, backupType)
+		if err != nil {
+			continue
+		}
+		for _, b := range backups {
+			if b.Manifest == nil || b.Manifest.Kind != kind {
+				continue
+			}
+			if newest == nil || b.Manifest.FinishedAt.After(newest.FinishedAt) {
+				newest = b.Manifest
+			}
+		}
+	}
+	if newest != nil {
+		return newest.Size
+	}
+
+	if sourceDir == "" {
+		return 0
+	}
+
+	var total int64
+	filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// lowPriorityCommand returns a command running at the configured backup
+// CPU and I/O priority. Scheduled jobs already start at that priority, so
+// only the difference is applied.
+func lowPriorityCommand(name string, args ...string) *exec.Cmd {
+	cfg := config.GetBackupConfig()
+
+	nice := cfg.Nice - currentNice()
+	if nice < 0 {
+		nice = 0
+	}
+
+	prefix := scheduler.PriorityArgs(nice, cfg.IOClass)
+	if len(prefix) == 0 {
+		return exec.Command(name, args...)
+	}
+	return exec.Command(prefix[0], append(append(prefix[1:], name), args...)...)
+}
+
+// currentNice returns the nice value of the running process
+func currentNice() int {
+	// The raw syscall returns 20 - nice
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return 0
+	}
+	return 20 - prio
+}
+
+// acquireSlot waits until fewer than the configured number of site and
+// database backups are running and returns a function releasing the slot.
+// Slots are lock files, so the limit holds across scheduled jobs as well.
+func acquireSlot(target string) (func(), error) {
+	limit := config.GetBackupConfig().Concurrency
+	if limit < 1 {
+		limit = 1
+	}
+
+	dir := filepath.Join(config.GetBackupDir(), slotDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup slot directory: %v", err)
+	}
+
+	waiting := false
+	for {
+		for i := 0; i < limit; i++ {
+			f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("slot-%d", i)), os.O_CREATE|os.O_RDWR, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open backup slot: %v", err)
+			}
+			if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+				return func() {
+					syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+					f.Close()
+				}, nil
+			}
+			f.Close()
+		}
+
+		if !waiting {
+			fmt.Printf("Waiting for a free backup slot for %s (limit %d)\n", target, limit)
+			waiting = true
+		}
+		time.Sleep(slotPollInterval)
+	}
+}
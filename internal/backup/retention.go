@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// archiveNamePattern matches the file names produced by the panel, e.g.
+// 2025-03-09.tar.gz, 2025-03-03-full.tar.gz or 2025-03-09.sql.gz
+var archiveNamePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(-full)?\.(tar\.gz|sql\.gz)$`)
+
+// Archive describes a backup file created by the panel
+type Archive struct {
+	Name string
+	Path string
+	Date time.Time
+	Full bool
+}
+
+// RetentionPolicy decides which archives in a backup directory are kept.
+// An archive is kept when any of the rules selects it.
+type RetentionPolicy struct {
+	MaxAge      time.Duration // keep everything younger than this
+	KeepDaily   int           // newest archive of each of the last N days
+	KeepWeekly  int           // newest archive of each of the last N ISO weeks
+	KeepMonthly int           // newest archive of each of the last N months
+	MinKeep     int           // always keep the N newest archives
+}
+
+// ParseArchiveName parses a backup file name following the panel's naming
+// scheme. It returns false for files the panel did not create.
+func ParseArchiveName(name string) (Archive, bool) {
+	m := archiveNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return Archive{}, false
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", m[1], time.Local)
+	if err != nil {
+		return Archive{}, false
+	}
+
+	return Archive{
+		Name: name,
+		Date: date,
+		Full: m[2] != "",
+	}, true
+}
+
+// ListArchives returns the panel archives in a directory, newest first.
+// Files that do not match the naming scheme are ignored.
+func ListArchives(dir string) ([]Archive, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Archive{}, nil
+		}
+		return nil, err
+	}
+
+	var archives []Archive
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		archive, ok := ParseArchiveName(entry.Name())
+		if !ok {
+			continue
+		}
+		archive.Path = filepath.Join(dir, entry.Name())
+		archives = append(archives, archive)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		if archives[i].Date.Equal(archives[j].Date) {
+			return archives[i].Name > archives[j].Name
+		}
+		return archives[i].Date.After(archives[j].Date)
+	})
+
+	return archives, nil
+}
+
+// PolicyFor builds the retention policy for a backup type from the configuration
+func PolicyFor(backupType string) RetentionPolicy {
+	cfg := config.GetBackupConfig()
+
+	schedule := cfg.Daily
+	if backupType == WeeklyBackup {
+		schedule = cfg.Weekly
+	}
+
+	return RetentionPolicy{
+		MaxAge:      time.Duration(schedule.RetentionDays) * 24 * time.Hour,
+		KeepDaily:   cfg.Retention.KeepDaily,
+		KeepWeekly:  cfg.Retention.KeepWeekly,
+		KeepMonthly: cfg.Retention.KeepMonthly,
+		MinKeep:     cfg.Retention.MinKeep,
+	}
+}
+
+// Select splits archives (sorted newest first) into the ones to keep and
+// the ones to prune
+func (p RetentionPolicy) Select(archives []Archive, now time.Time) (keep, prune []Archive) {
+	selected := make(map[string]bool)
+
+	for i, archive := range archives {
+		if i < p.MinKeep {
+			selected[archive.Name] = true
+		}
+		if p.MaxAge > 0 && now.Sub(archive.Date) < p.MaxAge {
+			selected[archive.Name] = true
+		}
+	}
+
+	selectBuckets(archives, p.KeepDaily, selected, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	selectBuckets(archives, p.KeepWeekly, selected, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	selectBuckets(archives, p.KeepMonthly, selected, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	for _, archive := range archives {
+		if selected[archive.Name] {
+			keep = append(keep, archive)
+		} else {
+			prune = append(prune, archive)
+		}
+	}
+
+	return keep, prune
+}
+
+// selectBuckets marks the newest archive of each of the first n buckets
+func selectBuckets(archives []Archive, n int, selected map[string]bool, bucket func(time.Time) string) {
+	if n <= 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, archive := range archives {
+		key := bucket(archive.Date)
+		if seen[key] {
+			continue
+		}
+		if len(seen) == n {
+			return
+		}
+		seen[key] = true
+		selected[archive.Name] = true
+	}
+}
+
+// PruneBackups applies the retention policy for a backup type to a backup
+// directory. With dryRun set nothing is deleted. It returns the archives
+// that were (or would be) removed.
+func PruneBackups(backupDir, backupType string, dryRun bool) ([]Archive, error) {
+	archives, err := ListArchives(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, prune := PolicyFor(backupType).Select(archives, time.Now())
+	if dryRun {
+		return prune, nil
+	}
+
+	var removed []Archive
+	for _, archive := range prune {
+		if err := os.Remove(archive.Path); err != nil {
+			fmt.Printf("Warning: failed to remove old backup %s: %v\n", archive.Path, err)
+			continue
+		}
+		if err := os.Remove(manifestPath(archive.Path)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: failed to remove manifest of %s: %v\n", archive.Path, err)
+		}
+		removed = append(removed, archive)
+	}
+
+	return removed, nil
+}
+
+// ListBackupTargets returns the names of all sites and databases that have
+// a backup directory for the given backup type
+func ListBackupTargets(backupType string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(config.GetBackupDir(), backupType))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var targets []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			targets = append(targets, entry.Name())
+		}
+	}
+
+	return targets, nil
+}
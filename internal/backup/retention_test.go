@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+func TestParseArchiveName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+		date string
+		full bool
+	}{
+		{"2025-03-09.tar.gz", true, "2025-03-09", false},
+		{"2025-03-03-full.tar.gz", true, "2025-03-03", true},
+		{"2025-03-09.sql.gz", true, "2025-03-09", false},
+		{"2025-03-09.tar.gz.partial", false, "", false},
+		{"2025-03-09.tar.gz" + manifestSuffix, false, "", false},
+		{"2025-13-01.tar.gz", false, "", false},
+		{"2025-3-9.tar.gz", false, "", false},
+		{"2025-03-09.zip", false, "", false},
+		{"backup.tar.gz", false, "", false},
+		{"../2025-03-09.tar.gz", false, "", false},
+	}
+
+	for _, tt := range tests {
+		archive, ok := ParseArchiveName(tt.name)
+		if ok != tt.ok {
+			t.Errorf("ParseArchiveName(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := archive.Date.Format("2006-01-02"); got != tt.date || archive.Full != tt.full || archive.Name != tt.name {
+			t.Errorf("ParseArchiveName(%q) = %s full %v, want %s full %v", tt.name, got, archive.Full, tt.date, tt.full)
+		}
+	}
+}
+
+// dailyArchives returns one archive per day from first to last, newest
+// first, as ListArchives does
+func dailyArchives(t *testing.T, first, last string) []Archive {
+	t.Helper()
+	start, _ := time.ParseInLocation("2006-01-02", first, time.Local)
+	end, _ := time.ParseInLocation("2006-01-02", last, time.Local)
+
+	var archives []Archive
+	for d := end; !d.Before(start); d = d.AddDate(0, 0, -1) {
+		archive, ok := ParseArchiveName(d.Format("2006-01-02") + ".tar.gz")
+		if !ok {
+			t.Fatalf("unparsable date %s", d)
+		}
+		archives = append(archives, archive)
+	}
+	return archives
+}
+
+func TestRetentionSelect(t *testing.T) {
+	// 2025-03-31 is the Monday of ISO week 14
+	archives := dailyArchives(t, "2025-01-01", "2025-03-31")
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		keep   []string
+	}{
+		{"keep daily", RetentionPolicy{KeepDaily: 3},
+			[]string{"2025-03-31", "2025-03-30", "2025-03-29"}},
+		{"keep weekly", RetentionPolicy{KeepWeekly: 3},
+			[]string{"2025-03-31", "2025-03-30", "2025-03-23"}},
+		{"keep monthly", RetentionPolicy{KeepMonthly: 2},
+			[]string{"2025-03-31", "2025-02-28"}},
+		{"keep monthly beyond the oldest", RetentionPolicy{KeepMonthly: 12},
+			[]string{"2025-03-31", "2025-02-28", "2025-01-31"}},
+		{"min keep", RetentionPolicy{MinKeep: 2},
+			[]string{"2025-03-31", "2025-03-30"}},
+		{"max age", RetentionPolicy{MaxAge: 48 * time.Hour},
+			[]string{"2025-03-31"}},
+		{"rules combine", RetentionPolicy{KeepDaily: 2, KeepWeekly: 2, KeepMonthly: 3},
+			[]string{"2025-03-31", "2025-03-30", "2025-02-28", "2025-01-31"}},
+	}
+
+	for _, tt := range tests {
+		keep, prune := tt.policy.Select(archives, now)
+		var got []string
+		for _, archive := range keep {
+			got = append(got, archive.Date.Format("2006-01-02"))
+		}
+		if !reflect.DeepEqual(got, tt.keep) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.keep)
+		}
+		if len(keep)+len(prune) != len(archives) {
+			t.Errorf("%s: %d kept and %d pruned of %d", tt.name, len(keep), len(prune), len(archives))
+		}
+	}
+}
+
+func TestRetentionSelectCountsBackupDays(t *testing.T) {
+	// Days without a backup do not use up the daily buckets
+	archives := append(dailyArchives(t, "2025-03-30", "2025-03-31"), dailyArchives(t, "2025-03-20", "2025-03-20")...)
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.Local)
+
+	keep, prune := RetentionPolicy{KeepDaily: 3}.Select(archives, now)
+	if len(keep) != 3 || len(prune) != 0 {
+		t.Errorf("kept %d and pruned %d, want all 3 kept", len(keep), len(prune))
+	}
+}
+
+func TestPruneBackupsRemovesManifests(t *testing.T) {
+	dir := t.TempDir()
+	config.SetConfig(&config.Config{
+		BackupDir: dir,
+		Backup: config.BackupConfig{
+			Retention: config.RetentionConfig{KeepDaily: 1},
+		},
+	})
+
+	names := []string{"2025-03-31.tar.gz", "2025-03-30.tar.gz", "2025-03-29.tar.gz"}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		for _, file := range []string{path, manifestPath(path)} {
+			if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if pruned, err := PruneBackups(dir, DailyBackup, true); err != nil || len(pruned) != 2 {
+		t.Fatalf("dry run pruned %d, %v", len(pruned), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[2])); err != nil {
+		t.Fatalf("dry run removed an archive: %v", err)
+	}
+
+	pruned, err := PruneBackups(dir, DailyBackup, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 {
+		t.Fatalf("pruned %d archives, want 2", len(pruned))
+	}
+
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		for _, file := range []string{path, manifestPath(path)} {
+			_, err := os.Stat(file)
+			if kept := err == nil; kept != (i == 0) {
+				t.Errorf("%s: kept %v", filepath.Base(file), kept)
+			}
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+)
+
+const (
+	siteJobPrefix     = "webpanel-backup-"
+	databaseJobPrefix = "webpanel-dbbackup-"
+)
+
+// ScheduleInfo describes an installed backup schedule
+type ScheduleInfo struct {
+	Kind   string
+	Type   string
+	Target string
+	Status scheduler.JobStatus
+}
+
+// scheduleBackend is the scheduler used for backup jobs
+var scheduleBackend = scheduler.Detect()
+
+// jobName returns the scheduler job name for a backup
+func jobName(kind, backupType, target string) string {
+	if kind == KindDatabase {
+		return databaseJobPrefix + backupType + "-" + target
+	}
+	return siteJobPrefix + backupType + "-" + target
+}
+
+// scheduleFor returns the configured schedule for a backup type
+func scheduleFor(backupType string) (scheduler.Schedule, error) {
+	cfg := config.GetBackupConfig()
+	if backupType == WeeklyBackup {
+		return scheduler.ParseSchedule(cfg.Weekly.Day, cfg.Weekly.Time, cfg.RandomDelay)
+	}
+	return scheduler.ParseSchedule("", cfg.Daily.Time, cfg.RandomDelay)
+}
+
+// executable returns the path of the running webpanel binary for use in
+// scheduled jobs
+func executable() string {
+	path, err := os.Executable()
+	if err != nil {
+		return "webpanel"
+	}
+	return path
+}
+
+// enableBackup installs the scheduled job for a site or database backup
+func enableBackup(kind, backupType, target string) error {
+	schedule, err := scheduleFor(backupType)
+	if err != nil {
+		return fmt.Errorf("invalid %s backup schedule in config: %v", backupType, err)
+	}
+
+	command := []string{executable(), "backup", "run", backupType, target}
+	description := fmt.Sprintf("webpanel %s backup of site %s", backupType, target)
+	if kind == KindDatabase {
+		command = []string{executable(), "dbbackup", "run", backupType, target}
+		description = fmt.Sprintf("webpanel %s backup of database %s", backupType, target)
+	}
+
+	removeLegacyCronJob(backupType, target)
+
+	cfg := config.GetBackupConfig()
+	if !scheduler.ValidIOClass(cfg.IOClass) {
+		return fmt.Errorf("invalid io_class in config: %s (must be 'idle' or 'best-effort')", cfg.IOClass)
+	}
+
+	return scheduleBackend.Install(scheduler.Job{
+		Name:        jobName(kind, backupType, target),
+		Description: description,
+		Command:     command,
+		Schedule:    schedule,
+		Nice:        cfg.Nice,
+		IOClass:     cfg.IOClass,
+	})
+}
+
+// disableBackup removes the scheduled job for a site or database backup
+func disableBackup(kind, backupType, target string) error {
+	removeLegacyCronJob(backupType, target)
+	return scheduleBackend.Remove(jobName(kind, backupType, target))
+}
+
+// removeLegacyCronJob deletes the /etc/cron.daily or /etc/cron.weekly file
+// written by earlier versions
+func removeLegacyCronJob(backupType, target string) {
+	legacy := fmt.Sprintf("/etc/cron.%s/webpanel-backup-%s-%s", backupType, backupType, target)
+	if err := os.Remove(legacy); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to remove legacy cron job %s: %v\n", legacy, err)
+	}
+}
+
+// EnableSiteBackup enables automatic backups for a site
+func EnableSiteBackup(domain, backupType string) error {
+	return enableBackup(KindSite, backupType, domain)
+}
+
+// DisableSiteBackup disables automatic backups for a site
+func DisableSiteBackup(domain, backupType string) error {
+	return disableBackup(KindSite, backupType, domain)
+}
+
+// IsSiteBackupEnabled checks if backup is enabled for a site
+func IsSiteBackupEnabled(domain, backupType string) bool {
+	return scheduleBackend.Exists(jobName(KindSite, backupType, domain))
+}
+
+// EnableDatabaseBackup enables automatic backups for a database
+func EnableDatabaseBackup(name, backupType string) error {
+	return enableBackup(KindDatabase, backupType, name)
+}
+
+// DisableDatabaseBackup disables automatic backups for a database
+func DisableDatabaseBackup(name, backupType string) error {
+	return disableBackup(KindDatabase, backupType, name)
+}
+
+// IsDatabaseBackupEnabled checks if backup is enabled for a database
+func IsDatabaseBackupEnabled(name, backupType string) bool {
+	return scheduleBackend.Exists(jobName(KindDatabase, backupType, name))
+}
+
+// ListSchedules returns every installed site and database backup schedule
+func ListSchedules() ([]ScheduleInfo, error) {
+	var schedules []ScheduleInfo
+
+	for _, prefix := range []string{siteJobPrefix, databaseJobPrefix} {
+		names, err := scheduleBackend.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			info, ok := parseJobName(name)
+			if !ok {
+				continue
+			}
+			status, err := scheduleBackend.Status(name)
+			if err != nil {
+				status = scheduler.JobStatus{Name: name, Backend: scheduleBackend.Name(), NextRun: "-", LastRun: "-", Result: err.Error()}
+			}
+			info.Status = status
+			schedules = append(schedules, info)
+		}
+	}
+
+	return schedules, nil
+}
+
+// parseJobName splits a backup job name into kind, type and target
+func parseJobName(name string) (ScheduleInfo, bool) {
+	info := ScheduleInfo{Kind: KindSite}
+
+	rest, ok := strings.CutPrefix(name, databaseJobPrefix)
+	if ok {
+		info.Kind = KindDatabase
+	} else if rest, ok = strings.CutPrefix(name, siteJobPrefix); !ok {
+		return info, false
+	}
+
+	backupType, target, ok := strings.Cut(rest, "-")
+	if !ok || (backupType != DailyBackup && backupType != WeeklyBackup) {
+		return info, false
+	}
+
+	info.Type = backupType
+	info.Target = target
+	return info, true
+}
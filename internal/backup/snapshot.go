@@ -0,0 +1,487 @@
+package backup
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
+	"github.com/doko/cli-webpanel/internal/site"
+	"github.com/doko/cli-webpanel/internal/version"
+)
+
+const (
+	// SnapshotBackup is the directory under the backup root holding snapshots
+	SnapshotBackup = "snapshots"
+
+	snapshotManifestFile = "snapshot.json"
+	snapshotFilesFile    = "files.tar.gz"
+	snapshotLogsFile     = "logs.tar.gz"
+	snapshotConfigFile   = "site.conf"
+	snapshotMetaFile     = "meta.json"
+)
+
+// SnapshotManifest describes a site snapshot. A snapshot bundles the web
+// root, the Caddy site configuration, panel metadata, logs and a dump of
+// every linked database under one ID.
+type SnapshotManifest struct {
+	ID          string              `json:"id"`
+	Domain      string              `json:"domain"`
+	CreatedAt   time.Time           `json:"created_at"`
+	FinishedAt  time.Time           `json:"finished_at"`
+	SiteDir     string              `json:"site_dir"`
+	Imports     []string            `json:"imports"`
+	Databases   []string            `json:"databases,omitempty"`
+	Components  []SnapshotComponent `json:"components"`
+	ToolVersion string              `json:"tool_version"`
+}
+
+// SnapshotComponent is one file inside a snapshot directory
+type SnapshotComponent struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreOptions controls which parts of a snapshot are restored
+type RestoreOptions struct {
+	SkipDatabases bool
+}
+
+// SnapshotDir returns the directory holding the snapshots of a site
+func SnapshotDir(domain string) string {
+	return filepath.Join(config.GetBackupDir(), SnapshotBackup, domain)
+}
+
+// Path returns the directory of the snapshot
+func (s *SnapshotManifest) Path() string {
+	return filepath.Join(SnapshotDir(s.Domain), s.ID)
+}
+
+// Size returns the total size of all snapshot components
+func (s *SnapshotManifest) Size() int64 {
+	var total int64
+	for _, c := range s.Components {
+		total += c.Size
+	}
+	return total
+}
+
+// CreateSnapshot takes a snapshot of a site. Databases linked to the site
+// are dumped along with any extra databases given.
+func CreateSnapshot(domain string, extraDatabases []string) (*SnapshotManifest, error) {
+	// A restore of the same site would change it while it is read
+	l, err := lock.Site(domain)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	siteDir := config.GetSiteDirectory(domain)
+	if _, err := os.Stat(siteDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("site directory does not exist: %s", siteDir)
+	}
+
+	siteConfig, err := os.ReadFile(config.GetSiteConfigPath(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read site configuration: %v", err)
+	}
+
+	meta, err := site.LoadMetadata(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// The names become mysqldump arguments and file names in the snapshot
+	databases := mergeNames(meta.Databases, extraDatabases)
+	for _, name := range databases {
+		if err := config.ValidateDatabaseName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	snapshot := &SnapshotManifest{
+		ID:          now.Format("20060102-150405"),
+		Domain:      domain,
+		CreatedAt:   now,
+		SiteDir:     siteDir,
+		Imports:     importLines(string(siteConfig)),
+		Databases:   databases,
+		ToolVersion: version.Version,
+	}
+
+	finalDir := snapshot.Path()
+	workDir := finalDir + partialSuffix
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+
+	if err := writeSnapshotComponents(snapshot, workDir, siteConfig, meta); err != nil {
+		os.RemoveAll(workDir)
+		return nil, err
+	}
+
+	snapshot.FinishedAt = time.Now()
+	if err := writeSnapshotManifest(workDir, snapshot); err != nil {
+		os.RemoveAll(workDir)
+		return nil, err
+	}
+
+	if err := os.Rename(workDir, finalDir); err != nil {
+		os.RemoveAll(workDir)
+		return nil, fmt.Errorf("failed to finalize snapshot: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// writeSnapshotComponents writes every part of a snapshot into dir
+func writeSnapshotComponents(snapshot *SnapshotManifest, dir string, siteConfig []byte, meta *site.Metadata) error {
+	// Dump databases first so they are as close as possible to the files
+	for _, name := range snapshot.Databases {
+		file := filepath.Join("db", name+".sql.gz")
+		if err := os.MkdirAll(filepath.Join(dir, "db"), 0755); err != nil {
+			return fmt.Errorf("failed to create snapshot directory: %v", err)
+		}
+		if err := dumpDatabase(name, filepath.Join(dir, file)); err != nil {
+			return fmt.Errorf("failed to dump database %s: %v", name, err)
+		}
+		if err := addComponent(snapshot, dir, "database:"+name, file); err != nil {
+			return err
+		}
+	}
+
+	if err := tarDirectory(filepath.Join(dir, snapshotFilesFile), snapshot.SiteDir); err != nil {
+		return fmt.Errorf("failed to archive site files: %v", err)
+	}
+	if err := addComponent(snapshot, dir, "files", snapshotFilesFile); err != nil {
+		return err
+	}
+
+	logDir := config.GetSiteLogDirectory(snapshot.Domain)
+	if _, err := os.Stat(logDir); err == nil {
+		if err := tarDirectory(filepath.Join(dir, snapshotLogsFile), logDir); err != nil {
+			return fmt.Errorf("failed to archive site logs: %v", err)
+		}
+		if err := addComponent(snapshot, dir, "logs", snapshotLogsFile); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, snapshotConfigFile), siteConfig, 0644); err != nil {
+		return fmt.Errorf("failed to store site configuration: %v", err)
+	}
+	if err := addComponent(snapshot, dir, "config", snapshotConfigFile); err != nil {
+		return err
+	}
+
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotMetaFile), append(metaData, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to store site metadata: %v", err)
+	}
+	return addComponent(snapshot, dir, "metadata", snapshotMetaFile)
+}
+
+// addComponent hashes a snapshot file and records it in the manifest
+func addComponent(snapshot *SnapshotManifest, dir, name, file string) error {
+	size, sum, err := hashFile(filepath.Join(dir, file))
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %v", file, err)
+	}
+
+	snapshot.Components = append(snapshot.Components, SnapshotComponent{
+		Name:   name,
+		File:   file,
+		Size:   size,
+		SHA256: sum,
+	})
+	return nil
+}
+
+// ListSnapshots returns the finished snapshots of a site, newest first
+func ListSnapshots(domain string) ([]*SnapshotManifest, error) {
+	entries, err := os.ReadDir(SnapshotDir(domain))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*SnapshotManifest{}, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []*SnapshotManifest
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), partialSuffix) {
+			continue
+		}
+		snapshot, err := LoadSnapshot(domain, entry.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// LoadSnapshot reads the manifest of a snapshot
+func LoadSnapshot(domain, id string) (*SnapshotManifest, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("invalid snapshot ID: %s", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(SnapshotDir(domain), id, snapshotManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("snapshot %s not found for %s", id, domain)
+		}
+		return nil, err
+	}
+
+	snapshot := &SnapshotManifest{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// RemoveSnapshot deletes a snapshot
+func RemoveSnapshot(domain, id string) error {
+	// A restore of the same snapshot reads it
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	snapshot, err := LoadSnapshot(domain, id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(snapshot.Path())
+}
+
+// RestoreSnapshot brings a site back to the state captured in a snapshot.
+// The current web root is moved into the site's snapshot directory as
+// pre-restore-<timestamp>.
+func RestoreSnapshot(domain, id string, opts RestoreOptions) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	snapshot, err := LoadSnapshot(domain, id)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range snapshot.Databases {
+		if err := config.ValidateDatabaseName(name); err != nil {
+			return fmt.Errorf("invalid snapshot manifest: %v", err)
+		}
+	}
+
+	dir := snapshot.Path()
+
+	// Refuse to restore anything from a damaged snapshot
+	for _, c := range snapshot.Components {
+		_, sum, err := hashFile(filepath.Join(dir, c.File))
+		if err != nil {
+			return fmt.Errorf("snapshot component %s is unreadable: %v", c.Name, err)
+		}
+		if sum != c.SHA256 {
+			return fmt.Errorf("snapshot component %s is corrupt: checksum mismatch", c.Name)
+		}
+	}
+
+	// Web root
+	siteDir := config.GetSiteDirectory(domain)
+	if _, err := os.Stat(siteDir); err == nil {
+		aside := filepath.Join(SnapshotDir(domain), "pre-restore-"+time.Now().Format("20060102-150405"))
+		if err := os.Rename(siteDir, aside); err != nil {
+			return fmt.Errorf("failed to move current site directory aside: %v", err)
+		}
+		fmt.Printf("Previous site directory moved to %s\n", aside)
+	}
+	if err := untar(filepath.Join(dir, snapshotFilesFile), filepath.Dir(siteDir), false); err != nil {
+		return fmt.Errorf("failed to restore site files: %v", err)
+	}
+
+	// Logs are history, never overwrite what is already there
+	if snapshot.hasComponent("logs") {
+		logDir := config.GetSiteLogDirectory(domain)
+		if err := os.MkdirAll(filepath.Dir(logDir), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+		if err := untar(filepath.Join(dir, snapshotLogsFile), filepath.Dir(logDir), true); err != nil {
+			return fmt.Errorf("failed to restore site logs: %v", err)
+		}
+	}
+
+	// Caddy site configuration and panel metadata
+	siteConfig, err := os.ReadFile(filepath.Join(dir, snapshotConfigFile))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot configuration: %v", err)
+	}
+	if err := fileutil.WriteFile(config.GetSiteConfigPath(domain), siteConfig, 0644); err != nil {
+		return fmt.Errorf("failed to restore site configuration: %v", err)
+	}
+
+	metaData, err := os.ReadFile(filepath.Join(dir, snapshotMetaFile))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot metadata: %v", err)
+	}
+	meta := &site.Metadata{}
+	if err := json.Unmarshal(metaData, meta); err != nil {
+		return fmt.Errorf("invalid snapshot metadata: %v", err)
+	}
+	meta.Domain = domain
+	if err := site.SaveMetadata(meta); err != nil {
+		return err
+	}
+
+	// Databases
+	if !opts.SkipDatabases {
+		for _, name := range snapshot.Databases {
+			if err := restoreDatabase(name, filepath.Join(dir, "db", name+".sql.gz")); err != nil {
+				return fmt.Errorf("failed to restore database %s: %v", name, err)
+			}
+		}
+	}
+
+	if err := caddy.Validate(); err != nil {
+		return fmt.Errorf("snapshot restored, but %v", err)
+	}
+	if err := caddy.Reload(); err != nil {
+		return fmt.Errorf("snapshot restored, but %v", err)
+	}
+
+	return nil
+}
+
+func (s *SnapshotManifest) hasComponent(name string) bool {
+	for _, c := range s.Components {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSnapshotManifest stores the snapshot manifest in dir
+func writeSnapshotManifest(dir string, snapshot *SnapshotManifest) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotManifestFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %v", err)
+	}
+	return nil
+}
+
+// restoreDatabase loads a gzip compressed dump into a database
+func restoreDatabase(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return database.Restore(name, gz)
+}
+
+// tarDirectory writes a tar.gz archive of dir to dest. Entries are stored
+// relative to the parent of dir.
+func tarDirectory(dest, dir string) error {
+	cmd := lowPriorityCommand("tar", "-czf", dest, "-C", filepath.Dir(dir), filepath.Base(dir))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// untar extracts a tar.gz archive into dir
+func untar(archive, dir string, keepExisting bool) error {
+	args := []string{"-xzf", archive, "-C", dir}
+	if keepExisting {
+		args = append(args, "--skip-old-files")
+	}
+	cmd := exec.Command("tar", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// hashFile returns the size and SHA-256 checksum of a file
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// importLines returns the import directives of a Caddy site configuration
+func importLines(siteConfig string) []string {
+	var imports []string
+	for _, line := range strings.Split(siteConfig, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "import ") {
+			imports = append(imports, line)
+		}
+	}
+	return imports
+}
+
+// mergeNames returns the sorted union of two name lists
+func mergeNames(a, b []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(append([]string{}, a...), b...) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
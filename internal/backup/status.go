@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"sort"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// staleGrace is added to the schedule interval before a backup counts as
+// stale, covering the randomized delay and the time the backup itself takes
+const staleGrace = 2 * time.Hour
+
+// TargetStatus summarizes the backups of one site or database
+type TargetStatus struct {
+	Kind        string
+	Target      string
+	Schedules   []string
+	LastSuccess *RunRecord
+	LastFailure *RunRecord // most recent failure newer than the last success
+	Stale       bool
+}
+
+// Age returns the time since the last successful backup
+func (s TargetStatus) Age(now time.Time) time.Duration {
+	if s.LastSuccess == nil {
+		return 0
+	}
+	return now.Sub(s.LastSuccess.FinishedAt)
+}
+
+// Status reports the backup state of every site and of every database that
+// is scheduled or has been backed up
+func Status(now time.Time) ([]TargetStatus, error) {
+	statuses := make(map[string]*TargetStatus)
+	get := func(kind, target string) *TargetStatus {
+		key := kind + "/" + target
+		if s, ok := statuses[key]; ok {
+			return s
+		}
+		s := &TargetStatus{Kind: kind, Target: target}
+		statuses[key] = s
+		return s
+	}
+
+	domains, err := site.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range domains {
+		get(KindSite, domain)
+	}
+
+	schedules, err := ListSchedules()
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range schedules {
+		s := get(schedule.Kind, schedule.Target)
+		s.Schedules = append(s.Schedules, schedule.Type)
+	}
+
+	history, err := LoadRunHistory()
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		record := &history[i]
+		s := get(record.Kind, record.Target)
+		if record.Succeeded() {
+			if s.LastSuccess == nil || record.FinishedAt.After(s.LastSuccess.FinishedAt) {
+				s.LastSuccess = record
+			}
+		} else if s.LastFailure == nil || record.FinishedAt.After(s.LastFailure.FinishedAt) {
+			s.LastFailure = record
+		}
+	}
+
+	var result []TargetStatus
+	for _, s := range statuses {
+		// Backups made before the run history existed still count
+		if s.LastSuccess == nil {
+			s.LastSuccess = newestManifestRecord(s.Kind, s.Target)
+		}
+		if s.LastFailure != nil && s.LastSuccess != nil && s.LastFailure.FinishedAt.Before(s.LastSuccess.FinishedAt) {
+			s.LastFailure = nil
+		}
+		if allowed, ok := allowedAge(s.Schedules); ok {
+			s.Stale = s.LastSuccess == nil || now.Sub(s.LastSuccess.FinishedAt) > allowed
+		}
+		sort.Strings(s.Schedules)
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind > result[j].Kind
+		}
+		return result[i].Target < result[j].Target
+	})
+
+	return result, nil
+}
+
+// allowedAge returns the maximum age of the last backup permitted by the
+// most frequent schedule. It returns false if nothing is scheduled.
+func allowedAge(schedules []string) (time.Duration, bool) {
+	if len(schedules) == 0 {
+		return 0, false
+	}
+
+	interval := 7 * 24 * time.Hour
+	for _, s := range schedules {
+		if s == DailyBackup {
+			interval = 24 * time.Hour
+		}
+	}
+
+	return interval + config.GetBackupConfig().RandomDelay + staleGrace, true
+}
+
+// newestManifestRecord builds a run record from the newest archive manifest
+// of a target
+func newestManifestRecord(kind, target string) *RunRecord {
+	var newest *RunRecord
+	for _, backupType := range []string{DailyBackup, WeeklyBackup} {
+		backups, err := ListBackups(target, backupType)
+		if err != nil {
+			continue
+		}
+		for _, b := range backups {
+			m := b.Manifest
+			if m == nil || m.Kind != kind {
+				continue
+			}
+			if newest == nil || m.FinishedAt.After(newest.FinishedAt) {
+				newest = &RunRecord{
+					Kind:       m.Kind,
+					Target:     m.Target,
+					Type:       m.Type,
+					StartedAt:  m.StartedAt,
+					FinishedAt: m.FinishedAt,
+					Archive:    m.Archive,
+					Size:       m.Size,
+				}
+			}
+		}
+	}
+	return newest
+}
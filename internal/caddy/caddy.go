@@ -0,0 +1,79 @@
+package caddy
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// CertificateDir is where the Caddy service stores the certificates it
+// obtained, one directory per issuer and domain
+const CertificateDir = "/var/lib/caddy/.local/share/caddy/certificates"
+
+// GetCaddyfilePath returns the path of the global Caddyfile that imports
+// all module and site configurations
+func GetCaddyfilePath() string {
+	return filepath.Join(config.GetConfigDir(), "global", "Caddyfile")
+}
+
+// Validate checks the global Caddyfile, including every imported site and
+// module configuration
+func Validate() error {
+	cmd := exec.Command("caddy", "validate", "--config", GetCaddyfilePath(), "--adapter", "caddyfile")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("caddy configuration is invalid: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Reload asks Caddy to reload its configuration through the unit named by
+// web_server.service_name
+func Reload() error {
+	cmd := exec.Command("systemctl", "reload", config.GetWebServerConfig().ServiceName)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to reload caddy: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CertificateExpiry returns when the newest certificate Caddy holds for a
+// domain expires
+func CertificateExpiry(domain string) (time.Time, error) {
+	matches, err := filepath.Glob(filepath.Join(CertificateDir, "*", domain, domain+".crt"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(matches) == 0 {
+		return time.Time{}, fmt.Errorf("no certificate found for %s", domain)
+	}
+
+	var expiry time.Time
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// The leaf certificate comes first, followed by the chain
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("invalid certificate: %s", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid certificate %s: %v", path, err)
+		}
+		if cert.NotAfter.After(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	return expiry, nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/doko/cli-webpanel/internal/agent"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the monitoring agent",
+	Long: `Run the monitoring agent in the foreground. Every monitoring.check_interval
+seconds it records the metrics history and evaluates the alert rules. The
+latest statistics are served on monitoring.agent_socket, where "status" and
+"monitor" read them. SIGHUP reloads the configuration, SIGTERM stops the
+agent.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return agent.New(reloadConfig).Run()
+	},
+}
+
+var agentInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Run the agent as a systemd service",
+	Long: `Write and enable the webpanel-agent systemd unit. The scheduled
+"metrics collect" job is removed, as the agent records the metrics itself.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to find the webpanel binary: %v", err)
+		}
+
+		command := []string{executable, "agent"}
+		if file := viper.ConfigFileUsed(); file != "" {
+			command = append(command, "--config", file)
+		}
+
+		if err := agent.Install(command); err != nil {
+			return fmt.Errorf("failed to install agent: %v", err)
+		}
+
+		backend := scheduler.Detect()
+		if backend.Exists(metricsJobName) {
+			if err := backend.Remove(metricsJobName); err != nil {
+				fmt.Printf("Warning: failed to remove the metrics collection job: %v\n", err)
+			} else {
+				fmt.Println("Removed the metrics collection job, the agent records metrics now")
+			}
+		}
+
+		fmt.Printf("Agent installed and started as %s.service\n", agent.ServiceName)
+		return nil
+	},
+}
+
+var agentUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop the agent service and remove its unit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := agent.Uninstall(); err != nil {
+			return fmt.Errorf("failed to uninstall agent: %v", err)
+		}
+		fmt.Println("Agent uninstalled")
+		return nil
+	},
+}
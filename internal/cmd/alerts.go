@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/alert"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Manage alert rules",
+	Long: `Evaluate the alert rules of the configuration and notify through the
+configured channels when alerts fire and resolve. Rule examples:
+
+  disk.usage_perc > 90 for 10m
+  service caddy != active
+  site example.com 5xx_rate > 5%
+  backup age > 36h`,
+}
+
+var alertsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List alert rules and active alerts",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rules, err := alert.LoadRules(config.GetAlertsConfig())
+		if err != nil {
+			return err
+		}
+		state, err := alert.LoadState()
+		if err != nil {
+			return fmt.Errorf("failed to read alert state: %v", err)
+		}
+
+		if len(rules) == 0 {
+			fmt.Println("No alert rules configured")
+			return nil
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RULE\tSEVERITY\tEXPRESSION\tSTATE")
+		for _, rule := range rules {
+			status := "ok"
+			firing, pending := 0, 0
+			for _, a := range state.Alerts {
+				if a.Rule != rule.Name {
+					continue
+				}
+				if a.State == alert.StateFiring {
+					firing++
+				} else {
+					pending++
+				}
+			}
+			switch {
+			case firing > 0:
+				status = fmt.Sprintf("\033[31mfiring (%d)\033[0m", firing)
+			case pending > 0:
+				status = fmt.Sprintf("\033[33mpending (%d)\033[0m", pending)
+			}
+			for _, silence := range state.Silences {
+				if silence.Rule == rule.Name && now.Before(silence.Until) {
+					status += fmt.Sprintf(", silenced until %s", silence.Until.Format("2006-01-02 15:04"))
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rule.Name, rule.Severity, rule.Expr, status)
+		}
+		w.Flush()
+
+		alerts := state.Sorted()
+		if len(alerts) == 0 {
+			return nil
+		}
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RULE\tINSTANCE\tVALUE\tACTIVE SINCE\tSTATE")
+		for _, a := range alerts {
+			instance := a.Instance
+			if instance == "" {
+				instance = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Rule, instance, a.Value,
+				a.ActiveSince.Format("2006-01-02 15:04:05"), a.State)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var alertsTestCmd = &cobra.Command{
+	Use:   "test [rule...]",
+	Short: "Evaluate alert rules without notifying",
+	Long: `Evaluate the alert rules once and print the result of every instance. The
+alert state is left unchanged. With --notify, a test message is sent through
+every configured channel instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sendTest, _ := cmd.Flags().GetBool("notify"); sendTest {
+			notifiers := notify.FromConfig(config.GetNotificationConfig())
+			if len(notifiers) == 0 {
+				return fmt.Errorf("no notification channel is enabled")
+			}
+			msg := notify.NewMessage("alert_test", "Test notification",
+				"This is a test notification of the webpanel alerts.")
+			if err := notify.SendAll(notifiers, msg); err != nil {
+				return err
+			}
+			for _, n := range notifiers {
+				fmt.Printf("Test notification sent through %s\n", n.Name())
+			}
+			return nil
+		}
+
+		engine, err := alert.NewEngine()
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			selected := make(map[string]bool)
+			for _, name := range args {
+				selected[name] = true
+			}
+			var rules []*alert.Rule
+			for _, rule := range engine.Rules {
+				if selected[rule.Name] {
+					rules = append(rules, rule)
+					delete(selected, rule.Name)
+				}
+			}
+			for name := range selected {
+				return fmt.Errorf("unknown alert rule: %s", name)
+			}
+			engine.Rules = rules
+		}
+
+		in, err := engine.Gather()
+		if err != nil {
+			return fmt.Errorf("failed to get system stats: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RULE\tINSTANCE\tVALUE\tRESULT")
+		for _, rule := range engine.Rules {
+			results := rule.Evaluate(in)
+			if len(results) == 0 {
+				fmt.Fprintf(w, "%s\t-\t-\tno data\n", rule.Name)
+			}
+			for _, result := range results {
+				instance := result.Instance
+				if instance == "" {
+					instance = "-"
+				}
+				outcome := "\033[32mok\033[0m"
+				if result.Active {
+					outcome = "\033[31mactive\033[0m"
+					if rule.For > 0 {
+						outcome += fmt.Sprintf(" (fires after %s)", rule.For)
+					}
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rule.Name, instance, result.Value, outcome)
+			}
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var alertsSilenceCmd = &cobra.Command{
+	Use:   "silence [rule]",
+	Short: "Suppress the notifications of a rule",
+	Long: `Suppress the notifications of a rule for a while. The rule is still evaluated
+and shown by "alerts list". Use --clear to end a silence early.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		duration, _ := cmd.Flags().GetDuration("for")
+		comment, _ := cmd.Flags().GetString("comment")
+		clear, _ := cmd.Flags().GetBool("clear")
+
+		rules, err := alert.LoadRules(config.GetAlertsConfig())
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, rule := range rules {
+			found = found || rule.Name == name
+		}
+		if !found {
+			return fmt.Errorf("unknown alert rule: %s", name)
+		}
+
+		if !clear && duration <= 0 {
+			return fmt.Errorf("--for must be a positive duration")
+		}
+
+		unlock, err := alert.LockState()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		state, err := alert.LoadState()
+		if err != nil {
+			return fmt.Errorf("failed to read alert state: %v", err)
+		}
+
+		now := time.Now()
+		var until time.Time
+		if !clear {
+			until = now.Add(duration)
+		}
+		state.Silence(name, until, comment, now)
+		if err := state.Save(); err != nil {
+			return fmt.Errorf("failed to save alert state: %v", err)
+		}
+
+		if clear {
+			fmt.Printf("Silence of %s removed\n", name)
+		} else {
+			fmt.Printf("Alert %s silenced until %s\n", name, until.Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+var alertsRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Evaluate alert rules continuously",
+	Long: `Evaluate the alert rules every monitoring.check_interval seconds and send
+notifications until stopped. With --once the rules are evaluated a single
+time, site request rates then cover a short sample only.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		once, _ := cmd.Flags().GetBool("once")
+
+		engine, err := alert.NewEngine()
+		if err != nil {
+			return err
+		}
+		if len(engine.Rules) == 0 {
+			return fmt.Errorf("no alert rules configured")
+		}
+
+		evaluate := func() error {
+			in, err := engine.Gather()
+			if err != nil {
+				return fmt.Errorf("failed to get system stats: %v", err)
+			}
+			return engine.Evaluate(in)
+		}
+
+		if once {
+			return evaluate()
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		ticker := time.NewTicker(metricsInterval())
+		defer ticker.Stop()
+
+		for {
+			if err := evaluate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			select {
+			case <-signals:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+func init() {
+	alertsTestCmd.Flags().Bool("notify", false, "Send a test message through every notification channel")
+	alertsSilenceCmd.Flags().Duration("for", 2*time.Hour, "How long to silence the rule")
+	alertsSilenceCmd.Flags().String("comment", "", "Reason for the silence")
+	alertsSilenceCmd.Flags().Bool("clear", false, "Remove the silence of the rule")
+	alertsRunCmd.Flags().Bool("once", false, "Evaluate the rules once and exit")
+}
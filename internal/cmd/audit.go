@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/audit"
+	"github.com/spf13/cobra"
+)
+
+// auditAnnotation marks the commands recorded in the audit log. Its value
+// lists the positions of arguments holding secrets, e.g. "1".
+const auditAnnotation = "audit"
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the audit log of panel operations",
+	Long: `Show the operations that changed the server, as recorded in webpanel.log in
+the log directory: who ran which command, the affected resources, the result
+and how long it took. Secrets are redacted from the recorded command lines.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter audit.Filter
+		filter.Resource, _ = cmd.Flags().GetString("resource")
+		filter.Command, _ = cmd.Flags().GetString("command")
+		filter.Failed, _ = cmd.Flags().GetBool("failed")
+		limit, _ := cmd.Flags().GetInt("tail")
+
+		if since, _ := cmd.Flags().GetString("since"); since != "" {
+			period, err := parseSince(since)
+			if err != nil {
+				return err
+			}
+			filter.Since = time.Now().Add(-period)
+		}
+
+		records, err := audit.Query(filter)
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %v", err)
+		}
+		if len(records) == 0 {
+			fmt.Println("No audit records found")
+			return nil
+		}
+		if limit > 0 && len(records) > limit {
+			records = records[len(records)-limit:]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tUSER\tCOMMAND\tRESOURCES\tDURATION\tRESULT")
+		for _, r := range records {
+			who := r.User
+			if r.SudoUser != "" {
+				who = fmt.Sprintf("%s (%s)", r.SudoUser, r.User)
+			}
+			resources := strings.Join(r.Resources, ", ")
+			if resources == "" {
+				resources = "-"
+			}
+			result := "\033[32m" + r.Result + "\033[0m"
+			if r.Result == audit.ResultError {
+				result = "\033[31merror: " + r.Error + "\033[0m"
+			}
+			duration := time.Duration(r.Duration * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Local().Format("2006-01-02 15:04:05"),
+				who, r.Command, resources, duration, result)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+// markAudited records the commands in the audit log when they run
+func markAudited(cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		if c.Annotations == nil {
+			c.Annotations = make(map[string]string)
+		}
+		c.Annotations[auditAnnotation] = ""
+	}
+}
+
+// recordAudit writes the audit record of a command that ran, when it is
+// marked as audited, and returns it
+func recordAudit(cmd *cobra.Command, start time.Time, runErr error) *audit.Record {
+	if cmd == nil {
+		return nil
+	}
+	secretPositions, ok := cmd.Annotations[auditAnnotation]
+	if !ok {
+		return nil
+	}
+	if help, _ := cmd.Flags().GetBool("help"); help {
+		return nil
+	}
+
+	secret := make(map[int]bool)
+	for _, field := range strings.Split(secretPositions, ",") {
+		if n, err := strconv.Atoi(field); err == nil {
+			secret[n] = true
+		}
+	}
+
+	var resources, secrets []string
+	for i, arg := range cmd.Flags().Args() {
+		if secret[i] {
+			secrets = append(secrets, arg)
+		} else {
+			resources = append(resources, arg)
+		}
+	}
+
+	command := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	record := audit.NewRecord(command, os.Args[1:], resources, secrets, start, runErr)
+	if err := audit.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+	}
+	return record
+}
+
+func init() {
+	auditCmd.Flags().String("resource", "", "Only show operations on this resource, e.g. a domain or database")
+	auditCmd.Flags().String("command", "", "Only show this command, e.g. \"site\" or \"site add\"")
+	auditCmd.Flags().String("since", "", "Only show operations of a recent period, e.g. 24h or 7d")
+	auditCmd.Flags().Bool("failed", false, "Only show failed operations")
+	auditCmd.Flags().IntP("tail", "n", 50, "Number of records to show, 0 for all")
+}
@@ -2,10 +2,16 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/doko/cli-webpanel/internal/backup"
 	"github.com/doko/cli-webpanel/internal/config"
-	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/site"
 	"github.com/spf13/cobra"
 )
 
@@ -97,7 +103,7 @@ var backupListCmd = &cobra.Command{
 			return err
 		}
 
-		backups, err := backup.ListSiteBackups(domain, backupType)
+		backups, err := backup.ListBackups(domain, backupType)
 		if err != nil {
 			return fmt.Errorf("failed to list backups: %v", err)
 		}
@@ -108,14 +114,539 @@ var backupListCmd = &cobra.Command{
 		}
 
 		fmt.Printf("%s backups for %s:\n", backupType, domain)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tSIZE\tDURATION\tFILES\tVERIFIED")
 		for _, b := range backups {
-			fmt.Printf("- %s\n", b)
+			if b.Manifest == nil {
+				fmt.Fprintf(w, "  %s\t-\t-\t-\tincomplete (no manifest)\n", b.Archive.Name)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\n",
+				b.Archive.Name,
+				monitoring.FormatBytes(uint64(b.Manifest.Size)),
+				b.Manifest.Duration().Round(time.Second),
+				b.Manifest.FileCount,
+				formatVerification(b.Manifest.Verified))
 		}
+		w.Flush()
 
 		return nil
 	},
 }
 
+var backupLsCmd = &cobra.Command{
+	Use:   "ls [domain] [backup-id] [path]",
+	Short: "List the contents of a site backup",
+	Long: `List the files in a site backup. The backup ID is an archive name as shown by
+'backup list' (e.g. 2025-03-09 or weekly/2025-03-03-full) or a snapshot ID.
+Paths are relative to the site directory.`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		id := args[1]
+		dir := ""
+		if len(args) == 3 {
+			dir = args[2]
+		}
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		recursive, _ := cmd.Flags().GetBool("recursive")
+
+		archivePath, err := backup.ResolveSiteArchive(domain, id)
+		if err != nil {
+			return err
+		}
+
+		entries, err := backup.ListArchiveEntries(archivePath, dir, recursive)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range entries {
+			name := e.Path
+			if e.IsDir() {
+				name += "/"
+			} else if e.Linkname != "" {
+				name += " -> " + e.Linkname
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				e.Mode,
+				monitoring.FormatBytes(uint64(e.Size)),
+				e.ModTime.Format("2006-01-02 15:04"),
+				name)
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+var backupExtractCmd = &cobra.Command{
+	Use:   "extract [domain] [backup-id] [path]",
+	Short: "Extract files from a site backup",
+	Long: `Extract a single file or directory tree from a site backup into the directory
+given with --to. The path is relative to the site directory and is kept below
+the destination, so nothing in the live site is overwritten.`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		id := args[1]
+		target := args[2]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		dest, _ := cmd.Flags().GetString("to")
+
+		archivePath, err := backup.ResolveSiteArchive(domain, id)
+		if err != nil {
+			return err
+		}
+
+		count, err := backup.ExtractFromArchive(archivePath, target, dest)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Extracted %d entries from %s to %s\n", count, id, dest)
+		return nil
+	},
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify [domain|dbname]",
+	Short: "Verify backup archives",
+	Long: `Re-hash backup archives, check that they decompress and compare them with
+their manifests. Use --all to verify the backups of every site and database.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if all == (len(args) == 1) {
+			return fmt.Errorf("specify either a domain or --all")
+		}
+		if len(args) == 1 {
+			if err := validateBackupTarget(args[0]); err != nil {
+				return err
+			}
+		}
+
+		failed := 0
+		checked := 0
+		for _, backupType := range []string{backup.DailyBackup, backup.WeeklyBackup} {
+			targets := args
+			if all {
+				var err error
+				if targets, err = backup.ListBackupTargets(backupType); err != nil {
+					return fmt.Errorf("failed to list backup directories: %v", err)
+				}
+			}
+
+			for _, target := range targets {
+				results, err := backup.VerifyBackups(target, backupType)
+				if err != nil {
+					return fmt.Errorf("failed to verify %s backups for %s: %v", backupType, target, err)
+				}
+
+				for _, r := range results {
+					checked++
+					if r.Status == backup.StatusOK {
+						fmt.Printf("OK          %s\n", r.Path)
+						continue
+					}
+					failed++
+					fmt.Printf("%-11s %s: %s\n", strings.ToUpper(r.Status), r.Path, r.Problem)
+				}
+			}
+		}
+
+		if checked == 0 {
+			fmt.Println("No backups found")
+			return nil
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d backup(s) failed verification", failed, checked)
+		}
+
+		fmt.Printf("All %d backup(s) verified successfully\n", checked)
+		return nil
+	},
+}
+
+var backupRunCmd = &cobra.Command{
+	Use:   "run [daily|weekly] [domain|all]",
+	Short: "Run a backup now",
+	Long: `Back up a website immediately. Scheduled backups call this command.
+Use 'all' to back up every website.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupType := args[0]
+		target := args[1]
+
+		if backupType != "daily" && backupType != "weekly" {
+			return fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", backupType)
+		}
+
+		domains := []string{target}
+		if target == "all" {
+			var err error
+			if domains, err = site.List(); err != nil {
+				return fmt.Errorf("failed to list websites: %v", err)
+			}
+		} else if err := config.ValidateSiteName(target); err != nil {
+			return err
+		}
+
+		// Each backup also waits for a free slot, so scheduled jobs running
+		// at the same time count towards the limit
+		workers := config.GetBackupConfig().Concurrency
+		if workers < 1 {
+			workers = 1
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		queue := make(chan string)
+		failed := 0
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for domain := range queue {
+					err := backup.RunSiteBackup(domain, backupType)
+
+					mu.Lock()
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to back up %s: %v\n", domain, err)
+						failed++
+					} else {
+						fmt.Printf("Successfully created %s backup for %s\n", backupType, domain)
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, domain := range domains {
+			queue <- domain
+		}
+		close(queue)
+		wg.Wait()
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d backup(s) failed", failed, len(domains))
+		}
+		return nil
+	},
+}
+
+var backupStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show backup status",
+	Long: `Show the last successful backup of every website and database, its age,
+size and duration, and the last error. Targets whose last backup is older
+than their schedule allows are flagged as stale.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		now := time.Now()
+		statuses, err := backup.Status(now)
+		if err != nil {
+			return fmt.Errorf("failed to get backup status: %v", err)
+		}
+
+		if len(statuses) == 0 {
+			fmt.Println("No websites or databases found")
+			return nil
+		}
+
+		stale := 0
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tTARGET\tSCHEDULE\tLAST SUCCESS\tAGE\tSIZE\tDURATION\tSTATE\tLAST ERROR")
+		for _, s := range statuses {
+			schedule := "-"
+			if len(s.Schedules) > 0 {
+				schedule = strings.Join(s.Schedules, ",")
+			}
+
+			lastSuccess, age, size, duration := "never", "-", "-", "-"
+			if s.LastSuccess != nil {
+				lastSuccess = s.LastSuccess.FinishedAt.Format("2006-01-02 15:04")
+				age = monitoring.FormatUptime(s.Age(now))
+				size = monitoring.FormatBytes(uint64(s.LastSuccess.Size))
+				duration = s.LastSuccess.Duration().Round(time.Second).String()
+			}
+
+			lastError := "-"
+			if s.LastFailure != nil {
+				lastError = fmt.Sprintf("%s: %s", s.LastFailure.FinishedAt.Format("2006-01-02 15:04"), s.LastFailure.Error)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				s.Kind, s.Target, schedule, lastSuccess, age, size, duration, formatBackupState(s), lastError)
+			if s.Stale {
+				stale++
+			}
+		}
+		w.Flush()
+
+		if stale > 0 {
+			fmt.Printf("\n%d target(s) have stale backups\n", stale)
+		}
+		return nil
+	},
+}
+
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage backup schedules",
+	Long:  `Inspect the scheduled site and database backups.`,
+}
+
+var backupScheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backup schedules",
+	Long:  `List every scheduled site and database backup with its next and last run time.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schedules, err := backup.ListSchedules()
+		if err != nil {
+			return fmt.Errorf("failed to list backup schedules: %v", err)
+		}
+
+		if len(schedules) == 0 {
+			fmt.Println("No backups scheduled")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tTYPE\tTARGET\tNEXT RUN\tLAST RUN\tRESULT")
+		for _, s := range schedules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				s.Kind, s.Type, s.Target, s.Status.NextRun, s.Status.LastRun, s.Status.Result)
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune [domain|dbname]",
+	Short: "Remove old backups",
+	Long: `Apply the configured retention policy to backups. Only archives following
+the panel's naming scheme are considered. If no target is given, the backups
+of every site and database are pruned.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupType, _ := cmd.Flags().GetString("type")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if len(args) == 1 {
+			if err := validateBackupTarget(args[0]); err != nil {
+				return err
+			}
+		}
+
+		var backupTypes []string
+		switch backupType {
+		case "":
+			backupTypes = []string{backup.DailyBackup, backup.WeeklyBackup}
+		case backup.DailyBackup, backup.WeeklyBackup:
+			backupTypes = []string{backupType}
+		default:
+			return fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", backupType)
+		}
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+
+		total := 0
+		for _, t := range backupTypes {
+			targets := args
+			if len(targets) == 0 {
+				var err error
+				if targets, err = backup.ListBackupTargets(t); err != nil {
+					return fmt.Errorf("failed to list backup directories: %v", err)
+				}
+			}
+
+			for _, target := range targets {
+				pruned, err := backup.PruneBackups(backup.TargetDir(t, target), t, dryRun)
+				if err != nil {
+					return fmt.Errorf("failed to prune %s backups for %s: %v", t, target, err)
+				}
+				for _, archive := range pruned {
+					fmt.Printf("%s %s\n", verb, archive.Path)
+				}
+				total += len(pruned)
+			}
+		}
+
+		if total == 0 {
+			fmt.Println("No backups to prune")
+			return nil
+		}
+
+		fmt.Printf("%s %d backup(s)\n", verb, total)
+		return nil
+	},
+}
+
+var backupSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage site snapshots",
+	Long: `A site snapshot bundles the web root, the Caddy site configuration, panel
+metadata, logs and a consistent dump of every linked database under one ID.`,
+}
+
+var backupSnapshotCreateCmd = &cobra.Command{
+	Use:   "create [domain]",
+	Short: "Take a site snapshot",
+	Long: `Take a snapshot of a website. Databases linked with 'site link-db' are
+dumped automatically; use --db to include additional databases.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		databases, _ := cmd.Flags().GetStringSlice("db")
+		for _, name := range databases {
+			if err := config.ValidateDatabaseName(name); err != nil {
+				return err
+			}
+		}
+
+		snapshot, err := backup.CreateSnapshot(domain, databases)
+		if err != nil {
+			return fmt.Errorf("failed to create snapshot: %v", err)
+		}
+
+		fmt.Printf("Successfully created snapshot %s for %s\n", snapshot.ID, domain)
+		fmt.Printf("Location: %s\n", snapshot.Path())
+		fmt.Printf("Size: %s\n", monitoring.FormatBytes(uint64(snapshot.Size())))
+		if len(snapshot.Databases) > 0 {
+			fmt.Printf("Databases: %s\n", strings.Join(snapshot.Databases, ", "))
+		}
+		return nil
+	},
+}
+
+var backupSnapshotListCmd = &cobra.Command{
+	Use:   "list [domain]",
+	Short: "List site snapshots",
+	Long:  `List all snapshots of a website.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		snapshots, err := backup.ListSnapshots(domain)
+		if err != nil {
+			return fmt.Errorf("failed to list snapshots: %v", err)
+		}
+
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots found for %s\n", domain)
+			return nil
+		}
+
+		fmt.Printf("Snapshots for %s:\n", domain)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  ID\tCREATED\tSIZE\tDURATION\tDATABASES")
+		for _, s := range snapshots {
+			databases := "-"
+			if len(s.Databases) > 0 {
+				databases = strings.Join(s.Databases, ",")
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n",
+				s.ID,
+				s.CreatedAt.Format("2006-01-02 15:04"),
+				monitoring.FormatBytes(uint64(s.Size())),
+				s.FinishedAt.Sub(s.CreatedAt).Round(time.Second),
+				databases)
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+var backupSnapshotRestoreCmd = &cobra.Command{
+	Use:   "restore [domain] [snapshot-id]",
+	Short: "Restore a site snapshot",
+	Long: `Restore the web root, Caddy site configuration, metadata and linked databases
+of a website from a snapshot. The current web root is kept in the snapshot
+directory as pre-restore-<timestamp>.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		id := args[1]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		skipDB, _ := cmd.Flags().GetBool("skip-db")
+
+		if _, err := backup.LoadSnapshot(domain, id); err != nil {
+			return err
+		}
+
+		// Prompt for confirmation
+		fmt.Printf("Are you sure you want to restore %s from snapshot %s? Linked databases will be overwritten. [y/N]: ", domain, id)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Operation cancelled")
+			return nil
+		}
+
+		if err := backup.RestoreSnapshot(domain, id, backup.RestoreOptions{SkipDatabases: skipDB}); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully restored %s from snapshot %s\n", domain, id)
+		return nil
+	},
+}
+
+var backupSnapshotRmCmd = &cobra.Command{
+	Use:   "rm [domain] [snapshot-id]",
+	Short: "Remove a site snapshot",
+	Long:  `Delete a snapshot of a website.`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		id := args[1]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		if err := backup.RemoveSnapshot(domain, id); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully removed snapshot %s for %s\n", id, domain)
+		return nil
+	},
+}
+
 var dbbackupCmd = &cobra.Command{
 	Use:   "dbbackup",
 	Short: "Manage database backups",
@@ -134,8 +665,16 @@ var dbbackupEnableCmd = &cobra.Command{
 		if backupType != "daily" && backupType != "weekly" {
 			return fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", backupType)
 		}
+		if err := config.ValidateDatabaseName(dbname); err != nil {
+			return err
+		}
 
-		if err := database.BackupDatabase(dbname, backupType); err != nil {
+		// Check if backup is already enabled
+		if backup.IsDatabaseBackupEnabled(dbname, backupType) {
+			return fmt.Errorf("%s backup is already enabled for database %s", backupType, dbname)
+		}
+
+		if err := backup.EnableDatabaseBackup(dbname, backupType); err != nil {
 			return fmt.Errorf("failed to enable backup: %v", err)
 		}
 
@@ -156,20 +695,97 @@ var dbbackupDisableCmd = &cobra.Command{
 		if backupType != "daily" && backupType != "weekly" {
 			return fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", backupType)
 		}
+		if err := config.ValidateDatabaseName(dbname); err != nil {
+			return err
+		}
+
+		// Check if backup is enabled
+		if !backup.IsDatabaseBackupEnabled(dbname, backupType) {
+			return fmt.Errorf("%s backup is not enabled for database %s", backupType, dbname)
+		}
+
+		if err := backup.DisableDatabaseBackup(dbname, backupType); err != nil {
+			return fmt.Errorf("failed to disable backup: %v", err)
+		}
 
-		// TODO: Implement database backup disable functionality
 		fmt.Printf("Successfully disabled %s backup for database %s\n", backupType, dbname)
 		return nil
 	},
 }
 
+var dbbackupRunCmd = &cobra.Command{
+	Use:   "run [daily|weekly] [dbname]",
+	Short: "Run a database backup now",
+	Long:  `Back up a database immediately. Scheduled database backups call this command.`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupType := args[0]
+		dbname := args[1]
+
+		if backupType != "daily" && backupType != "weekly" {
+			return fmt.Errorf("invalid backup type: %s (must be 'daily' or 'weekly')", backupType)
+		}
+		if err := config.ValidateDatabaseName(dbname); err != nil {
+			return err
+		}
+
+		if err := backup.RunDatabaseBackup(dbname, backupType); err != nil {
+			return fmt.Errorf("failed to back up database %s: %v", dbname, err)
+		}
+
+		fmt.Printf("Successfully created %s backup for database %s\n", backupType, dbname)
+		return nil
+	},
+}
+
+// formatBackupState renders the state column of backup status
+func formatBackupState(s backup.TargetStatus) string {
+	switch {
+	case s.Stale:
+		return "STALE"
+	case s.LastFailure != nil:
+		return "failing"
+	case len(s.Schedules) == 0:
+		return "unscheduled"
+	default:
+		return "ok"
+	}
+}
+
+// formatVerification renders the last verification result of a backup
+func formatVerification(v *backup.Verification) string {
+	if v == nil {
+		return "never"
+	}
+	if v.Status == backup.StatusOK {
+		return fmt.Sprintf("ok (%s)", v.CheckedAt.Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("%s (%s)", v.Status, v.CheckedAt.Format("2006-01-02 15:04"))
+}
+
+// validateBackupTarget checks a target naming either a site or a database,
+// before it becomes part of a backup path
+func validateBackupTarget(target string) error {
+	if config.ValidateSiteName(target) != nil && config.ValidateDatabaseName(target) != nil {
+		return fmt.Errorf("invalid site or database name: %s", target)
+	}
+	return nil
+}
+
 func init() {
-	rootCmd.AddCommand(backupCmd)
-	backupCmd.AddCommand(backupEnableCmd)
-	backupCmd.AddCommand(backupDisableCmd)
-	backupCmd.AddCommand(backupListCmd)
-
-	rootCmd.AddCommand(dbbackupCmd)
-	dbbackupCmd.AddCommand(dbbackupEnableCmd)
-	dbbackupCmd.AddCommand(dbbackupDisableCmd)
+	// Add flags for prune command
+	backupPruneCmd.Flags().String("type", "", "Only prune daily or weekly backups")
+	backupPruneCmd.Flags().Bool("dry-run", false, "Show what would be deleted without deleting anything")
+
+	// Add flags for ls and extract commands
+	backupLsCmd.Flags().BoolP("recursive", "r", false, "List the whole tree below the path")
+	backupExtractCmd.Flags().String("to", "", "Directory to extract into")
+	backupExtractCmd.MarkFlagRequired("to")
+
+	// Add flags for verify command
+	backupVerifyCmd.Flags().Bool("all", false, "Verify the backups of every site and database")
+
+	// Add flags for snapshot commands
+	backupSnapshotCreateCmd.Flags().StringSlice("db", nil, "Additional database to include (repeatable)")
+	backupSnapshotRestoreCmd.Flags().Bool("skip-db", false, "Do not restore databases")
 }
@@ -10,7 +10,63 @@ func RegisterCommands(root *cobra.Command) {
 	initMonitorCommands(root)
 	initModuleCommands(root)
 	initPHPCommands(root)
+	initServerCommands(root)
+	initServiceCommands(root)
 	initSiteCommands(root)
+	initStateCommands(root)
+	initAuditCommands(root)
+	initHistoryCommands(root)
+	initDoctorCommands(root)
+}
+
+// initDoctorCommands registers the self-diagnosis command. It is audited
+// when run with --fix only, see its PersistentPreRunE.
+func initDoctorCommands(root *cobra.Command) {
+	root.AddCommand(doctorCmd)
+}
+
+// initStateCommands registers the declarative state commands
+func initStateCommands(root *cobra.Command) {
+	root.AddCommand(planCmd)
+	root.AddCommand(applyCmd)
+	root.AddCommand(exportStateCmd)
+	markExclusive(applyCmd)
+}
+
+// initHistoryCommands registers the configuration history commands. The
+// audited commands record the files they change.
+func initHistoryCommands(root *cobra.Command) {
+	root.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	root.AddCommand(rollbackCmd)
+	markAudited(rollbackCmd)
+	markExclusive(rollbackCmd)
+}
+
+// initAuditCommands registers the audit command and marks the commands
+// that change the server for the audit log
+func initAuditCommands(root *cobra.Command) {
+	root.AddCommand(auditCmd)
+
+	markAudited(
+		siteAddCmd, siteRmCmd, siteLinkDBCmd, siteUnlinkDBCmd,
+		moduleAddCmd, moduleRmCmd,
+		dbCreateCmd, dbDeleteCmd, dbuserCreateCmd, dbuserDeleteCmd, dbgrantCmd,
+		backupEnableCmd, backupDisableCmd, backupRunCmd, backupPruneCmd, backupExtractCmd,
+		backupSnapshotCreateCmd, backupSnapshotRestoreCmd, backupSnapshotRmCmd,
+		dbbackupEnableCmd, dbbackupDisableCmd, dbbackupRunCmd,
+		phpInstallCmd, phpRemoveCmd, phpModuleInstallCmd, phpModuleRemoveCmd,
+		serverImportCmd,
+		serviceStartCmd, serviceStopCmd, serviceRestartCmd, serviceReloadCmd,
+		agentInstallCmd, agentUninstallCmd,
+		metricsEnableCmd, metricsDisableCmd,
+		alertsSilenceCmd,
+		logsRotateCmd, logsPruneCmd, logsSetupCmd,
+		applyCmd,
+	)
+
+	// The password of "dbuser create [username] [password]"
+	dbuserCreateCmd.Annotations[auditAnnotation] = "1"
 }
 
 // initBackupCommands registers all backup related commands
@@ -19,10 +75,27 @@ func initBackupCommands(root *cobra.Command) {
 	backupCmd.AddCommand(backupEnableCmd)
 	backupCmd.AddCommand(backupDisableCmd)
 	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupLsCmd)
+	backupCmd.AddCommand(backupExtractCmd)
+	backupCmd.AddCommand(backupRunCmd)
+	backupCmd.AddCommand(backupStatusCmd)
+	backupCmd.AddCommand(backupScheduleCmd)
+	backupScheduleCmd.AddCommand(backupScheduleListCmd)
+	backupCmd.AddCommand(backupPruneCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
+	backupCmd.AddCommand(backupSnapshotCmd)
+	backupSnapshotCmd.AddCommand(backupSnapshotCreateCmd)
+	backupSnapshotCmd.AddCommand(backupSnapshotListCmd)
+	backupSnapshotCmd.AddCommand(backupSnapshotRestoreCmd)
+	backupSnapshotCmd.AddCommand(backupSnapshotRmCmd)
 
 	root.AddCommand(dbbackupCmd)
 	dbbackupCmd.AddCommand(dbbackupEnableCmd)
 	dbbackupCmd.AddCommand(dbbackupDisableCmd)
+	dbbackupCmd.AddCommand(dbbackupRunCmd)
+
+	// Backups take hours on large sites, the backup slots limit them
+	markBrief(backupRunCmd, dbbackupRunCmd)
 }
 
 // initDatabaseCommands registers all database related commands
@@ -44,7 +117,29 @@ func initDatabaseCommands(root *cobra.Command) {
 func initMonitorCommands(root *cobra.Command) {
 	root.AddCommand(statusCmd)
 	root.AddCommand(logsCmd)
+	logsCmd.AddCommand(logsSiteCmd)
+	logsCmd.AddCommand(logsRotateCmd)
+	logsCmd.AddCommand(logsPruneCmd)
+	logsCmd.AddCommand(logsSetupCmd)
 	root.AddCommand(monitorCmd)
+	root.AddCommand(statsCmd)
+
+	root.AddCommand(exporterCmd)
+
+	root.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentInstallCmd)
+	agentCmd.AddCommand(agentUninstallCmd)
+
+	root.AddCommand(alertsCmd)
+	alertsCmd.AddCommand(alertsListCmd)
+	alertsCmd.AddCommand(alertsTestCmd)
+	alertsCmd.AddCommand(alertsSilenceCmd)
+	alertsCmd.AddCommand(alertsRunCmd)
+
+	root.AddCommand(metricsCmd)
+	metricsCmd.AddCommand(metricsCollectCmd)
+	metricsCmd.AddCommand(metricsEnableCmd)
+	metricsCmd.AddCommand(metricsDisableCmd)
 }
 
 // initModuleCommands registers all module related commands
@@ -67,10 +162,31 @@ func initPHPCommands(root *cobra.Command) {
 	phpCmd.AddCommand(phpModuleRemoveCmd)
 }
 
+// initServerCommands registers the server export and import commands
+func initServerCommands(root *cobra.Command) {
+	root.AddCommand(serverCmd)
+	serverCmd.AddCommand(serverExportCmd)
+	serverCmd.AddCommand(serverImportCmd)
+	markExclusive(serverImportCmd)
+}
+
+// initServiceCommands registers the service control commands
+func initServiceCommands(root *cobra.Command) {
+	root.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceListCmd)
+	serviceCmd.AddCommand(serviceStatusCmd)
+	serviceCmd.AddCommand(serviceStartCmd)
+	serviceCmd.AddCommand(serviceStopCmd)
+	serviceCmd.AddCommand(serviceRestartCmd)
+	serviceCmd.AddCommand(serviceReloadCmd)
+}
+
 // initSiteCommands registers all site related commands
 func initSiteCommands(root *cobra.Command) {
 	root.AddCommand(siteCmd)
 	siteCmd.AddCommand(siteAddCmd)
 	siteCmd.AddCommand(siteListCmd)
 	siteCmd.AddCommand(siteRmCmd)
+	siteCmd.AddCommand(siteLinkDBCmd)
+	siteCmd.AddCommand(siteUnlinkDBCmd)
 }
@@ -169,17 +169,3 @@ var dbgrantCmd = &cobra.Command{
 		return nil
 	},
 }
-
-func init() {
-	rootCmd.AddCommand(dbCmd)
-	dbCmd.AddCommand(dbListCmd)
-	dbCmd.AddCommand(dbCreateCmd)
-	dbCmd.AddCommand(dbDeleteCmd)
-
-	rootCmd.AddCommand(dbuserCmd)
-	dbuserCmd.AddCommand(dbuserListCmd)
-	dbuserCmd.AddCommand(dbuserCreateCmd)
-	dbuserCmd.AddCommand(dbuserDeleteCmd)
-
-	rootCmd.AddCommand(dbgrantCmd)
-}
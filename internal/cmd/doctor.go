@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/doko/cli-webpanel/internal/doctor"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the server and repair what is safe",
+	Long: `Check the panel directories and their ownership, that every site
+configuration has a web root and the reverse, module imports without a
+snippet, site log directories, the Caddy configuration, MariaDB and PHP-FPM
+sockets, backup schedules of removed sites and databases, the HTTP ports and
+the DNS records of the sites. Every problem comes with a hint.
+
+With --fix, the problems that are safe to repair are repaired: missing
+directories are created, ownership and permissions corrected, imports naming
+a module renamed to its snippet, missing module configurations written and
+stopped services started. The checks then run again.`,
+	// Only the repairs change the server, a plain diagnosis is neither
+	// audited nor waits for the lock. This replaces the hook of the root
+	// command, which it calls once the annotation is set.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if fix, _ := cmd.Flags().GetBool("fix"); fix {
+			markAudited(cmd)
+		}
+		return prepareRun(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+
+		errors, warnings, fixable := runChecks(fix)
+		if fix && fixable > 0 {
+			fmt.Println("\nChecking again after the repairs:")
+			errors, warnings, fixable = runChecks(false)
+		}
+
+		fmt.Printf("\n%d errors, %d warnings\n", errors, warnings)
+		if fixable > 0 && !fix {
+			fmt.Printf("%d can be repaired with \"webpanel doctor --fix\"\n", fixable)
+		}
+		if errors > 0 {
+			return fmt.Errorf("doctor found %d errors", errors)
+		}
+		return nil
+	},
+}
+
+// runChecks prints the findings of every check, repairing them with fix,
+// and returns the number of errors, warnings and repairable findings
+func runChecks(fix bool) (errors, warnings, fixable int) {
+	for _, check := range doctor.Checks() {
+		findings := check.Run()
+		if len(findings) == 0 {
+			fmt.Printf("\033[32m✓\033[0m %s\n", check.Name)
+			continue
+		}
+
+		for _, f := range findings {
+			if f.Severity == doctor.SeverityError {
+				errors++
+				fmt.Printf("\033[31m✗\033[0m %s: %s\n", check.Name, f.Message)
+			} else {
+				warnings++
+				fmt.Printf("\033[33m!\033[0m %s: %s\n", check.Name, f.Message)
+			}
+			fmt.Printf("    hint: %s\n", f.Hint)
+
+			if f.Fix == nil {
+				continue
+			}
+			fixable++
+			if !fix {
+				continue
+			}
+			if err := f.Fix(); err != nil {
+				fmt.Printf("    \033[31mrepair failed: %v\033[0m\n", err)
+			} else {
+				fmt.Printf("    \033[32mrepaired\033[0m\n")
+			}
+		}
+	}
+	return errors, warnings, fixable
+}
+
+func init() {
+	doctorCmd.Flags().Bool("fix", false, "Repair the problems that are safe to repair")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/exporter"
+	"github.com/spf13/cobra"
+)
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve metrics for Prometheus",
+	Long: `Serve system, service, site, database, backup and certificate metrics in the
+Prometheus text format on /metrics until stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listen, _ := cmd.Flags().GetString("listen")
+
+		server := &http.Server{
+			Addr:              listen,
+			Handler:           exporter.New().Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- server.ListenAndServe()
+		}()
+		fmt.Printf("Serving metrics on http://%s/metrics\n", listen)
+
+		select {
+		case err := <-errc:
+			return fmt.Errorf("failed to serve metrics: %v", err)
+		case <-signals:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	},
+}
+
+func init() {
+	exporterCmd.Flags().String("listen", exporter.DefaultListen, "Address to serve metrics on")
+}
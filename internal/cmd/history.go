@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/doko/cli-webpanel/internal/audit"
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/history"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List operations that changed the configuration",
+	Long: `List the operations that changed configuration files: site and module
+configurations, the global Caddyfile, site metadata, the units and crontab
+files of scheduled jobs and the logrotate configuration. Use "history show"
+to see the changes and "rollback" to undo them. Web root contents are not
+kept.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("tail")
+
+		entries, err := history.List()
+		if err != nil {
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No operations recorded")
+			return nil
+		}
+		if limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tUSER\tCOMMAND\tFILES\tRESULT")
+		for _, e := range entries {
+			result := "\033[32m" + e.Result + "\033[0m"
+			if e.Result == audit.ResultError {
+				result = "\033[31m" + e.Result + "\033[0m"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"),
+				e.User, strings.Join(e.Args, " "), len(e.Files), result)
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show [id]",
+	Short: "Show the changes of an operation",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseHistoryID(args[0])
+		if err != nil {
+			return err
+		}
+		e, err := history.Load(id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Operation %d: webpanel %s\n", e.ID, strings.Join(e.Args, " "))
+		fmt.Printf("Time:   %s\n", e.Time.Local().Format("2006-01-02 15:04:05"))
+		fmt.Printf("User:   %s\n", e.User)
+		fmt.Printf("Result: %s\n", e.Result)
+		for _, f := range e.Files {
+			fmt.Println()
+			fmt.Print(f.Diff())
+		}
+		return nil
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [id]",
+	Short: "Restore the configuration from before an operation",
+	Long: `Restore the configuration files changed by an operation, and by every later
+operation, to their state before it. The Caddy configuration is validated
+before Caddy is reloaded, an invalid result is reverted. The rollback is
+recorded in the history itself, so it can be rolled back as well.
+
+Web root contents, databases and installed packages are not restored.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseHistoryID(args[0])
+		if err != nil {
+			return err
+		}
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		entries, err := history.Since(id)
+		if err != nil {
+			return err
+		}
+		files := history.Previous(entries)
+
+		fmt.Println("Operations to undo:")
+		for i := len(entries) - 1; i >= 0; i-- {
+			fmt.Printf("  %d  webpanel %s\n", entries[i].ID, strings.Join(entries[i].Args, " "))
+		}
+		fmt.Println("Files to restore:")
+		for _, f := range files {
+			action := "restore"
+			if f.Before == nil {
+				action = "remove"
+			}
+			fmt.Printf("  %-7s %s\n", action, f.Path)
+		}
+		for _, path := range history.Modified(entries) {
+			fmt.Printf("\033[33mWarning: %s was changed outside the panel, the change will be lost\033[0m\n", path)
+		}
+
+		if !yes {
+			fmt.Print("Continue? [y/N]: ")
+			var response string
+			fmt.Scanln(&response)
+			if response != "y" && response != "Y" {
+				fmt.Println("Operation cancelled")
+				return nil
+			}
+		}
+
+		current := history.Current(files)
+		if err := history.Apply(files); err != nil {
+			return fmt.Errorf("failed to restore configuration: %v", err)
+		}
+
+		if err := caddy.Validate(); err != nil {
+			if rerr := history.Apply(current); rerr != nil {
+				return fmt.Errorf("%v\nfailed to revert the rollback: %v", err, rerr)
+			}
+			return fmt.Errorf("rollback reverted: %v", err)
+		}
+		if err := caddy.Reload(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Configuration restored to before operation %d\n", id)
+		return nil
+	},
+}
+
+func parseHistoryID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid history ID: %s", s)
+	}
+	return id, nil
+}
+
+// captureHistory makes fileutil keep the configuration files an audited
+// command changes as they were before, for its history entry
+func captureHistory(cmd *cobra.Command, args []string) {
+	if _, ok := cmd.Annotations[auditAnnotation]; ok {
+		fileutil.Journal(history.IsTracked)
+	}
+}
+
+// recordHistory stores the configuration files an audited command changed
+func recordHistory(record *audit.Record) {
+	if record == nil {
+		return
+	}
+	files := history.Changes(history.Journaled())
+	if len(files) == 0 {
+		return
+	}
+
+	e := &history.Entry{
+		Time:    record.Time,
+		User:    record.User,
+		Command: record.Command,
+		Args:    record.Args,
+		Result:  record.Result,
+		Files:   files,
+	}
+	if record.SudoUser != "" {
+		e.User = record.SudoUser
+	}
+	if err := history.Save(e); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record history: %v\n", err)
+	}
+}
+
+func init() {
+	historyCmd.Flags().IntP("tail", "n", 20, "Number of operations to show, 0 for all")
+	rollbackCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+}
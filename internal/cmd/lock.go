@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"github.com/doko/cli-webpanel/internal/lock"
+	"github.com/spf13/cobra"
+)
+
+// exclusiveAnnotation marks the audited commands that change the whole
+// server and run alone
+const exclusiveAnnotation = "lock-exclusive"
+
+// briefAnnotation marks the long-running audited commands, such as
+// scheduled backups, that release the global lock once they started
+// instead of holding off exclusive commands for hours
+const briefAnnotation = "lock-brief"
+
+// globalLock is the global lock held by the running command
+var globalLock *lock.Lock
+
+// markExclusive makes the commands wait for all other commands changing the
+// server, and those wait for them
+func markExclusive(cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		if c.Annotations == nil {
+			c.Annotations = make(map[string]string)
+		}
+		c.Annotations[exclusiveAnnotation] = ""
+	}
+}
+
+// markBrief makes the commands wait for exclusive commands to finish
+// before they start, but not the other way round
+func markBrief(cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		if c.Annotations == nil {
+			c.Annotations = make(map[string]string)
+		}
+		c.Annotations[briefAnnotation] = ""
+	}
+}
+
+// prepareRun takes the global lock for an audited command and starts
+// journaling the configuration files it changes for the history
+func prepareRun(cmd *cobra.Command, args []string) error {
+	if _, ok := cmd.Annotations[auditAnnotation]; !ok {
+		return nil
+	}
+	lock.Command = cmd.CommandPath()
+	_, exclusive := cmd.Annotations[exclusiveAnnotation]
+	l, err := lock.Global(exclusive)
+	if err != nil {
+		return err
+	}
+	globalLock = l
+
+	captureHistory(cmd, args)
+	if _, brief := cmd.Annotations[briefAnnotation]; brief {
+		releaseLock()
+	}
+	return nil
+}
+
+// releaseLock releases the global lock once the command is recorded
+func releaseLock() {
+	globalLock.Release()
+	globalLock = nil
+}
+
+func init() {
+	RootCmd.PersistentFlags().DurationVar(&lock.Timeout, "lock-timeout", lock.DefaultTimeout,
+		"How long to wait for a site, database or the panel locked by another run")
+}
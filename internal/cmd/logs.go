@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/logs"
+	"github.com/doko/cli-webpanel/internal/module"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/spf13/cobra"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [flags] [service]",
+	Short: "Show service logs",
+	Long: `Display logs for the specified service. Available services:
+- caddy: Web server logs
+- mariadb: Database server logs
+- webpanel: audit log of panel operations (see "webpanel audit")
+If no service is specified, shows the webpanel logs. Use "logs site" for the
+access and error logs of a site.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service := "webpanel"
+		if len(args) > 0 {
+			service = args[0]
+		}
+
+		var logPath string
+		switch service {
+		case "caddy":
+			logPath = "/var/log/caddy/access.log"
+		case "mariadb":
+			logPath = filepath.Join(config.DatabaseLogDir, "error.log")
+		case "webpanel":
+			logPath = filepath.Join(config.GetLogDir(), "webpanel.log")
+		default:
+			return fmt.Errorf("unknown service: %s", service)
+		}
+
+		lines, _ := cmd.Flags().GetInt("tail")
+		follow, _ := cmd.Flags().GetBool("follow")
+		return printLog(logPath, lines, follow, false, func(line string) (string, bool) {
+			return line, true
+		})
+	},
+}
+
+var logsSiteCmd = &cobra.Command{
+	Use:   "site [domain]",
+	Short: "Show the access or error log of a site",
+	Long: `Show the Caddy JSON access log of a site written by the access_log module, or
+its error log with --error, as readable lines. Filters select entries by
+status (404 or 5xx), client IP or network, path prefix, method and age. With
+--raw, the matching JSON lines are printed unchanged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+		errorLog, _ := cmd.Flags().GetBool("error")
+		lines, _ := cmd.Flags().GetInt("tail")
+		follow, _ := cmd.Flags().GetBool("follow")
+		raw, _ := cmd.Flags().GetBool("raw")
+
+		filter, err := logFilterFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		name, module := "access.log", "access_log"
+		if errorLog {
+			name, module = "error.log", "error_log"
+		}
+		logPath := filepath.Join(config.GetSiteLogDirectory(domain), name)
+		if _, err := os.Stat(logPath); os.IsNotExist(err) {
+			return fmt.Errorf("log file not found: %s (is the %s module enabled for %s?)", logPath, module, domain)
+		}
+
+		return printLog(logPath, lines, follow, !filter.Empty(), func(line string) (string, bool) {
+			entry, err := logs.ParseEntry(line)
+			if err != nil {
+				// Lines that are not JSON cannot match a filter
+				return line, filter.Empty()
+			}
+			if !filter.Match(entry) {
+				return "", false
+			}
+			if raw {
+				return line, true
+			}
+			return entry.Format(), true
+		})
+	},
+}
+
+var logsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the panel and MariaDB logs",
+	Long: `Rotate the logs in the log directory and the MariaDB logs that grew beyond
+logging.max_size, keeping logging.max_files rotated files, compressed with
+logging.compress_logs. MariaDB is asked to reopen its logs afterwards. Caddy
+rolls the site logs itself.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		cfg := config.GetLoggingConfig()
+		limit := int64(cfg.MaxSize) << 20
+
+		// rotate moves a log aside when it is due and returns the rotated file
+		rotate := func(path string) string {
+			info, err := os.Stat(path)
+			if err != nil || info.Size() == 0 || (!force && info.Size() < limit) {
+				return ""
+			}
+			rotated, err := logs.Rotate(path, cfg.MaxFiles)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to rotate %s: %v\n", path, err)
+				return ""
+			}
+			fmt.Printf("Rotated %s (%s)\n", path, monitoring.FormatBytes(uint64(info.Size())))
+			return rotated
+		}
+
+		var rotated []string
+		for _, path := range panelLogs() {
+			if r := rotate(path); r != "" {
+				rotated = append(rotated, r)
+			}
+		}
+
+		databaseRotated := false
+		for _, path := range databaseLogs() {
+			if r := rotate(path); r != "" {
+				rotated = append(rotated, r)
+				databaseRotated = true
+			}
+		}
+		if databaseRotated {
+			// MariaDB writes to the rotated files until it reopens its logs
+			output, err := exec.Command("mysqladmin", "--local", "flush-error-log", "flush-slow-log").CombinedOutput()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to reopen MariaDB logs: %v: %s\n", err, strings.TrimSpace(string(output)))
+			}
+		}
+
+		if len(rotated) == 0 {
+			fmt.Println("No log needs rotation")
+			return nil
+		}
+		if cfg.CompressLogs {
+			for _, path := range rotated {
+				if err := logs.Compress(path); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to compress %s: %v\n", path, err)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+var logsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old rotated logs",
+	Long: `Remove rotated panel and MariaDB logs beyond logging.max_files or older than
+logging.max_age_days, and the files Caddy rolled in the site log directories
+beyond the same limits. A limit of 0 removes nothing on its account.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		cfg := config.GetLoggingConfig()
+		maxAge := time.Duration(cfg.MaxAgeDays) * 24 * time.Hour
+		now := time.Now()
+
+		var removed []string
+		for _, path := range append(panelLogs(), databaseLogs()...) {
+			files, err := logs.Prune(path, cfg.MaxFiles, maxAge, now, dryRun)
+			removed = append(removed, files...)
+			if err != nil {
+				return fmt.Errorf("failed to prune rotated logs of %s: %v", path, err)
+			}
+		}
+
+		siteDirs, _ := filepath.Glob(filepath.Join(config.SiteLogRoot, "*"))
+		for _, dir := range siteDirs {
+			files, err := logs.PruneRolled(dir, cfg.MaxFiles, maxAge, now, dryRun)
+			removed = append(removed, files...)
+			if err != nil {
+				return fmt.Errorf("failed to prune rolled logs in %s: %v", dir, err)
+			}
+		}
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		for _, path := range removed {
+			fmt.Printf("%s %s\n", verb, path)
+		}
+		if len(removed) == 0 {
+			fmt.Println("No rotated logs to remove")
+		}
+		return nil
+	},
+}
+
+var logsSetupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Apply the logging settings to Caddy and logrotate",
+	Long: `Write the access_log and error_log module snippets with Caddy's roll_size,
+roll_keep and roll_keep_for from the logging settings and reload Caddy.
+Then write a logrotate configuration for the panel logs and, unless the
+MariaDB package ships its own, the MariaDB logs.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		noReload, _ := cmd.Flags().GetBool("no-reload")
+
+		if err := module.WriteLoggingModules(); err != nil {
+			return err
+		}
+		fmt.Printf("Logging modules written to %s\n",
+			filepath.Join(config.GetConfigDir(), "modules", module.LoggingModulesFile))
+
+		if !noReload {
+			if err := caddy.Validate(); err != nil {
+				return err
+			}
+			if err := caddy.Reload(); err != nil {
+				return err
+			}
+			fmt.Println("Caddy reloaded")
+		}
+
+		database := !logs.DatabaseLogrotateShipped()
+		content := logs.Logrotate(config.GetLoggingConfig(), database)
+		if err := fileutil.WriteFile(logs.LogrotatePath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write logrotate configuration: %v", err)
+		}
+		fmt.Printf("Logrotate configuration written to %s\n", logs.LogrotatePath)
+		if !database {
+			fmt.Println("MariaDB logs are rotated by the logrotate configuration of the MariaDB package")
+		}
+		return nil
+	},
+}
+
+// panelLogs returns the logs in the log directory
+func panelLogs() []string {
+	matches, _ := filepath.Glob(filepath.Join(config.GetLogDir(), "*.log"))
+	return matches
+}
+
+// databaseLogs returns the MariaDB error and slow query logs
+func databaseLogs() []string {
+	matches, _ := filepath.Glob(filepath.Join(config.DatabaseLogDir, "*.log"))
+	return matches
+}
+
+// logFilterFromFlags builds the entry filter of "logs site"
+func logFilterFromFlags(cmd *cobra.Command) (*logs.Filter, error) {
+	filter := &logs.Filter{}
+
+	if status, _ := cmd.Flags().GetString("status"); status != "" {
+		if err := filter.SetStatus(status); err != nil {
+			return nil, err
+		}
+	}
+	if ip, _ := cmd.Flags().GetString("ip"); ip != "" {
+		if err := filter.SetIP(ip); err != nil {
+			return nil, err
+		}
+	}
+	if since, _ := cmd.Flags().GetString("since"); since != "" {
+		period, err := parseSince(since)
+		if err != nil {
+			return nil, err
+		}
+		filter.Since = time.Now().Add(-period)
+	}
+	filter.PathPrefix, _ = cmd.Flags().GetString("path-prefix")
+	method, _ := cmd.Flags().GetString("method")
+	filter.Method = strings.ToUpper(method)
+	return filter, nil
+}
+
+// printLog prints the last lines of a log, then with follow the lines
+// appended until interrupted. render formats a line and reports whether it
+// is shown. A filtered log is read completely to find the last matching
+// lines.
+func printLog(path string, lines int, follow, filtered bool, render func(line string) (string, bool)) error {
+	var shown []string
+	var offset int64
+	var err error
+
+	if filtered {
+		offset, err = logs.Scan(path, func(line string) {
+			if out, ok := render(line); ok {
+				shown = append(shown, out)
+				if len(shown) > lines {
+					shown = shown[1:]
+				}
+			}
+		})
+	} else {
+		var tail []string
+		tail, offset, err = logs.Tail(path, lines)
+		for _, line := range tail {
+			if out, ok := render(line); ok {
+				shown = append(shown, out)
+			}
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("log file not found: %s", path)
+		}
+		return fmt.Errorf("failed to read logs: %v", err)
+	}
+
+	for _, line := range shown {
+		fmt.Println(line)
+	}
+	if !follow {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	err = logs.Follow(path, offset, stop, func(line string) {
+		if out, ok := render(line); ok {
+			fmt.Println(out)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to follow logs: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	logsCmd.PersistentFlags().IntP("tail", "n", 50, "Number of lines to show")
+	logsCmd.PersistentFlags().BoolP("follow", "f", false, "Follow log output")
+
+	logsSiteCmd.Flags().Bool("access", false, "Show the access log (default)")
+	logsSiteCmd.Flags().Bool("error", false, "Show the error log")
+	logsSiteCmd.MarkFlagsMutuallyExclusive("access", "error")
+	logsSiteCmd.Flags().String("status", "", "Only show responses with this status, e.g. 404 or 5xx")
+	logsSiteCmd.Flags().String("ip", "", "Only show requests from this client IP or network")
+	logsSiteCmd.Flags().String("path-prefix", "", "Only show requests whose path starts with this prefix")
+	logsSiteCmd.Flags().String("method", "", "Only show requests with this HTTP method")
+	logsSiteCmd.Flags().String("since", "", "Only show entries of a recent period, e.g. 1h or 2d")
+	logsSiteCmd.Flags().Bool("raw", false, "Print the JSON lines unchanged")
+
+	logsRotateCmd.Flags().Bool("force", false, "Rotate every non-empty log regardless of its size")
+	logsPruneCmd.Flags().Bool("dry-run", false, "Only list the files that would be removed")
+	logsSetupCmd.Flags().Bool("no-reload", false, "Do not validate and reload Caddy")
+}
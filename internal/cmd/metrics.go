@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/agent"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/spf13/cobra"
+)
+
+// metricsJobName is the scheduler job recording the metrics history
+const metricsJobName = "webpanel-metrics"
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Record the metrics history",
+	Long: `Record system metrics under the log directory so "status --since" can show
+trends. Samples are kept for monitoring.log_retention_days.`,
+}
+
+var metricsCollectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Record one sample of the system metrics",
+	Long: `Record one sample of the system metrics and remove expired history.
+With --loop a sample is recorded every monitoring.check_interval seconds
+until the process is stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		loop, _ := cmd.Flags().GetBool("loop")
+
+		cfg := config.GetMonitoringConfig()
+		store := monitoring.NewMetricsStore(config.GetMetricsDir(), cfg.LogRetentionDays)
+		collector := monitoring.NewCollector()
+		collector.Paths = config.GetManagedDirectories()
+		collector.Services = service.Monitored()
+
+		if !loop {
+			stats, err := collector.Collect()
+			if err != nil {
+				return fmt.Errorf("failed to get system stats: %v", err)
+			}
+			return recordMetrics(store, stats)
+		}
+
+		return collectMetricsLoop(store, collector, metricsInterval())
+	},
+}
+
+var metricsEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Record metrics on a schedule",
+	Long:  `Install a systemd timer or cron job running "metrics collect" every monitoring.check_interval seconds.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		executable, err := os.Executable()
+		if err != nil {
+			executable = "webpanel"
+		}
+
+		backend := scheduler.Detect()
+		err = backend.Install(scheduler.Job{
+			Name:        metricsJobName,
+			Description: "webpanel metrics history",
+			Command:     []string{executable, "metrics", "collect"},
+			Schedule:    scheduler.EverySchedule(metricsInterval()),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable metrics collection: %v", err)
+		}
+
+		fmt.Printf("Metrics are recorded every %s using %s\n", metricsInterval(), backend.Name())
+		return nil
+	},
+}
+
+var metricsDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop recording metrics on a schedule",
+	Long:  `Remove the scheduled "metrics collect" job. The recorded history is kept.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := scheduler.Detect().Remove(metricsJobName); err != nil {
+			return fmt.Errorf("failed to disable metrics collection: %v", err)
+		}
+		fmt.Println("Metrics collection disabled")
+		return nil
+	},
+}
+
+// metricsInterval returns the configured sampling interval
+func metricsInterval() time.Duration {
+	interval := time.Duration(config.GetMonitoringConfig().CheckInterval) * time.Second
+	if interval <= 0 {
+		return time.Minute
+	}
+	return interval
+}
+
+// recordMetrics stores a sample and removes expired history
+func recordMetrics(store *monitoring.MetricsStore, stats *monitoring.SystemStats) error {
+	now := time.Now()
+	if err := store.Append(monitoring.NewMetricSample(now, stats)); err != nil {
+		return fmt.Errorf("failed to record metrics: %v", err)
+	}
+	if _, err := store.Prune(now); err != nil {
+		return fmt.Errorf("failed to prune metrics history: %v", err)
+	}
+	return nil
+}
+
+// collectMetricsLoop records a sample every interval until SIGINT or
+// SIGTERM. Rates are averaged over the whole interval.
+func collectMetricsLoop(store *monitoring.MetricsStore, collector *monitoring.Collector, interval time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	prev, err := collector.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+		}
+
+		cur, err := collector.Snapshot()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		stats, err := collector.CollectBetween(prev, cur)
+		prev = cur
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if err := recordMetrics(store, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// parseSince parses a period like "24h", "90m" or "7d"
+func parseSince(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid period: %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid period: %s", value)
+	}
+	return period, nil
+}
+
+// printMetricsHistory prints the min, average, max and 95th percentile of
+// every metric recorded in the last period, optionally with charts
+func printMetricsHistory(period time.Duration, chart bool) error {
+	cfg := config.GetMonitoringConfig()
+	since := time.Now().Add(-period)
+
+	// A running agent serves the history, the files are read otherwise
+	samples, err := agent.NewClient(cfg.AgentSocket).History(since)
+	if err != nil {
+		store := monitoring.NewMetricsStore(config.GetMetricsDir(), cfg.LogRetentionDays)
+		if samples, err = store.Load(since); err != nil {
+			return fmt.Errorf("failed to read metrics history: %v", err)
+		}
+	}
+	if len(samples) == 0 {
+		fmt.Printf("No metrics recorded since %s. Run 'webpanel agent install' or 'webpanel metrics enable'.\n",
+			since.Format("2006-01-02 15:04"))
+		return nil
+	}
+
+	first := time.Unix(samples[0].Time, 0)
+	last := time.Unix(samples[len(samples)-1].Time, 0)
+	fmt.Printf("Metrics from %s to %s (%d samples):\n",
+		first.Format("2006-01-02 15:04"), last.Format("2006-01-02 15:04"), len(samples))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  METRIC\tMIN\tAVG\tMAX\tP95")
+	for _, metric := range monitoring.Metrics {
+		s := monitoring.Summarize(samples, metric)
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", metric.Label,
+			metric.Format(s.Min), metric.Format(s.Avg), metric.Format(s.Max), metric.Format(s.P95))
+	}
+	w.Flush()
+
+	if !chart {
+		return nil
+	}
+
+	const chartWidth, chartHeight = 60, 6
+	for _, metric := range monitoring.Metrics {
+		values := make([]float64, len(samples))
+		for i, s := range samples {
+			values[i] = metric.Value(s)
+		}
+
+		scale := metric.Scale
+		if scale == 0 {
+			scale = monitoring.Summarize(samples, metric).Max
+		}
+		rows := monitoring.Chart(values, chartWidth, chartHeight, scale)
+		if rows == nil {
+			continue
+		}
+
+		fmt.Printf("\n%s (top %s)\n", metric.Label, metric.Format(scale))
+		for _, row := range rows {
+			fmt.Printf("  |%s\n", row)
+		}
+		fmt.Printf("  +%s\n", strings.Repeat("-", len(rows[0])))
+		fmt.Printf("   %-*s%s\n", len(rows[0])-11, first.Format("01-02 15:04"), last.Format("01-02 15:04"))
+	}
+
+	return nil
+}
+
+func init() {
+	metricsCollectCmd.Flags().Bool("loop", false, "Keep recording every monitoring.check_interval seconds")
+}
@@ -137,11 +137,3 @@ var moduleRmCmd = &cobra.Command{
 		return nil
 	},
 }
-
-func init() {
-	RootCmd.AddCommand(moduleCmd)
-	moduleCmd.AddCommand(moduleListAvailableCmd)
-	moduleCmd.AddCommand(moduleListCmd)
-	moduleCmd.AddCommand(moduleAddCmd)
-	moduleCmd.AddCommand(moduleRmCmd)
-}
@@ -4,23 +4,47 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
+	"github.com/doko/cli-webpanel/internal/agent"
 	"github.com/doko/cli-webpanel/internal/config"
 	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/doko/cli-webpanel/internal/site"
 	"github.com/spf13/cobra"
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show system status",
-	Long:  `Display current system status including CPU, memory, disk usage, and service status.`,
+	Long: `Display current system status including CPU, memory, disk usage, and service status.
+With --since, summarize the recorded metrics history of that period instead.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		stats, err := monitoring.GetSystemStats()
-		if err != nil {
-			return fmt.Errorf("failed to get system stats: %v", err)
+		if since, _ := cmd.Flags().GetString("since"); since != "" {
+			period, err := parseSince(since)
+			if err != nil {
+				return err
+			}
+			chart, _ := cmd.Flags().GetBool("chart")
+			return printMetricsHistory(period, chart)
+		}
+
+		stats := agentStats()
+		if live, _ := cmd.Flags().GetBool("live"); live || stats == nil {
+			collector := monitoring.NewCollector()
+			collector.Paths = config.GetManagedDirectories()
+			collector.Services = service.Monitored()
+
+			var err error
+			if stats, err = collector.Collect(); err != nil {
+				return fmt.Errorf("failed to get system stats: %v", err)
+			}
 		}
 
 		// Create tabwriter for aligned output
@@ -28,22 +52,52 @@ var statusCmd = &cobra.Command{
 
 		// System Information
 		fmt.Fprintln(w, "System Information:")
-		fmt.Fprintf(w, "  CPU Usage:\t%.1f%%\n", stats.CPU)
+		fmt.Fprintf(w, "  CPU Usage:\t%.1f%% (%d cores)\n", stats.CPU, len(stats.Cores))
+		if len(stats.Cores) > 1 {
+			cores := make([]string, len(stats.Cores))
+			for i, usage := range stats.Cores {
+				cores[i] = fmt.Sprintf("%.0f%%", usage)
+			}
+			fmt.Fprintf(w, "  Per Core:\t%s\n", strings.Join(cores, " "))
+		}
+		fmt.Fprintf(w, "  Load Average:\t%.2f %.2f %.2f (%d/%d processes)\n",
+			stats.Load.Load1, stats.Load.Load5, stats.Load.Load15,
+			stats.Load.Running, stats.Load.Processes)
 		fmt.Fprintf(w, "  Memory Usage:\t%s / %s (%.1f%%)\n",
 			monitoring.FormatBytes(stats.Memory.Used),
 			monitoring.FormatBytes(stats.Memory.Total),
 			stats.Memory.UsagePerc)
+		fmt.Fprintf(w, "  Buffers/Cache:\t%s (%s available)\n",
+			monitoring.FormatBytes(stats.Memory.Buffers+stats.Memory.Cached),
+			monitoring.FormatBytes(stats.Memory.Available))
+		if stats.Memory.SwapTotal > 0 {
+			fmt.Fprintf(w, "  Swap Usage:\t%s / %s\n",
+				monitoring.FormatBytes(stats.Memory.SwapUsed),
+				monitoring.FormatBytes(stats.Memory.SwapTotal))
+		}
 		fmt.Fprintf(w, "  Disk Usage:\t%s / %s (%.1f%%)\n",
 			monitoring.FormatBytes(stats.Disk.Used),
 			monitoring.FormatBytes(stats.Disk.Total),
 			stats.Disk.UsagePerc)
 		fmt.Fprintf(w, "  Uptime:\t%s\n", monitoring.FormatUptime(stats.Uptime))
 		fmt.Fprintln(w)
+		w.Flush()
+
+		printMounts(stats.Mounts, config.GetMonitoringConfig().DiskThreshold)
+		printDiskIO(stats.DiskIO)
+		printNetwork(stats)
+
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 
 		// Service Status
 		fmt.Fprintln(w, "Service Status:")
-		for service, status := range stats.Services {
-			fmt.Fprintf(w, "  %s:\t%s\n", service, formatServiceStatus(status))
+		services := make([]string, 0, len(stats.Services))
+		for name := range stats.Services {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+		for _, name := range services {
+			fmt.Fprintf(w, "  %s:\t%s\n", name, formatServiceStatus(stats.Services[name]))
 		}
 
 		w.Flush()
@@ -51,106 +105,264 @@ var statusCmd = &cobra.Command{
 	},
 }
 
-var logsCmd = &cobra.Command{
-	Use:   "logs [flags] [service]",
-	Short: "Show service logs",
-	Long: `Display logs for the specified service. Available services:
-- caddy: Web server logs
-- mariadb: Database server logs
-- webpanel: CLI tool logs
-If no service is specified, shows the webpanel logs.`,
+var monitorCmd = &cobra.Command{
+	Use:   "monitor",
+	Short: "Monitor system in real-time",
+	Long: `Display a full-screen dashboard with CPU, memory, disk and network history,
+service states, the busiest processes grouped by caddy, PHP-FPM pool and
+mariadb, and request rates per site from the Caddy access logs.
+Press q or Ctrl-C to quit.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		service := "webpanel"
-		if len(args) > 0 {
-			service = args[0]
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			interval = time.Duration(config.GetMonitoringConfig().CheckInterval) * time.Second
 		}
-
-		var logPath string
-		switch service {
-		case "caddy":
-			logPath = "/var/log/caddy/access.log"
-		case "mariadb":
-			logPath = "/var/log/mysql/error.log"
-		case "webpanel":
-			logPath = filepath.Join(config.GetLogDir(), "webpanel.log")
-		default:
-			return fmt.Errorf("unknown service: %s", service)
+		if interval <= 0 {
+			interval = 2 * time.Second
 		}
 
-		// Check if log file exists
-		if _, err := os.Stat(logPath); os.IsNotExist(err) {
-			return fmt.Errorf("log file not found: %s", logPath)
+		collector := monitoring.NewCollector()
+		collector.Paths = config.GetManagedDirectories()
+		collector.Services = service.Monitored()
+
+		logs := make(map[string]string)
+		if domains, err := site.List(); err == nil {
+			for _, domain := range domains {
+				logs[domain] = filepath.Join(config.GetSiteLogDirectory(domain), "access.log")
+			}
 		}
 
-		// Get number of lines from flag
-		numLines, err := cmd.Flags().GetInt("tail")
+		dashboard, err := monitoring.NewDashboard(collector, monitoring.NewAccessLogWatcher(logs), interval)
 		if err != nil {
-			numLines = 50
+			return fmt.Errorf("failed to start monitoring: %v", err)
 		}
 
-		// Check if follow mode is enabled
-		follow, err := cmd.Flags().GetBool("follow")
-		if err != nil {
-			follow = false
+		// Start the sparklines with the history the agent recorded
+		client := agent.NewClient(config.GetMonitoringConfig().AgentSocket)
+		if samples, err := client.History(time.Now().Add(-time.Hour)); err == nil {
+			dashboard.Preload(samples)
 		}
 
-		// Prepare tail command
-		tailArgs := []string{"-n", fmt.Sprintf("%d", numLines)}
-		if follow {
-			tailArgs = append(tailArgs, "-f")
+		return runDashboard(dashboard, interval)
+	},
+}
+
+// agentStats returns the latest statistics of a running agent, or nil when
+// no agent answers or it has not collected for two intervals
+func agentStats() *monitoring.SystemStats {
+	resp, err := agent.NewClient(config.GetMonitoringConfig().AgentSocket).Status()
+	if err != nil || time.Since(resp.CollectedAt) > 2*resp.Interval {
+		return nil
+	}
+	fmt.Printf("Collected by the agent %s ago\n\n", time.Since(resp.CollectedAt).Round(time.Second))
+	return resp.Stats
+}
+
+// runDashboard redraws the dashboard until q, Ctrl-C or SIGTERM. The
+// terminal is switched to unbuffered input and the alternate screen, and
+// restored on exit.
+func runDashboard(dashboard *monitoring.Dashboard, interval time.Duration) error {
+	saved, err := stty("-g")
+	if err != nil {
+		return fmt.Errorf("monitor needs an interactive terminal")
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return fmt.Errorf("failed to configure terminal: %v", err)
+	}
+	fmt.Print("\033[?1049h\033[?25l")
+	defer func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		stty(saved)
+	}()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			} else if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
+	draw := func() {
+		rows, cols := terminalSize()
+		dashboard.Render(os.Stdout, cols, rows)
+	}
+	draw()
+
+	// The first update comes early so rates show up without waiting a
+	// whole interval
+	refresh := time.NewTimer(time.Second)
+	defer refresh.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || key == 'q' || key == 'Q' || key == 3 {
+				return lastErr
+			}
+		case sig := <-signals:
+			if sig != syscall.SIGWINCH {
+				return lastErr
+			}
+			draw()
+		case <-refresh.C:
+			lastErr = dashboard.Update()
+			draw()
+			refresh.Reset(interval)
 		}
-		tailArgs = append(tailArgs, logPath)
+	}
+}
+
+// stty runs stty on the terminal attached to stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// terminalSize returns the rows and columns of the terminal, falling back
+// to 24x80
+func terminalSize() (int, int) {
+	output, err := stty("size")
+	if err != nil {
+		return 24, 80
+	}
+	var rows, cols int
+	if _, err := fmt.Sscanf(output, "%d %d", &rows, &cols); err != nil || rows == 0 || cols == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
 
-		execCmd := exec.Command("tail", tailArgs...)
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
+// printMounts prints the usage of the file systems holding panel data.
+// Mounts above threshold percent, in bytes or inodes, are highlighted.
+func printMounts(mounts []monitoring.MountStats, threshold float64) {
+	if len(mounts) == 0 {
+		return
+	}
 
-		if err := execCmd.Run(); err != nil {
-			return fmt.Errorf("failed to read logs: %v", err)
+	fmt.Println("File Systems:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  MOUNT\tDEVICE\tSIZE\tUSED\tUSE%\tINODES\tPATHS\t")
+	for _, m := range mounts {
+		inodes := "-"
+		if m.Inodes > 0 {
+			inodes = fmt.Sprintf("%.1f%%", m.InodeUsagePerc)
 		}
 
-		return nil
-	},
+		warning := ""
+		if threshold > 0 && (m.UsagePerc >= threshold || m.InodeUsagePerc >= threshold) {
+			warning = fmt.Sprintf("\033[31mabove %.0f%%\033[0m", threshold)
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\t%s\n",
+			m.MountPoint,
+			m.Device,
+			monitoring.FormatBytes(m.Total),
+			monitoring.FormatBytes(m.Used),
+			m.UsagePerc,
+			inodes,
+			strings.Join(m.Paths, ","),
+			warning)
+	}
+	w.Flush()
+	fmt.Println()
 }
 
-var monitorCmd = &cobra.Command{
-	Use:   "monitor",
-	Short: "Monitor system in real-time",
-	Long:  `Display real-time system monitoring information with automatic updates.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		// Clear screen
-		fmt.Print("\033[H\033[2J")
+// printDiskIO prints the throughput and utilization of block devices
+func printDiskIO(devices []monitoring.DiskIOStats) {
+	if len(devices) == 0 {
+		return
+	}
 
-		// Run top command with custom format
-		execCmd := exec.Command("top", "-b", "-n", "1", "-w", "512")
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
+	fmt.Println("Disk I/O:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  DEVICE\tREAD/s\tWRITE/s\tREAD IOPS\tWRITE IOPS\tUTIL")
+	for _, d := range devices {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%.0f\t%.0f\t%.1f%%\n",
+			d.Device,
+			monitoring.FormatBytes(uint64(d.ReadBytesSec)),
+			monitoring.FormatBytes(uint64(d.WriteBytesSec)),
+			d.ReadsSec,
+			d.WritesSec,
+			d.UtilizationPct)
+	}
+	w.Flush()
+	fmt.Println()
+}
 
-		if err := execCmd.Run(); err != nil {
-			return fmt.Errorf("failed to run monitoring: %v", err)
+// printNetwork prints interface traffic and TCP connection counts
+func printNetwork(stats *monitoring.SystemStats) {
+	if len(stats.Network) > 0 {
+		fmt.Println("Network:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  INTERFACE\tRX/s\tTX/s\tRX\tTX\tRX PKTS\tTX PKTS\tERRORS\tDROPS")
+		for _, iface := range stats.Network {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%d\t%d\t%d/%d\t%d/%d\n",
+				iface.Name,
+				monitoring.FormatBytes(uint64(iface.RxBytesSec)),
+				monitoring.FormatBytes(uint64(iface.TxBytesSec)),
+				monitoring.FormatBytes(iface.RxBytes),
+				monitoring.FormatBytes(iface.TxBytes),
+				iface.RxPackets,
+				iface.TxPackets,
+				iface.RxErrors, iface.TxErrors,
+				iface.RxDropped, iface.TxDropped)
 		}
+		w.Flush()
+		fmt.Println()
+	}
 
-		return nil
-	},
+	fmt.Println("TCP Connections:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	states := make([]string, 0, len(stats.TCP.States))
+	for state := range stats.TCP.States {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(w, "  %s:\t%d\n", state, stats.TCP.States[state])
+	}
+	ports := make([]int, 0, len(stats.TCP.Established))
+	for port := range stats.TCP.Established {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		fmt.Fprintf(w, "  Established to :%d:\t%d\n", port, stats.TCP.Established[port])
+	}
+	w.Flush()
+	fmt.Println()
 }
 
 func formatServiceStatus(status string) string {
 	switch strings.ToLower(status) {
 	case "active":
 		return "\033[32mactive\033[0m" // Green
-	case "inactive":
-		return "\033[31minactive\033[0m" // Red
+	case "inactive", "failed":
+		return "\033[31m" + status + "\033[0m" // Red
 	default:
 		return "\033[33m" + status + "\033[0m" // Yellow for unknown status
 	}
 }
 
 func init() {
-	rootCmd.AddCommand(statusCmd)
-	rootCmd.AddCommand(logsCmd)
-	rootCmd.AddCommand(monitorCmd)
+	// Add flags for status command
+	statusCmd.Flags().String("since", "", "Summarize recorded metrics of a period, e.g. 24h or 7d")
+	statusCmd.Flags().Bool("chart", false, "Draw a chart of each metric (with --since)")
+	statusCmd.Flags().Bool("live", false, "Collect now instead of reading the agent")
 
-	// Add flags for logs command
-	logsCmd.Flags().IntP("tail", "n", 50, "Number of lines to show")
-	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	// Add flags for monitor command
+	monitorCmd.Flags().DurationP("interval", "i", 0, "Refresh interval (default monitoring.check_interval)")
 }
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/doko/cli-webpanel/internal/fileutil"
 	"github.com/spf13/cobra"
 )
 
@@ -99,16 +100,6 @@ var phpModuleRemoveCmd = &cobra.Command{
 	},
 }
 
-func init() {
-	RootCmd.AddCommand(phpCmd)
-	phpCmd.AddCommand(phpListCmd)
-	phpCmd.AddCommand(phpInstallCmd)
-	phpCmd.AddCommand(phpRemoveCmd)
-	phpCmd.AddCommand(phpModuleAvailableCmd)
-	phpCmd.AddCommand(phpModuleInstallCmd)
-	phpCmd.AddCommand(phpModuleRemoveCmd)
-}
-
 func listInstalledPHPVersions() error {
 	cmd := exec.Command("sh", "-c", "ls /usr/sbin/php-fpm* | grep -o '[0-9]\\.[0-9]'")
 	output, err := cmd.Output()
@@ -160,7 +151,7 @@ func installPHP(version string) error {
 }`, strings.ReplaceAll(version, ".", ""), version)
 
 	configPath := fmt.Sprintf("/usr/local/webpanel/config/modules/php%s.conf", strings.ReplaceAll(version, ".", ""))
-	if err := os.WriteFile(configPath, []byte(moduleConfig), 0644); err != nil {
+	if err := fileutil.WriteFile(configPath, []byte(moduleConfig), 0644); err != nil {
 		return fmt.Errorf("failed to create PHP module configuration: %v", err)
 	}
 
@@ -188,7 +179,7 @@ func removePHP(version string) error {
 
 	// Remove Caddy module configuration
 	configPath := fmt.Sprintf("/usr/local/webpanel/config/modules/php%s.conf", strings.ReplaceAll(version, ".", ""))
-	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+	if err := fileutil.Remove(configPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove PHP module configuration: %v", err)
 	}
 
@@ -3,7 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -21,12 +24,19 @@ and monitoring server resources.`,
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// Commands that change the server hold the global lock, and are recorded in
+// the audit log and the configuration files they change in the history.
 func Execute() error {
-	return RootCmd.Execute()
+	start := time.Now()
+	cmd, err := RootCmd.ExecuteC()
+	recordHistory(recordAudit(cmd, start, err))
+	releaseLock()
+	return err
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	RootCmd.PersistentPreRunE = prepareRun
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.webpanel.yaml)")
 
 	// Initialize version command
@@ -56,12 +66,37 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
+
+	// Overlay settings from the config file on top of the defaults
+	if cfg := config.GetConfig(); cfg != nil {
+		if err := viper.Unmarshal(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid config file:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// reloadConfig reads the configuration file again, starting from the
+// defaults so removed settings revert
+func reloadConfig() error {
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+	}
+
+	cfg := config.Default()
+	if err := viper.Unmarshal(cfg); err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+	config.SetConfig(cfg)
+	return nil
 }
 
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number of webpanel",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("webpanel v0.1.0")
+		fmt.Printf("webpanel v%s\n", version.Version)
 	},
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Export and import the whole server",
+	Long:  `Move every site, database and setting of this server to another server.`,
+}
+
+var serverExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the whole server to an archive",
+	Long: `Write the panel configuration, global Caddyfile, module snippets, site
+configurations and web roots, database dumps with users and grants, installed
+PHP versions and extensions, and backup schedules to a single tar.gz archive.
+
+Use --only to export a subset: ` + strings.Join(server.AllParts, ", ") + `.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		only, _ := cmd.Flags().GetString("only")
+		parts, err := server.ParseParts(only)
+		if err != nil {
+			return err
+		}
+
+		if parts[server.PartDatabases] {
+			if err := database.Initialize(); err != nil {
+				return err
+			}
+			defer database.Close()
+		}
+
+		manifest, err := server.Export(args[0], parts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Exported %s to %s\n", strings.Join(manifest.Parts, ", "), args[0])
+		fmt.Printf("  Sites:     %d\n", len(manifest.Sites))
+		fmt.Printf("  Databases: %d\n", len(manifest.Databases))
+		fmt.Printf("  PHP:       %d\n", len(manifest.PHP))
+		fmt.Printf("  Schedules: %d\n", len(manifest.Schedules))
+		return nil
+	},
+}
+
+var serverImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import a server archive",
+	Long: `Recreate a server from an archive made with 'server export'. A preflight
+report of missing packages and conflicts is shown first; missing PHP packages
+are installed with apt-get.
+
+Use --only to import a subset, e.g. --only sites,dbs.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		only, _ := cmd.Flags().GetString("only")
+		force, _ := cmd.Flags().GetBool("force")
+		checkOnly, _ := cmd.Flags().GetBool("check")
+
+		manifest, err := server.ReadManifest(path)
+		if err != nil {
+			return err
+		}
+
+		parts, err := server.ParseParts(only)
+		if err != nil {
+			return err
+		}
+		// Only import what the archive actually contains
+		exported := make(map[string]bool)
+		for _, p := range manifest.Parts {
+			exported[p] = true
+		}
+		for p := range parts {
+			if !exported[p] {
+				if only != "" {
+					return fmt.Errorf("archive does not contain %s", p)
+				}
+				delete(parts, p)
+			}
+		}
+
+		if parts[server.PartDatabases] {
+			if err := database.Initialize(); err != nil {
+				return err
+			}
+			defer database.Close()
+		}
+
+		preflight, err := server.Check(manifest, parts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Archive from %s, created %s by webpanel %s\n",
+			manifest.Host, manifest.CreatedAt.Format("2006-01-02 15:04"), manifest.ToolVersion)
+		printPreflight(preflight)
+
+		if checkOnly {
+			return nil
+		}
+		if !preflight.OK() && !force {
+			return fmt.Errorf("preflight check failed, use --force to import anyway")
+		}
+
+		if err := server.Import(path, manifest, preflight, server.ImportOptions{Parts: parts, Force: force}); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully imported %d parts from %s\n", len(parts), path)
+		return nil
+	},
+}
+
+// printPreflight prints the result of an import preflight check
+func printPreflight(p *server.Preflight) {
+	if len(p.MissingCommands) == 0 && len(p.MissingPackages) == 0 && len(p.Conflicts) == 0 {
+		fmt.Println("Preflight: OK")
+		return
+	}
+
+	fmt.Println("Preflight:")
+	for _, c := range p.MissingCommands {
+		fmt.Printf("  missing command:  %s\n", c)
+	}
+	for _, pkg := range p.MissingPackages {
+		fmt.Printf("  missing package:  %s (will be installed)\n", pkg)
+	}
+	for _, c := range p.Conflicts {
+		fmt.Printf("  conflict:         %s\n", c)
+	}
+	fmt.Println()
+}
+
+func init() {
+	// Add flags for export command
+	serverExportCmd.Flags().String("only", "", "Comma separated parts to export")
+
+	// Add flags for import command
+	serverImportCmd.Flags().String("only", "", "Comma separated parts to import")
+	serverImportCmd.Flags().Bool("force", false, "Import even if the preflight check finds conflicts")
+	serverImportCmd.Flags().Bool("check", false, "Only show the preflight report")
+}
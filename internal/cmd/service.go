@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the panel services",
+	Long: `Show and control the monitored services: the web and database servers from
+web_server.service_name and database.service_name, the units listed in
+monitoring.services and the PHP-FPM unit of every installed PHP version.`,
+}
+
+var serviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List monitored services",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tPID\tMEMORY\tRESTARTS\tSINCE\tSTATE")
+		for _, name := range service.Monitored() {
+			status, err := service.GetStatus(name)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", name, formatServiceStatus("unknown"))
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", name, formatPID(status.MainPID), formatMemory(status.Memory),
+				status.Restarts, dashIfEmpty(status.Since), formatServiceStatus(status.ActiveState))
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status [name]",
+	Short: "Show the details of a service",
+	Long: `Show the systemd details of a service. The last journal lines are included
+when the service has failed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !service.IsMonitored(name) {
+			return unknownServiceError(name)
+		}
+		lines, _ := cmd.Flags().GetInt("lines")
+		return printServiceStatus(name, lines)
+	},
+}
+
+// newServiceActionCmd returns the command running a systemctl action on a
+// monitored service
+func newServiceActionCmd(action, done, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " [name]",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if !service.IsMonitored(name) {
+				return unknownServiceError(name)
+			}
+
+			if err := service.Control(action, name); err != nil {
+				printServiceStatus(name, 20)
+				return fmt.Errorf("failed to %s %s: %v", action, name, err)
+			}
+			fmt.Printf("Service %s %s\n\n", name, done)
+			return printServiceStatus(name, 20)
+		},
+	}
+}
+
+var (
+	serviceStartCmd   = newServiceActionCmd("start", "started", "Start a service")
+	serviceStopCmd    = newServiceActionCmd("stop", "stopped", "Stop a service")
+	serviceRestartCmd = newServiceActionCmd("restart", "restarted", "Restart a service")
+	serviceReloadCmd  = newServiceActionCmd("reload", "reloaded", "Reload the configuration of a service")
+)
+
+// printServiceStatus prints the systemd details of a unit, followed by the
+// last journal lines when it has failed
+func printServiceStatus(name string, lines int) error {
+	status, err := service.GetStatus(name)
+	if err != nil {
+		return err
+	}
+	if status.LoadState == "not-found" {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+
+	fmt.Printf("%s - %s\n", name, status.Description)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  State:\t%s (%s)\n", formatServiceStatus(status.ActiveState), status.SubState)
+	fmt.Fprintf(w, "  Since:\t%s\n", dashIfEmpty(status.Since))
+	fmt.Fprintf(w, "  Main PID:\t%s\n", formatPID(status.MainPID))
+	fmt.Fprintf(w, "  Memory:\t%s\n", formatMemory(status.Memory))
+	fmt.Fprintf(w, "  Restarts:\t%d\n", status.Restarts)
+	w.Flush()
+
+	if status.Failed() && lines > 0 {
+		journal, err := service.Journal(name, lines)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nLast %d journal lines:\n%s\n", lines, journal)
+	}
+	return nil
+}
+
+func unknownServiceError(name string) error {
+	return fmt.Errorf("unknown service: %s (monitored: %s)", name, strings.Join(service.Monitored(), ", "))
+}
+
+func formatPID(pid int) string {
+	if pid == 0 {
+		return "-"
+	}
+	return fmt.Sprint(pid)
+}
+
+func formatMemory(bytes uint64) string {
+	if bytes == 0 {
+		return "-"
+	}
+	return monitoring.FormatBytes(bytes)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	serviceStatusCmd.Flags().Int("lines", 20, "Journal lines shown for a failed service")
+}
@@ -3,10 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/doko/cli-webpanel/internal/config"
 	"github.com/doko/cli-webpanel/internal/module"
+	"github.com/doko/cli-webpanel/internal/site"
 	"github.com/spf13/cobra"
 )
 
@@ -29,60 +29,14 @@ var siteAddCmd = &cobra.Command{
 			return err
 		}
 
-		// Create site directory
-		siteDir := config.GetSiteDirectory(domain)
-		if err := os.MkdirAll(siteDir, 0755); err != nil {
-			return fmt.Errorf("failed to create site directory: %v", err)
-		}
-
-		// Create public directory
-		publicDir := filepath.Join(siteDir, "public")
-		if err := os.MkdirAll(publicDir, 0755); err != nil {
-			return fmt.Errorf("failed to create public directory: %v", err)
-		}
-
-		// Create logs directory
-		logsDir := filepath.Join("/var/log/webpanel/caddy", domain)
-		if err := os.MkdirAll(logsDir, 0755); err != nil {
-			return fmt.Errorf("failed to create logs directory: %v", err)
-		}
-
-		// Create basic index.html
-		indexPath := filepath.Join(publicDir, "index.html")
-		indexContent := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-    <title>Welcome to %s</title>
-</head>
-<body>
-    <h1>Welcome to %s</h1>
-    <p>Your website is now set up and running!</p>
-</body>
-</html>`, domain, domain)
-
-		if err := os.WriteFile(indexPath, []byte(indexContent), 0644); err != nil {
-			return fmt.Errorf("failed to create index.html: %v", err)
-		}
-
-		// Create Caddy configuration with default modules
-		caddyConfig := fmt.Sprintf(`%s {
-    root * %s
-    import access_log %s
-    import error_log %s
-    import header_config
-    import security_config
-    import php_config
-}`, domain, publicDir, domain, domain)
-
-		configPath := config.GetSiteConfigPath(domain)
-		if err := os.WriteFile(configPath, []byte(caddyConfig), 0644); err != nil {
-			return fmt.Errorf("failed to create Caddy configuration: %v", err)
+		if err := site.Create(domain, site.DefaultConfig(domain)); err != nil {
+			return err
 		}
 
 		fmt.Printf("Successfully created website for %s\n", domain)
-		fmt.Printf("Site directory: %s\n", siteDir)
-		fmt.Printf("Configuration: %s\n", configPath)
-		fmt.Printf("Log directory: %s\n", logsDir)
+		fmt.Printf("Site directory: %s\n", config.GetSiteDirectory(domain))
+		fmt.Printf("Configuration: %s\n", config.GetSiteConfigPath(domain))
+		fmt.Printf("Log directory: %s\n", config.GetSiteLogDirectory(domain))
 		return nil
 	},
 }
@@ -117,6 +71,15 @@ var siteListCmd = &cobra.Command{
 						fmt.Printf("    - %s\n", mod)
 					}
 				}
+
+				// List linked databases
+				meta, err := site.LoadMetadata(domain)
+				if err == nil && len(meta.Databases) > 0 {
+					fmt.Printf("  Linked databases:\n")
+					for _, db := range meta.Databases {
+						fmt.Printf("    - %s\n", db)
+					}
+				}
 			}
 		}
 		return nil
@@ -151,31 +114,66 @@ var siteRmCmd = &cobra.Command{
 			return nil
 		}
 
-		// Remove site directory
-		if err := os.RemoveAll(siteDir); err != nil {
-			return fmt.Errorf("failed to remove site directory: %v", err)
+		if err := site.Remove(domain); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully removed website %s\n", domain)
+		return nil
+	},
+}
+
+var siteLinkDBCmd = &cobra.Command{
+	Use:   "link-db [domain] [dbname]",
+	Short: "Link a database to a website",
+	Long: `Record that a database belongs to a website. Linked databases are dumped
+together with the website files when a site snapshot is taken.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		dbname := args[1]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+		if err := config.ValidateDatabaseName(dbname); err != nil {
+			return err
 		}
 
-		// Remove configuration file
-		configPath := config.GetSiteConfigPath(domain)
-		if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove configuration file: %v", err)
+		// Check if site exists
+		if _, err := os.Stat(config.GetSiteDirectory(domain)); os.IsNotExist(err) {
+			return fmt.Errorf("website %s does not exist", domain)
 		}
 
-		// Remove log directory
-		logDir := filepath.Join("/var/log/webpanel/caddy", domain)
-		if err := os.RemoveAll(logDir); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove log directory: %v", err)
+		if err := site.LinkDatabase(domain, dbname); err != nil {
+			return err
 		}
 
-		fmt.Printf("Successfully removed website %s\n", domain)
+		fmt.Printf("Successfully linked database '%s' to %s\n", dbname, domain)
 		return nil
 	},
 }
 
-func init() {
-	RootCmd.AddCommand(siteCmd)
-	siteCmd.AddCommand(siteAddCmd)
-	siteCmd.AddCommand(siteListCmd)
-	siteCmd.AddCommand(siteRmCmd)
+var siteUnlinkDBCmd = &cobra.Command{
+	Use:   "unlink-db [domain] [dbname]",
+	Short: "Unlink a database from a website",
+	Long:  `Remove the link between a database and a website. The database itself is not deleted.`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		dbname := args[1]
+
+		// Validate domain name
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+
+		if err := site.UnlinkDatabase(domain, dbname); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully unlinked database '%s' from %s\n", dbname, domain)
+		return nil
+	},
 }
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/state"
+	"github.com/spf13/cobra"
+)
+
+const stateFileExample = `sites:
+  - domain: example.com
+    type: php            # php (default), static or spa
+    php: "8.2"           # optional, installed with "webpanel php install 8.2"
+    aliases: [www.example.com]
+    modules:
+      - header
+      - security
+      - name: restrict
+        params: [192.168.1.0/24]
+    databases: [example]  # linked databases
+databases: [example]
+users:
+  - name: example
+    password_env: EXAMPLE_DB_PASSWORD
+    grants: [example]
+backups:
+  - site: example.com
+    schedule: [daily, weekly]
+  - database: example
+    schedule: [daily]`
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes applying a state file would make",
+	Long: `Compare the server with a state file and show the changes "apply" would
+make, without changing anything. Example state file:
+
+` + stateFileExample,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		desired, withDatabase, err := readStateFile(cmd)
+		if err != nil {
+			return err
+		}
+		if withDatabase {
+			if err := database.Initialize(); err != nil {
+				return err
+			}
+			defer database.Close()
+		}
+
+		plan, err := loadPlan(cmd, desired, withDatabase)
+		if err != nil {
+			return err
+		}
+		printPlan(plan)
+		return nil
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Converge the server to a state file",
+	Long: `Create and update the sites, databases, users and backup schedules of a
+state file, in dependency order. Applying the same file again changes
+nothing. Sites, users and backup schedules in the file are converged to
+exactly what it describes, including modules, linked databases and grants.
+Resources the file does not mention are left alone, unless --prune is given:
+then they are removed, including the web root of sites and the tables of
+databases.
+
+Passwords are only used to create users. See "webpanel plan --help" for an
+example state file, and "webpanel export-state" to write one describing
+this server.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		desired, withDatabase, err := readStateFile(cmd)
+		if err != nil {
+			return err
+		}
+		// The connection stays open for the changes made by Apply
+		if withDatabase {
+			if err := database.Initialize(); err != nil {
+				return err
+			}
+			defer database.Close()
+		}
+
+		plan, err := loadPlan(cmd, desired, withDatabase)
+		if err != nil {
+			return err
+		}
+		if !printPlan(plan) {
+			return nil
+		}
+
+		if !yes {
+			fmt.Print("\nApply these changes? [y/N]: ")
+			var response string
+			fmt.Scanln(&response)
+			if response != "y" && response != "Y" {
+				fmt.Println("Operation cancelled")
+				return nil
+			}
+		}
+
+		fmt.Println()
+		err = plan.Apply(func(c *state.Change) {
+			fmt.Printf("%s %s\n", changeSymbol(c.Action), c.Resource)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Server converged to the state file")
+		return nil
+	},
+}
+
+var exportStateCmd = &cobra.Command{
+	Use:   "export-state",
+	Short: "Write the current server as a state file",
+	Long: `Describe the sites, databases, users and backup schedules of this server as
+a state file for "webpanel apply". Passwords cannot be exported: set password
+or password_env of each user before applying the file to another server.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+
+		withDatabase := true
+		if err := database.Initialize(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: databases and users are not exported: %v\n", err)
+			withDatabase = false
+		} else {
+			defer database.Close()
+		}
+
+		current, warnings, err := state.Current(withDatabase)
+		if err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+
+		data, err := current.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to format state: %v", err)
+		}
+		data = append([]byte("# webpanel state, apply with: webpanel apply -f <file>\n"), data...)
+
+		if output == "" || output == "-" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			return fmt.Errorf("failed to write state file: %v", err)
+		}
+		fmt.Printf("State written to %s\n", output)
+		return nil
+	},
+}
+
+// readStateFile reads the state file of a plan or apply command and
+// reports whether comparing it with the server needs the database
+func readStateFile(cmd *cobra.Command) (*state.State, bool, error) {
+	file, _ := cmd.Flags().GetString("file")
+	prune, _ := cmd.Flags().GetBool("prune")
+	if file == "" {
+		return nil, false, fmt.Errorf("a state file is required (-f)")
+	}
+
+	desired, err := state.Load(file)
+	if err != nil {
+		return nil, false, err
+	}
+	return desired, prune || desired.NeedsDatabase(), nil
+}
+
+// loadPlan compares a state file with the server. With withDatabase the
+// database connection must be initialized.
+func loadPlan(cmd *cobra.Command, desired *state.State, withDatabase bool) (*state.Plan, error) {
+	prune, _ := cmd.Flags().GetBool("prune")
+
+	current, warnings, err := state.Current(withDatabase)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	return state.NewPlan(desired, current, prune)
+}
+
+// printPlan shows the changes of a plan and reports whether there are any
+func printPlan(plan *state.Plan) bool {
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes, the server matches the state file")
+		return false
+	}
+
+	for _, c := range plan.Changes {
+		fmt.Printf("%s %s\n", changeSymbol(c.Action), c.Resource)
+		for _, line := range c.Details {
+			fmt.Printf("    %s\n", colorDiffLine(line))
+		}
+	}
+
+	create, update, remove := plan.Count()
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to remove\n", create, update, remove)
+	return true
+}
+
+func changeSymbol(action string) string {
+	switch action {
+	case state.Create:
+		return "\033[32m+\033[0m"
+	case state.Delete:
+		return "\033[31m-\033[0m"
+	default:
+		return "\033[33m~\033[0m"
+	}
+}
+
+// colorDiffLine colours added and removed lines of a diff
+func colorDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		return line
+	case strings.HasPrefix(line, "+"):
+		return "\033[32m" + line + "\033[0m"
+	case strings.HasPrefix(line, "-"):
+		return "\033[31m" + line + "\033[0m"
+	}
+	return line
+}
+
+func init() {
+	for _, c := range []*cobra.Command{planCmd, applyCmd} {
+		c.Flags().StringP("file", "f", "", "State file describing the server")
+		c.Flags().Bool("prune", false, "Remove sites, databases, users and backup schedules the file does not mention")
+	}
+	applyCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	exportStateCmd.Flags().StringP("output", "o", "", "Write the state to a file instead of stdout")
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+)
+
+// fakeDriver is a database driver recording the statements it executes.
+// Queries return no rows, so the server has no databases or users.
+type fakeDriver struct {
+	mu   sync.Mutex
+	exec []string
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{d: d}, nil
+}
+
+func (d *fakeDriver) statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.exec...)
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.exec = append(s.d.exec, s.query)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"name"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+var testDriver = &fakeDriver{}
+
+func init() {
+	sql.Register("webpanel-test", testDriver)
+}
+
+func TestApplyDatabaseChanges(t *testing.T) {
+	root := t.TempDir()
+	config.SetConfig(&config.Config{
+		WebRoot:   filepath.Join(root, "sites"),
+		ConfigDir: filepath.Join(root, "config"),
+		LogDir:    filepath.Join(root, "logs"),
+	})
+	if err := os.MkdirAll(config.GetWebRoot(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	driverName, dsn := database.Driver, database.DSN
+	database.Driver, database.DSN = "webpanel-test", ""
+	defer func() { database.Driver, database.DSN = driverName, dsn }()
+
+	file := filepath.Join(root, "state.yml")
+	stateFile := `databases: [shop]
+users:
+  - name: shop
+    password: secret
+    grants: [shop]
+`
+	if err := os.WriteFile(file, []byte(stateFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	applyCmd.Flags().Set("file", file)
+	applyCmd.Flags().Set("yes", "true")
+	defer applyCmd.Flags().Set("file", "")
+	defer applyCmd.Flags().Set("yes", "false")
+
+	if err := applyCmd.RunE(applyCmd, nil); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	want := []string{
+		"CREATE DATABASE IF NOT EXISTS `shop`",
+		"CREATE USER 'shop'@'localhost' IDENTIFIED BY 'secret'",
+		"GRANT ALL PRIVILEGES ON `shop`.* TO 'shop'@'localhost'",
+		"FLUSH PRIVILEGES",
+	}
+	if got := testDriver.statements(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("executed\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
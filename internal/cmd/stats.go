@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/logs"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [domain]",
+	Short: "Report the traffic of a site",
+	Long: `Aggregate the Caddy JSON access logs of a site, written by the access_log
+module, into a report of requests over time, status codes, top paths, client
+IPs, user agents and referrers, bandwidth and latency percentiles. Rolled
+log files, compressed or not, are included. The report is printed as tables,
+as JSON or as a self-contained HTML page.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		if err := config.ValidateSiteName(domain); err != nil {
+			return err
+		}
+		since, _ := cmd.Flags().GetString("since")
+		format, _ := cmd.Flags().GetString("format")
+		top, _ := cmd.Flags().GetInt("top")
+		output, _ := cmd.Flags().GetString("output")
+
+		if format != "table" && format != "json" && format != "html" {
+			return fmt.Errorf("unknown format: %s (use table, json or html)", format)
+		}
+		period, err := parseSince(since)
+		if err != nil {
+			return err
+		}
+
+		dir := config.GetSiteLogDirectory(domain)
+		files, err := logs.AccessLogFiles(dir, time.Now().Add(-period))
+		if err != nil {
+			return fmt.Errorf("failed to list access logs: %v", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no access log found in %s (is the access_log module enabled for %s?)", dir, domain)
+		}
+
+		analyzer := logs.NewAnalyzer(time.Now().Add(-period))
+		for _, file := range files {
+			if err := analyzer.AnalyzeFile(file); err != nil {
+				return fmt.Errorf("failed to read %s: %v", file, err)
+			}
+		}
+		report := analyzer.Report(domain, top)
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create report file: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		switch format {
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(report)
+		case "html":
+			err = report.WriteHTML(w)
+		default:
+			printStatsReport(w, report)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write report: %v", err)
+		}
+
+		if output != "" {
+			fmt.Printf("Report written to %s\n", output)
+		}
+		return nil
+	},
+}
+
+// printStatsReport prints a report as tables
+func printStatsReport(out io.Writer, r *logs.Report) {
+	fmt.Fprintf(out, "Traffic of %s from %s to %s\n\n", r.Domain,
+		r.Since.Format("2006-01-02 15:04"), r.Until.Format("2006-01-02 15:04"))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  Requests:\t%d\n", r.Requests)
+	fmt.Fprintf(w, "  Bandwidth:\t%s\n", monitoring.FormatBytes(r.Bytes))
+	fmt.Fprintf(w, "  Latency:\tp50 %.1f ms, p90 %.1f ms, p95 %.1f ms, p99 %.1f ms, max %.1f ms\n",
+		r.Latency.P50, r.Latency.P90, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+	w.Flush()
+	if r.Requests == 0 {
+		return
+	}
+
+	var max uint64
+	for _, b := range r.Timeline {
+		if b.Requests > max {
+			max = b.Requests
+		}
+	}
+	fmt.Fprintf(out, "\nRequests per %s:\n", r.Interval)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  TIME\tREQUESTS\t5XX\tBANDWIDTH\t")
+	for _, b := range r.Timeline {
+		bar := strings.Repeat("#", int(float64(b.Requests)*40/float64(max)))
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s\t%s\n", b.Start.Format("01-02 15:04"), b.Requests, b.Errors,
+			monitoring.FormatBytes(b.Bytes), bar)
+	}
+	w.Flush()
+
+	printStatsCounts(out, "Status codes", r.StatusCodes, r.Requests)
+	printStatsCounts(out, "Top paths", r.Paths, r.Requests)
+	printStatsCounts(out, "Top client IPs", r.ClientIPs, r.Requests)
+	printStatsCounts(out, "Top user agents", r.UserAgents, r.Requests)
+	printStatsCounts(out, "Top referrers", r.Referrers, r.Requests)
+}
+
+// printStatsCounts prints a top list with the share of all requests
+func printStatsCounts(out io.Writer, title string, counts []logs.Count, total uint64) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n%s:\n", title)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, c := range counts {
+		key := c.Key
+		if len(key) > 80 {
+			key = key[:77] + "..."
+		}
+		fmt.Fprintf(w, "  %d\t%.1f%%\t%s\n", c.Requests, float64(c.Requests)*100/float64(total), key)
+	}
+	w.Flush()
+}
+
+func init() {
+	statsCmd.Flags().String("since", "24h", "Period to report, e.g. 1h, 24h or 7d")
+	statsCmd.Flags().String("format", "table", "Output format: table, json or html")
+	statsCmd.Flags().Int("top", 10, "Number of entries in the top lists")
+	statsCmd.Flags().StringP("output", "o", "", "Write the report to a file instead of stdout")
+}
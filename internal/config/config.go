@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"time"
 )
 
 const (
@@ -12,32 +14,161 @@ const (
 	DefaultBackupDir = "/backup"
 	DefaultLogDir    = "/usr/local/webpanel/logs"
 	DefaultModuleDir = "/usr/local/webpanel/lib/modules"
+
+	// SiteLogRoot is where the access_log and error_log modules write
+	SiteLogRoot = "/var/log/webpanel/caddy"
+
+	// DatabaseDataDir is where MariaDB keeps its data files
+	DatabaseDataDir = "/var/lib/mysql"
+
+	// DatabaseLogDir is where MariaDB writes its error and slow query logs
+	DatabaseLogDir = "/var/log/mysql"
 )
 
 type Config struct {
-	WebRoot   string `mapstructure:"web_root"`
-	ConfigDir string `mapstructure:"config_dir"`
-	BackupDir string `mapstructure:"backup_dir"`
-	LogDir    string `mapstructure:"log_dir"`
-	ModuleDir string `mapstructure:"module_dir"`
+	WebRoot   string       `mapstructure:"web_root"`
+	ConfigDir string       `mapstructure:"config_dir"`
+	BackupDir string       `mapstructure:"backup_dir"`
+	LogDir    string       `mapstructure:"log_dir"`
+	ModuleDir string       `mapstructure:"module_dir"`
+	Backup    BackupConfig `mapstructure:"backup"`
+
+	WebServer     WebServerConfig    `mapstructure:"web_server"`
+	Database      DatabaseConfig     `mapstructure:"database"`
+	Monitoring    MonitoringConfig   `mapstructure:"monitoring"`
+	Logging       LoggingConfig      `mapstructure:"logging"`
+	Notifications NotificationConfig `mapstructure:"notifications"`
+	Alerts        AlertsConfig       `mapstructure:"alerts"`
+}
+
+// WebServerConfig holds the web_server section of the configuration file
+type WebServerConfig struct {
+	ServiceName string `mapstructure:"service_name"` // systemd unit of the web server
+}
+
+// DatabaseConfig holds the database section of the configuration file
+type DatabaseConfig struct {
+	ServiceName string `mapstructure:"service_name"` // systemd unit of the database server
+}
+
+// AlertsConfig holds the alert rules and how often firing alerts are
+// repeated
+type AlertsConfig struct {
+	RepeatInterval time.Duration     `mapstructure:"repeat_interval"`
+	Rules          []AlertRuleConfig `mapstructure:"rules"`
+}
+
+// AlertRuleConfig is one alert rule, e.g. "disk.usage_perc > 90 for 10m"
+type AlertRuleConfig struct {
+	Name     string `mapstructure:"name"`
+	Expr     string `mapstructure:"expr"`
+	Severity string `mapstructure:"severity"`
+}
+
+// MonitoringConfig holds the monitoring section of the configuration file
+type MonitoringConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	CheckInterval    int      `mapstructure:"check_interval"` // seconds
+	LogRetentionDays int      `mapstructure:"log_retention_days"`
+	Metrics          []string `mapstructure:"metrics"`
+	DiskThreshold    float64  `mapstructure:"disk_threshold"` // usage percent highlighted in status
+	AgentSocket      string   `mapstructure:"agent_socket"`   // unix socket of "webpanel agent"
+	Services         []string `mapstructure:"services"`       // units monitored besides the web and database servers
+}
+
+// LoggingConfig holds the logging section of the configuration file. The
+// limits apply to the Caddy site logs, the panel logs and the MariaDB logs.
+type LoggingConfig struct {
+	Level        string `mapstructure:"level"`
+	MaxSize      int    `mapstructure:"max_size"`      // MB before a log is rotated
+	MaxFiles     int    `mapstructure:"max_files"`     // rotated files kept per log, 0 keeps them all
+	MaxAgeDays   int    `mapstructure:"max_age_days"`  // rotated files older than this are removed, 0 keeps them
+	CompressLogs bool   `mapstructure:"compress_logs"` // gzip rotated files
+}
+
+// BackupConfig holds the backup section of the configuration file
+type BackupConfig struct {
+	Daily           BackupScheduleConfig `mapstructure:"daily"`
+	Weekly          BackupScheduleConfig `mapstructure:"weekly"`
+	Retention       RetentionConfig      `mapstructure:"retention"`
+	Compress        bool                 `mapstructure:"compress"`
+	RandomDelay     time.Duration        `mapstructure:"random_delay"`
+	NotifyOnFailure bool                 `mapstructure:"notify_on_failure"`
+	SpaceCheck      bool                 `mapstructure:"space_check"`
+	MinFreeMB       int                  `mapstructure:"min_free_mb"`
+	PruneOnLowSpace bool                 `mapstructure:"prune_on_low_space"`
+	Nice            int                  `mapstructure:"nice"`
+	IOClass         string               `mapstructure:"io_class"`
+	Concurrency     int                  `mapstructure:"concurrency"`
+}
+
+// BackupScheduleConfig holds the settings for one backup schedule (daily or weekly)
+type BackupScheduleConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	Day           string `mapstructure:"day"`
+	Time          string `mapstructure:"time"`
+	RetentionDays int    `mapstructure:"retention_days"`
+}
+
+// RetentionConfig holds the grandfather-father-son retention settings.
+// A backup is kept when any of the rules selects it.
+type RetentionConfig struct {
+	KeepDaily   int `mapstructure:"keep_daily"`
+	KeepWeekly  int `mapstructure:"keep_weekly"`
+	KeepMonthly int `mapstructure:"keep_monthly"`
+	MinKeep     int `mapstructure:"min_keep"`
 }
 
 // Global configuration instance
 var globalConfig *Config
 
+// Default returns the configuration used for settings the configuration
+// file leaves out
+func Default() *Config {
+	return &Config{
+		WebRoot:    DefaultWebRoot,
+		ConfigDir:  DefaultConfigDir,
+		BackupDir:  DefaultBackupDir,
+		LogDir:     DefaultLogDir,
+		ModuleDir:  DefaultModuleDir,
+		Backup:     DefaultBackupConfig(),
+		WebServer:  WebServerConfig{ServiceName: "caddy"},
+		Database:   DatabaseConfig{ServiceName: "mariadb"},
+		Monitoring: DefaultMonitoringConfig(),
+		Logging: LoggingConfig{
+			Level:        "info",
+			MaxSize:      100,
+			MaxFiles:     5,
+			MaxAgeDays:   30,
+			CompressLogs: true,
+		},
+		Notifications: NotificationConfig{
+			Sendmail: SendmailConfig{Path: "/usr/sbin/sendmail"},
+			SMTP:     SMTPConfig{Port: 25},
+		},
+		Alerts: AlertsConfig{RepeatInterval: 4 * time.Hour},
+	}
+}
+
 // Init initializes the configuration system
 func Init() error {
 	// Create default configuration
-	globalConfig = &Config{
-		WebRoot:   DefaultWebRoot,
-		ConfigDir: DefaultConfigDir,
-		BackupDir: DefaultBackupDir,
-		LogDir:    DefaultLogDir,
-		ModuleDir: DefaultModuleDir,
-	}
+	globalConfig = Default()
 
 	// Ensure directories exist
-	dirs := []string{
+	for _, dir := range RequiredDirectories() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// RequiredDirectories returns the directories the panel needs, as created
+// by Init
+func RequiredDirectories() []string {
+	return []string{
 		globalConfig.WebRoot,
 		globalConfig.ConfigDir,
 		globalConfig.BackupDir,
@@ -45,15 +176,95 @@ func Init() error {
 		globalConfig.ModuleDir,
 		filepath.Join(globalConfig.ConfigDir, "sites"),
 		filepath.Join(globalConfig.ConfigDir, "global"),
+		filepath.Join(globalConfig.ConfigDir, "meta"),
 	}
+}
 
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %v", dir, err)
-		}
+// NotificationConfig holds the channels used for backup failures and alerts
+type NotificationConfig struct {
+	Sendmail SendmailConfig `mapstructure:"sendmail"`
+	SMTP     SMTPConfig     `mapstructure:"smtp"`
+	Webhook  WebhookConfig  `mapstructure:"webhook"`
+	LogFile  LogFileConfig  `mapstructure:"logfile"`
+}
+
+// SendmailConfig configures delivery through the local sendmail binary
+type SendmailConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Path    string   `mapstructure:"path"`
+	From    string   `mapstructure:"from"`
+	To      []string `mapstructure:"to"`
+}
+
+// SMTPConfig configures delivery through an SMTP server. STARTTLS is used
+// when the server offers it.
+type SMTPConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+// LogFileConfig configures appending notifications as JSON lines to a
+// local file. An empty path writes alerts.log in the log directory.
+type LogFileConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Path    string `mapstructure:"path"`
+}
+
+// WebhookConfig configures delivery as JSON posted to a URL
+type WebhookConfig struct {
+	Enabled bool              `mapstructure:"enabled"`
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// DefaultBackupConfig returns the backup settings used when the
+// configuration file does not override them
+func DefaultBackupConfig() BackupConfig {
+	return BackupConfig{
+		Daily: BackupScheduleConfig{
+			Enabled:       true,
+			Time:          "01:00",
+			RetentionDays: 7,
+		},
+		Weekly: BackupScheduleConfig{
+			Enabled:       true,
+			Day:           "sunday",
+			Time:          "02:00",
+			RetentionDays: 30,
+		},
+		Retention: RetentionConfig{
+			KeepDaily:   7,
+			KeepWeekly:  4,
+			KeepMonthly: 6,
+			MinKeep:     3,
+		},
+		Compress:        true,
+		RandomDelay:     15 * time.Minute,
+		NotifyOnFailure: true,
+		SpaceCheck:      true,
+		MinFreeMB:       1024,
+		Nice:            10,
+		IOClass:         "idle",
+		Concurrency:     1,
 	}
+}
 
-	return nil
+// DefaultMonitoringConfig returns the monitoring settings used when the
+// configuration file does not override them
+func DefaultMonitoringConfig() MonitoringConfig {
+	return MonitoringConfig{
+		Enabled:          true,
+		CheckInterval:    60,
+		LogRetentionDays: 7,
+		Metrics:          []string{"cpu", "memory", "disk", "services"},
+		DiskThreshold:    85,
+		AgentSocket:      "/run/webpanel/agent.sock",
+	}
 }
 
 // GetConfig returns the global configuration instance
@@ -86,17 +297,87 @@ func GetLogDir() string {
 	return globalConfig.LogDir
 }
 
+// GetMetricsDir returns the directory holding the recorded metrics history
+func GetMetricsDir() string {
+	return filepath.Join(globalConfig.LogDir, "metrics")
+}
+
 // GetModuleDir returns the configured module directory
 func GetModuleDir() string {
 	return globalConfig.ModuleDir
 }
 
+// GetBackupConfig returns the configured backup settings
+func GetBackupConfig() BackupConfig {
+	return globalConfig.Backup
+}
+
+// GetMonitoringConfig returns the configured monitoring settings
+func GetMonitoringConfig() MonitoringConfig {
+	return globalConfig.Monitoring
+}
+
+// GetLoggingConfig returns the configured log rotation settings
+func GetLoggingConfig() LoggingConfig {
+	return globalConfig.Logging
+}
+
+// GetManagedDirectories returns the directories holding panel managed
+// data. They often live on separate volumes.
+func GetManagedDirectories() []string {
+	return []string{
+		GetWebRoot(),
+		GetBackupDir(),
+		DatabaseDataDir,
+		GetConfigDir(),
+		GetLogDir(),
+	}
+}
+
+// GetWebServerConfig returns the configured web server settings
+func GetWebServerConfig() WebServerConfig {
+	return globalConfig.WebServer
+}
+
+// GetDatabaseConfig returns the configured database server settings
+func GetDatabaseConfig() DatabaseConfig {
+	return globalConfig.Database
+}
+
+// GetNotificationConfig returns the configured notification channels
+func GetNotificationConfig() NotificationConfig {
+	return globalConfig.Notifications
+}
+
+// GetAlertsConfig returns the configured alert rules
+func GetAlertsConfig() AlertsConfig {
+	return globalConfig.Alerts
+}
+
+// siteNamePattern matches lower case host names. Site names become file
+// names and arguments of tar, rsync, systemd units and crontab lines.
+var siteNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
 // ValidateSiteName checks if a site name is valid
 func ValidateSiteName(domain string) error {
 	if domain == "" {
 		return fmt.Errorf("domain name cannot be empty")
 	}
-	// Add more domain validation if needed
+	if len(domain) > 253 || !siteNamePattern.MatchString(domain) {
+		return fmt.Errorf("invalid domain name: %s", domain)
+	}
+	return nil
+}
+
+// databaseNamePattern matches database and user names, which are quoted
+// into SQL and become part of backup paths and job names
+var databaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// ValidateDatabaseName validates a database or database user name
+func ValidateDatabaseName(name string) error {
+	if !databaseNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid database name: %s", name)
+	}
 	return nil
 }
 
@@ -109,3 +390,13 @@ func GetSiteDirectory(domain string) string {
 func GetSiteConfigPath(domain string) string {
 	return filepath.Join(GetConfigDir(), "sites", domain+".conf")
 }
+
+// GetSiteMetaPath returns the full path to a site's panel metadata file
+func GetSiteMetaPath(domain string) string {
+	return filepath.Join(GetConfigDir(), "meta", domain+".json")
+}
+
+// GetSiteLogDirectory returns the directory holding a site's Caddy logs
+func GetSiteLogDirectory(domain string) string {
+	return filepath.Join(SiteLogRoot, domain)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSiteName(t *testing.T) {
+	tests := map[string]bool{
+		"example.com":        true,
+		"www.example.co.uk":  true,
+		"xn--bcher-kva.de":   true,
+		"a-b.example":        true,
+		"localhost":          true,
+		"":                   false,
+		"Example.com":        false,
+		"-example.com":       false,
+		"example-.com":       false,
+		".example.com":       false,
+		"example.com.":       false,
+		"example..com":       false,
+		"../etc":             false,
+		"example.com/x":      false,
+		`example.com\x`:      false,
+		"example.com\n* * *": false,
+		"example%n.com":      false,
+		"exa mple.com":       false,
+		"*.example.com":      false,
+	}
+	for name, valid := range tests {
+		if err := ValidateSiteName(name); (err == nil) != valid {
+			t.Errorf("ValidateSiteName(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
+
+func TestValidateDatabaseName(t *testing.T) {
+	tests := map[string]bool{
+		"wordpress": true,
+		"shop_2024": true,
+		"":          false,
+		"my-db":     false,
+		"db.name":   false,
+		"../x":      false,
+		"db`; DROP": false,
+
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+	}
+	for name, valid := range tests {
+		if err := ValidateDatabaseName(name); (err == nil) != valid {
+			t.Errorf("ValidateDatabaseName(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
@@ -1,24 +1,43 @@
 package database
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
-	"os"
-	"path/filepath"
-	"time"
+	"io"
+	"os/exec"
+	"strings"
 
-	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/lock"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var db *sql.DB
 
+// systemDatabases are created by the server and never listed, exported or
+// migrated. MariaDB 10.6 and later add sys.
+var systemDatabases = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+// systemUsers are the accounts the server creates for itself, quoted for
+// SQL
+const systemUsers = "'root', 'mysql', 'mysql.sys', 'mysql.session', 'mysql.infoschema', 'mariadb.sys'"
+
+// Driver and DSN select the connection Initialize opens: root on the local
+// server, to set up the initial databases
+var (
+	Driver = "mysql"
+	DSN    = "root@tcp(127.0.0.1:3306)/"
+)
+
 // Initialize sets up the database connection
 func Initialize() error {
-	// Try to connect as root first to setup initial database
-	dsn := fmt.Sprintf("root@tcp(127.0.0.1:3306)/")
 	var err error
-	db, err = sql.Open("mysql", dsn)
+	db, err = sql.Open(Driver, DSN)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
@@ -40,8 +59,14 @@ func Close() error {
 
 // CreateDatabase creates a new database
 func CreateDatabase(name string) error {
+	l, err := lock.Database(name)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
 	query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", name)
-	_, err := db.Exec(query)
+	_, err = db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("failed to create database: %v", err)
 	}
@@ -50,8 +75,14 @@ func CreateDatabase(name string) error {
 
 // DeleteDatabase deletes a database
 func DeleteDatabase(name string) error {
+	l, err := lock.Database(name)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
 	query := fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name)
-	_, err := db.Exec(query)
+	_, err = db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("failed to delete database: %v", err)
 	}
@@ -73,7 +104,7 @@ func ListDatabases() ([]string, error) {
 			return nil, fmt.Errorf("failed to scan database name: %v", err)
 		}
 		// Skip system databases
-		if name != "information_schema" && name != "mysql" && name != "performance_schema" {
+		if !systemDatabases[name] {
 			databases = append(databases, name)
 		}
 	}
@@ -81,6 +112,31 @@ func ListDatabases() ([]string, error) {
 	return databases, nil
 }
 
+// Sizes returns the size of the data and indexes of every database in
+// bytes
+func Sizes() (map[string]int64, error) {
+	rows, err := db.Query(`SELECT table_schema, COALESCE(SUM(data_length + index_length), 0)
+		FROM information_schema.tables GROUP BY table_schema`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database sizes: %v", err)
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return nil, fmt.Errorf("failed to scan database size: %v", err)
+		}
+		if !systemDatabases[name] {
+			sizes[name] = size
+		}
+	}
+
+	return sizes, rows.Err()
+}
+
 // CreateUser creates a new database user
 func CreateUser(username, password string) error {
 	// Create user
@@ -104,7 +160,7 @@ func DeleteUser(username string) error {
 
 // ListUsers returns a list of all database users
 func ListUsers() ([]string, error) {
-	rows, err := db.Query("SELECT User FROM mysql.user WHERE Host = 'localhost' AND User NOT IN ('root', 'mysql.sys', 'mysql.session')")
+	rows, err := db.Query("SELECT User FROM mysql.user WHERE Host = 'localhost' AND User NOT IN (" + systemUsers + ")")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list users: %v", err)
 	}
@@ -139,34 +195,136 @@ func GrantAccess(username, dbname string) error {
 	return nil
 }
 
-// BackupDatabase creates a backup of the specified database
-func BackupDatabase(name, backupType string) error {
-	now := time.Now()
-	var filename string
-	backupDir := filepath.Join(config.GetBackupDir(), backupType, name)
+// RevokeAccess removes the access of a user to a database
+func RevokeAccess(username, dbname string) error {
+	query := fmt.Sprintf("REVOKE ALL PRIVILEGES ON `%s`.* FROM '%s'@'localhost'", dbname, username)
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to revoke access: %v", err)
+	}
 
-	if err := os.MkdirAll(backupDir, 0755); err != nil {
-		return fmt.Errorf("failed to create backup directory: %v", err)
+	if _, err := db.Exec("FLUSH PRIVILEGES"); err != nil {
+		return fmt.Errorf("failed to flush privileges: %v", err)
 	}
 
-	if backupType == "weekly" {
-		filename = fmt.Sprintf("%s/%s-full.sql.gz", backupDir, now.Format("2006-01-02"))
-	} else {
-		filename = fmt.Sprintf("%s/%s.sql.gz", backupDir, now.Format("2006-01-02"))
+	return nil
+}
+
+// Grants returns the databases a user has been granted access to
+func Grants(username string) ([]string, error) {
+	rows, err := db.Query("SELECT Db FROM mysql.db WHERE User = ? AND Host = 'localhost' ORDER BY Db", username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %v", err)
 	}
+	defer rows.Close()
 
-	// Create backup command
-	cmd := fmt.Sprintf("mysqldump -u root %s | gzip > %s", name, filename)
+	var databases []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %v", err)
+		}
+		databases = append(databases, name)
+	}
 
-	// Execute backup command
-	if err := executeCommand(cmd); err != nil {
-		return fmt.Errorf("failed to create backup: %v", err)
+	return databases, rows.Err()
+}
+
+// ExportUsersAndGrants returns SQL statements recreating every panel
+// database user with its password hash and grants
+func ExportUsersAndGrants() (string, error) {
+	users, err := ListUsers()
+	if err != nil {
+		return "", err
 	}
 
+	var b strings.Builder
+	for _, user := range users {
+		var create string
+		row := db.QueryRow(fmt.Sprintf("SHOW CREATE USER '%s'@'localhost'", user))
+		if err := row.Scan(&create); err != nil {
+			return "", fmt.Errorf("failed to export user %s: %v", user, err)
+		}
+		create = strings.Replace(create, "CREATE USER ", "CREATE USER IF NOT EXISTS ", 1)
+		fmt.Fprintf(&b, "%s;\n", create)
+
+		rows, err := db.Query(fmt.Sprintf("SHOW GRANTS FOR '%s'@'localhost'", user))
+		if err != nil {
+			return "", fmt.Errorf("failed to export grants of %s: %v", user, err)
+		}
+		for rows.Next() {
+			var grant string
+			if err := rows.Scan(&grant); err != nil {
+				rows.Close()
+				return "", fmt.Errorf("failed to scan grant: %v", err)
+			}
+			fmt.Fprintf(&b, "%s;\n", grant)
+		}
+		rows.Close()
+	}
+	b.WriteString("FLUSH PRIVILEGES;\n")
+
+	return b.String(), nil
+}
+
+// ExecSQL runs SQL statements read from r with the mysql client
+func ExecSQL(r io.Reader) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("mysql", "-u", "root")
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return commandError("failed to execute SQL", err, stderr.String())
+	}
 	return nil
 }
 
-// Helper function to execute shell commands
-func executeCommand(command string) error {
-	return nil // TODO: Implement actual command execution
+// Dump writes a consistent SQL dump of the specified database to w
+func Dump(name string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("mysqldump", "-u", "root",
+		"--single-transaction", "--quick", "--routines", "--triggers", name)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return commandError("mysqldump failed", err, stderr.String())
+	}
+
+	return nil
+}
+
+// Restore creates the specified database if needed and loads an SQL dump
+// read from r into it
+func Restore(name string, r io.Reader) error {
+	l, err := lock.Database(name)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	var stderr bytes.Buffer
+	create := exec.Command("mysql", "-u", "root", "-e",
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", name))
+	create.Stderr = &stderr
+	if err := create.Run(); err != nil {
+		return commandError("failed to create database", err, stderr.String())
+	}
+
+	stderr.Reset()
+	cmd := exec.Command("mysql", "-u", "root", name)
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return commandError("failed to import dump", err, stderr.String())
+	}
+
+	return nil
+}
+
+// commandError formats the failure of an external command with its stderr
+func commandError(msg string, err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%s: %v: %s", msg, err, stderr)
+	}
+	return fmt.Errorf("%s: %v", msg, err)
 }
@@ -0,0 +1,97 @@
+// Package doctor diagnoses the server layout and the services the panel
+// relies on, and repairs what is safe to repair
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// Severities of a finding
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Finding is a problem found by a check
+type Finding struct {
+	Severity string
+	Message  string
+	Hint     string       // how to remediate
+	Fix      func() error // nil when it must be repaired by hand
+}
+
+// Check is one area of the diagnosis
+type Check struct {
+	Name string
+	Run  func() []Finding
+}
+
+// Checks returns the checks in the order they run. The layout comes first
+// so its repairs are in place when Caddy validates.
+func Checks() []Check {
+	return []Check{
+		{Name: "directories", Run: checkDirectories},
+		{Name: "sites", Run: checkSites},
+		{Name: "modules", Run: checkModules},
+		{Name: "caddy", Run: checkCaddy},
+		{Name: "mariadb", Run: checkDatabase},
+		{Name: "php-fpm", Run: checkPHPFPM},
+		{Name: "backups", Run: checkBackups},
+		{Name: "ports", Run: checkPorts},
+		{Name: "dns", Run: checkDNS},
+	}
+}
+
+func errorf(msg, hint string, fix func() error) Finding {
+	return Finding{Severity: SeverityError, Message: msg, Hint: hint, Fix: fix}
+}
+
+func warningf(msg, hint string, fix func() error) Finding {
+	return Finding{Severity: SeverityWarning, Message: msg, Hint: hint, Fix: fix}
+}
+
+// snippetPattern matches the start of a snippet definition, e.g.
+// "(php_config) {"
+var snippetPattern = regexp.MustCompile(`^\(([^)\s]+)\)\s*\{`)
+
+// snippets returns the snippets defined by the module configurations and
+// the global Caddyfile, with the file defining each
+func snippets() map[string]string {
+	files, _ := filepath.Glob(filepath.Join(config.GetConfigDir(), "modules", "*.conf"))
+	files = append(files, caddy.GetCaddyfilePath())
+
+	defined := make(map[string]string)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if m := snippetPattern.FindStringSubmatch(line); m != nil {
+				defined[m[1]] = path
+			}
+		}
+	}
+	return defined
+}
+
+// siteConfigs returns the domains of the site configurations
+func siteConfigs() []string {
+	files, _ := filepath.Glob(filepath.Join(config.GetConfigDir(), "sites", "*.conf"))
+	domains := make([]string, 0, len(files))
+	for _, path := range files {
+		domains = append(domains, strings.TrimSuffix(filepath.Base(path), ".conf"))
+	}
+	return domains
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
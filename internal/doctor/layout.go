@@ -0,0 +1,186 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/lock"
+	"github.com/doko/cli-webpanel/internal/module"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// checkDirectories checks the directories of config.Init. The panel
+// configuration must be owned by root, the web root and backups are handed
+// to the web server user by the installer. None may be writable by every
+// user.
+func checkDirectories() []Finding {
+	shared := map[string]bool{config.GetWebRoot(): true, config.GetBackupDir(): true}
+
+	var findings []Finding
+	for _, dir := range config.RequiredDirectories() {
+		dir := dir
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			findings = append(findings, errorf(fmt.Sprintf("directory %s is missing", dir),
+				"create it, or run \"webpanel doctor --fix\"",
+				func() error { return os.MkdirAll(dir, 0755) }))
+			continue
+		}
+		if err != nil {
+			findings = append(findings, errorf(err.Error(), "check the permissions of its parent directories", nil))
+			continue
+		}
+		if !info.IsDir() {
+			findings = append(findings, errorf(fmt.Sprintf("%s is not a directory", dir),
+				"move the file away and run \"webpanel doctor --fix\"", nil))
+			continue
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && !shared[dir] {
+			findings = append(findings, errorf(fmt.Sprintf("%s is owned by uid %d instead of root", dir, st.Uid),
+				fmt.Sprintf("only root may change the panel configuration: chown root:root %s", dir),
+				func() error { return os.Chown(dir, 0, 0) }))
+		}
+		if mode := info.Mode().Perm(); mode&0002 != 0 {
+			findings = append(findings, errorf(fmt.Sprintf("%s is writable by every user (%04o)", dir, mode),
+				fmt.Sprintf("chmod o-w %s", dir),
+				func() error { return os.Chmod(dir, mode&^0002) }))
+		}
+	}
+	return findings
+}
+
+// checkSites matches the site configurations with the web roots and checks
+// the log directories of the sites
+func checkSites() []Finding {
+	var findings []Finding
+
+	configured := make(map[string]bool)
+	for _, domain := range siteConfigs() {
+		domain := domain
+		configured[domain] = true
+
+		if root := site.PublicDir(domain); !exists(config.GetSiteDirectory(domain)) {
+			findings = append(findings, errorf(
+				fmt.Sprintf("site %s has a configuration but no web root %s", domain, config.GetSiteDirectory(domain)),
+				fmt.Sprintf("restore the web root from a backup, or remove the site with \"webpanel site rm %s\"", domain),
+				func() error { return os.MkdirAll(root, 0755) }))
+		}
+
+		if logDir := config.GetSiteLogDirectory(domain); !exists(logDir) {
+			findings = append(findings, errorf(
+				fmt.Sprintf("log directory %s of site %s is missing", logDir, domain),
+				"the access_log and error_log modules write there, create it",
+				func() error { return os.MkdirAll(logDir, 0755) }))
+		}
+	}
+
+	domains, err := site.List()
+	if err != nil {
+		return append(findings, errorf(fmt.Sprintf("failed to list web roots: %v", err), "see the directories check", nil))
+	}
+	for _, domain := range domains {
+		if !configured[domain] {
+			findings = append(findings, warningf(
+				fmt.Sprintf("web root %s has no site configuration", config.GetSiteDirectory(domain)),
+				"recreate the configuration with \"webpanel apply\" or from a backup, or remove the directory",
+				nil))
+		}
+	}
+	return findings
+}
+
+// checkModules flags imports of site configurations that name snippets no
+// module defines
+func checkModules() []Finding {
+	defined := snippets()
+
+	var findings []Finding
+	for _, domain := range siteConfigs() {
+		domain := domain
+		cfg, err := site.LoadConfig(domain)
+		if err != nil {
+			findings = append(findings, errorf(err.Error(),
+				"fix the file, or restore it with \"webpanel history\" and \"webpanel rollback\"", nil))
+			continue
+		}
+
+		for _, line := range cfg.Lines {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "import" {
+				continue
+			}
+			name := fields[1]
+			// Defined snippets, and imports of files rather than snippets
+			if _, ok := defined[name]; ok || strings.ContainsAny(name, "/*.") {
+				continue
+			}
+			msg := fmt.Sprintf("site %s imports %s, which no module defines", domain, name)
+
+			m, ok := module.ForImport(name)
+			switch {
+			case !ok && strings.HasPrefix(name, "php") && strings.HasSuffix(name, "_config"):
+				findings = append(findings, errorf(msg,
+					"install the PHP version with \"webpanel php install <version>\"", nil))
+			case !ok:
+				findings = append(findings, errorf(msg, "remove the import or add a module defining the snippet", nil))
+			case defined[m.Snippet()] != "":
+				snippet := m.Snippet()
+				findings = append(findings, errorf(msg,
+					fmt.Sprintf("the import names the %s module instead of its snippet %s", m.Name, snippet),
+					func() error { return renameImport(domain, name, snippet) }))
+			default:
+				moduleName := m.Name
+				findings = append(findings, errorf(msg,
+					fmt.Sprintf("the configuration of the %s module is missing, write it again", moduleName),
+					func() error {
+						// Another finding may have written it already
+						if _, ok := snippets()[m.Snippet()]; ok {
+							return nil
+						}
+						return module.WriteConfig(moduleName)
+					}))
+			}
+		}
+	}
+	return findings
+}
+
+// renameImport replaces an import of a site configuration
+func renameImport(domain, from, to string) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	cfg, err := site.LoadConfig(domain)
+	if err != nil {
+		return err
+	}
+	for i, line := range cfg.Lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "import" && fields[1] == from {
+			fields[1] = to
+			cfg.Lines[i] = strings.Join(fields, " ")
+		}
+	}
+	return site.SaveConfig(domain, cfg)
+}
+
+// checkCaddy validates the Caddy configuration
+func checkCaddy() []Finding {
+	if _, err := exec.LookPath("caddy"); err != nil {
+		return []Finding{errorf("caddy is not installed", "install it with \"apt-get install caddy\"", nil)}
+	}
+	if err := caddy.Validate(); err != nil {
+		return []Finding{errorf(err.Error(),
+			"fix the reported file, or undo the last change with \"webpanel history\" and \"webpanel rollback\"", nil)}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+const (
+	// databaseAddress is where the panel connects to MariaDB
+	databaseAddress = "127.0.0.1:3306"
+
+	// databaseSocket is the socket of the mysql client used for dumps
+	databaseSocket = "/run/mysqld/mysqld.sock"
+
+	dialTimeout   = 2 * time.Second
+	lookupTimeout = 3 * time.Second
+)
+
+// fastcgiPattern matches the PHP-FPM socket of a php_fastcgi directive
+var fastcgiPattern = regexp.MustCompile(`php_fastcgi\s+unix/(/\S+)`)
+
+func reachable(network, address string) error {
+	conn, err := net.DialTimeout(network, address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// startUnit returns a fix starting a systemd unit that is not running
+func startUnit(name string) func() error {
+	return func() error {
+		if monitoring.ServiceStatus(name) == "active" {
+			return fmt.Errorf("%s is running but not reachable, see \"webpanel service status %s\"", name, name)
+		}
+		return service.Control("start", name)
+	}
+}
+
+// checkDatabase checks that MariaDB accepts connections on the address the
+// panel uses and on the socket of the mysql client
+func checkDatabase() []Finding {
+	unit := config.GetDatabaseConfig().ServiceName
+	hint := fmt.Sprintf("start MariaDB with \"systemctl start %s\" and see \"webpanel service status %s\"", unit, unit)
+
+	var findings []Finding
+	if err := reachable("tcp", databaseAddress); err != nil {
+		findings = append(findings, errorf(fmt.Sprintf("MariaDB is not reachable on %s: %v", databaseAddress, err),
+			hint, startUnit(unit)))
+	}
+	if err := reachable("unix", databaseSocket); err != nil {
+		findings = append(findings, errorf(fmt.Sprintf("MariaDB socket %s is not reachable: %v", databaseSocket, err),
+			hint, startUnit(unit)))
+	}
+	return findings
+}
+
+// checkPHPFPM checks the PHP-FPM sockets of the snippets sites import
+func checkPHPFPM() []Finding {
+	sockets := make(map[string]string) // snippet to socket
+	for snippet, path := range snippets() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// The snippet body follows its definition
+		text := string(data)
+		if i := strings.Index(text, "("+snippet+")"); i >= 0 {
+			body := text[i:]
+			if end := strings.Index(body, "\n}"); end >= 0 {
+				body = body[:end]
+			}
+			if m := fastcgiPattern.FindStringSubmatch(body); m != nil {
+				sockets[snippet] = m[1]
+			}
+		}
+	}
+
+	used := make(map[string][]string) // socket to sites
+	for _, domain := range siteConfigs() {
+		cfg, err := site.LoadConfig(domain)
+		if err != nil {
+			continue
+		}
+		for _, line := range cfg.Lines {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "import" && sockets[fields[1]] != "" {
+				used[sockets[fields[1]]] = append(used[sockets[fields[1]]], domain)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(used))
+	for path := range used {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var findings []Finding
+	for _, path := range paths {
+		if err := reachable("unix", path); err == nil {
+			continue
+		}
+		// php8.1-fpm.sock is served by the php8.1-fpm unit
+		unit := strings.TrimSuffix(filepath.Base(path), ".sock")
+		var fix func() error
+		version := strings.TrimSuffix(strings.TrimPrefix(unit, "php"), "-fpm")
+		hint := fmt.Sprintf("install PHP %s with \"webpanel php install %s\", or change the php module of the sites", version, version)
+		for _, installed := range service.PHPFPMUnits() {
+			if installed == unit {
+				hint = fmt.Sprintf("start it with \"systemctl start %s\"", unit)
+				fix = startUnit(unit)
+			}
+		}
+		findings = append(findings, errorf(
+			fmt.Sprintf("PHP-FPM socket %s used by %s is not reachable", path, strings.Join(used[path], ", ")),
+			hint, fix))
+	}
+	return findings
+}
+
+// checkBackups checks that backup schedules point at existing sites and
+// databases
+func checkBackups() []Finding {
+	schedules, err := backup.ListSchedules()
+	if err != nil {
+		return []Finding{errorf(fmt.Sprintf("failed to list backup schedules: %v", err), "see \"webpanel backup schedule list\"", nil)}
+	}
+
+	var databases map[string]bool
+	var findings []Finding
+	for _, s := range schedules {
+		if s.Kind == backup.KindSite {
+			if !exists(config.GetSiteDirectory(s.Target)) {
+				findings = append(findings, warningf(
+					fmt.Sprintf("%s backup schedule of site %s points at a missing site", s.Type, s.Target),
+					fmt.Sprintf("remove it with \"webpanel backup disable %s %s\"", s.Type, s.Target), nil))
+			}
+			continue
+		}
+
+		if databases == nil {
+			databases = make(map[string]bool)
+			if err := database.Initialize(); err != nil {
+				// Reported by the mariadb check
+				continue
+			}
+			names, err := database.ListDatabases()
+			database.Close()
+			if err != nil {
+				continue
+			}
+			for _, name := range names {
+				databases[name] = true
+			}
+		}
+		if len(databases) > 0 && !databases[s.Target] {
+			findings = append(findings, warningf(
+				fmt.Sprintf("%s backup schedule of database %s points at a missing database", s.Type, s.Target),
+				fmt.Sprintf("remove it with \"webpanel dbbackup disable %s %s\"", s.Type, s.Target), nil))
+		}
+	}
+	return findings
+}
+
+// checkPorts checks that Caddy accepts connections on the HTTP and HTTPS
+// ports
+func checkPorts() []Finding {
+	unit := config.GetWebServerConfig().ServiceName
+
+	var findings []Finding
+	for _, port := range []string{"80", "443"} {
+		if err := reachable("tcp", net.JoinHostPort("127.0.0.1", port)); err != nil {
+			findings = append(findings, errorf(fmt.Sprintf("nothing accepts connections on port %s", port),
+				fmt.Sprintf("start Caddy with \"systemctl start %s\" and see \"webpanel service status %s\"", unit, unit),
+				startUnit(unit)))
+		}
+	}
+	return findings
+}
+
+// checkDNS checks that the domains of the sites resolve to this server
+func checkDNS() []Finding {
+	local := make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				local[ipnet.IP.String()] = true
+			}
+		}
+	}
+
+	var findings []Finding
+	for _, domain := range siteConfigs() {
+		cfg, err := site.LoadConfig(domain)
+		if err != nil {
+			continue
+		}
+		for _, address := range cfg.Addresses {
+			host := hostOf(address)
+			if host == "" {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+			ips, err := net.DefaultResolver.LookupHost(ctx, host)
+			cancel()
+			if err != nil {
+				findings = append(findings, warningf(fmt.Sprintf("%s does not resolve", host),
+					"add an A or AAAA record pointing to this server, Caddy cannot obtain a certificate before", nil))
+				continue
+			}
+
+			here := false
+			for _, ip := range ips {
+				here = here || local[ip]
+			}
+			if !here {
+				findings = append(findings, warningf(
+					fmt.Sprintf("%s resolves to %s, not to an address of this server", host, strings.Join(ips, ", ")),
+					"update the DNS records, unless the server is behind NAT or a proxy", nil))
+			}
+		}
+	}
+	return findings
+}
+
+// hostOf returns the host name of a site address, or "" for addresses
+// without one such as ":8080", IP addresses and wildcards
+func hostOf(address string) string {
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if address == "" || address == "localhost" || strings.Contains(address, "*") || net.ParseIP(address) != nil {
+		return ""
+	}
+	return address
+}
@@ -0,0 +1,302 @@
+// Package exporter serves the panel's system, site, database and backup
+// statistics in the Prometheus text format.
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+	"github.com/doko/cli-webpanel/internal/service"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// DefaultListen is the address served when none is given
+const DefaultListen = "127.0.0.1:9813"
+
+// slowInterval is how long the results of expensive collectors, which walk
+// site directories or query MariaDB, are reused between scrapes
+const slowInterval = 5 * time.Minute
+
+// Exporter gathers the metrics for every scrape. Scrapes are serialized,
+// so rates are computed between consecutive scrapes.
+type Exporter struct {
+	collector *monitoring.Collector
+	logs      *monitoring.AccessLogWatcher
+
+	mu       sync.Mutex
+	prev     *monitoring.Snapshot
+	slow     *registry
+	slowAt   time.Time
+	dbOpened bool
+}
+
+// New returns an exporter for the watched directories of the panel
+func New() *Exporter {
+	collector := monitoring.NewCollector()
+	collector.Paths = config.GetManagedDirectories()
+	collector.Services = service.Monitored()
+
+	return &Exporter{
+		collector: collector,
+		logs:      monitoring.NewAccessLogWatcher(nil),
+	}
+}
+
+// Handler returns the HTTP handler serving /metrics
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><head><title>webpanel exporter</title></head><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+	return mux
+}
+
+// ServeHTTP writes the metrics of one scrape
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg := e.scrape()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	reg.write(w)
+}
+
+// scrape runs every collector. Failing collectors are reported through
+// webpanel_exporter_collector_success instead of failing the scrape.
+func (e *Exporter) scrape() *registry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	reg := newRegistry()
+
+	e.run(reg, "system", e.collectSystem)
+	e.run(reg, "access_logs", e.collectAccessLogs)
+	e.run(reg, "backups", func(reg *registry) error { return collectBackups(reg, now) })
+
+	if e.slow == nil || now.Sub(e.slowAt) >= slowInterval {
+		e.slow = newRegistry()
+		e.slowAt = now
+		e.run(e.slow, "sites", collectSites)
+		e.run(e.slow, "certificates", collectCertificates)
+		e.run(e.slow, "databases", e.collectDatabases)
+	}
+	reg.merge(e.slow)
+
+	return reg
+}
+
+// run calls a collector and records whether it succeeded and how long it
+// took
+func (e *Exporter) run(reg *registry, name string, collect func(*registry) error) {
+	start := time.Now()
+	err := collect(reg)
+
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	reg.gauge(metricCollectorSuccess, "Whether the last run of a collector succeeded.", success, "collector", name)
+	reg.gauge(metricCollectorDuration, "How long the last run of a collector took.", time.Since(start).Seconds(), "collector", name)
+}
+
+// collectSystem adds the SystemStats values. Rates cover the time since
+// the previous scrape.
+func (e *Exporter) collectSystem(reg *registry) error {
+	// The first scrape samples over the collector interval
+	if e.prev == nil {
+		prev, err := e.collector.Snapshot()
+		if err != nil {
+			return err
+		}
+		e.prev = prev
+		time.Sleep(e.collector.Interval)
+	}
+
+	cur, err := e.collector.Snapshot()
+	if err != nil {
+		return err
+	}
+	stats, err := e.collector.CollectBetween(e.prev, cur)
+	e.prev = cur
+	if err != nil {
+		return err
+	}
+
+	reg.gauge(metricCPUUsage, "CPU usage of all cores in percent.", stats.CPU)
+	for i, usage := range stats.Cores {
+		reg.gauge(metricCPUCoreUsage, "CPU usage of one core in percent.", usage, "core", strconv.Itoa(i))
+	}
+
+	loadHelp := "System load average."
+	reg.gauge(metricLoadAverage, loadHelp, stats.Load.Load1, "period", "1m")
+	reg.gauge(metricLoadAverage, loadHelp, stats.Load.Load5, "period", "5m")
+	reg.gauge(metricLoadAverage, loadHelp, stats.Load.Load15, "period", "15m")
+
+	reg.gauge(metricMemoryTotal, "Total memory.", float64(stats.Memory.Total))
+	reg.gauge(metricMemoryUsed, "Memory in use, without reclaimable buffers and cache.", float64(stats.Memory.Used))
+	reg.gauge(metricMemoryAvailable, "Memory available for new processes.", float64(stats.Memory.Available))
+	reg.gauge(metricSwapTotal, "Total swap space.", float64(stats.Memory.SwapTotal))
+	reg.gauge(metricSwapUsed, "Swap space in use.", float64(stats.Memory.SwapUsed))
+	reg.gauge(metricUptime, "Time since boot.", stats.Uptime.Seconds())
+
+	for _, m := range stats.Mounts {
+		labels := []string{"mountpoint", m.MountPoint, "device", m.Device, "fstype", m.FSType}
+		reg.gauge(metricFSSize, "Size of a file system holding panel data.", float64(m.Used+m.Free), labels...)
+		reg.gauge(metricFSUsed, "Used space of a file system, including blocks reserved for root.", float64(m.Used), labels...)
+		reg.gauge(metricFSAvailable, "Space of a file system available to unprivileged users.", float64(m.Free), labels...)
+		reg.gauge(metricFSInodes, "Inodes of a file system.", float64(m.Inodes), labels...)
+		reg.gauge(metricFSInodesUsed, "Used inodes of a file system.", float64(m.InodesUsed), labels...)
+	}
+
+	for _, d := range stats.DiskIO {
+		reg.gauge(metricDiskRead, "Bytes read from a block device per second.", d.ReadBytesSec, "device", d.Device)
+		reg.gauge(metricDiskWrite, "Bytes written to a block device per second.", d.WriteBytesSec, "device", d.Device)
+		reg.gauge(metricDiskUtil, "Share of time a block device was busy in percent.", d.UtilizationPct, "device", d.Device)
+	}
+
+	for _, iface := range stats.Network {
+		reg.counter(metricNetRxBytes, "Bytes received by a network interface.", float64(iface.RxBytes), "interface", iface.Name)
+		reg.counter(metricNetTxBytes, "Bytes sent by a network interface.", float64(iface.TxBytes), "interface", iface.Name)
+		reg.counter(metricNetRxPackets, "Packets received by a network interface.", float64(iface.RxPackets), "interface", iface.Name)
+		reg.counter(metricNetTxPackets, "Packets sent by a network interface.", float64(iface.TxPackets), "interface", iface.Name)
+		reg.counter(metricNetRxErrors, "Receive errors of a network interface.", float64(iface.RxErrors), "interface", iface.Name)
+		reg.counter(metricNetTxErrors, "Transmit errors of a network interface.", float64(iface.TxErrors), "interface", iface.Name)
+	}
+
+	for state, count := range stats.TCP.States {
+		reg.gauge(metricTCPConnections, "TCP connections by state.", float64(count), "state", state)
+	}
+	for port, count := range stats.TCP.Established {
+		reg.gauge(metricTCPEstablished, "Established TCP connections to a local port.", float64(count), "port", strconv.Itoa(port))
+	}
+
+	for service, status := range stats.Services {
+		up := 0.0
+		if status == "active" {
+			up = 1
+		}
+		reg.gauge(metricServiceUp, "Whether a service is active.", up, "service", service)
+	}
+
+	return nil
+}
+
+// collectAccessLogs adds the request counters of every site. Counting
+// starts when the exporter starts.
+func (e *Exporter) collectAccessLogs(reg *registry) error {
+	domains, err := site.List()
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		e.logs.Add(domain, filepath.Join(config.GetSiteLogDirectory(domain), "access.log"))
+	}
+
+	e.logs.Poll()
+	for domain, codes := range e.logs.Totals() {
+		for code, count := range codes {
+			reg.counter(metricSiteRequests, "HTTP requests served for a site by status code.", float64(count),
+				"site", domain, "code", strconv.Itoa(code))
+		}
+	}
+	return nil
+}
+
+// collectBackups adds the state of the newest backup of every target
+func collectBackups(reg *registry, now time.Time) error {
+	statuses, err := backup.Status(now)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		labels := []string{"kind", s.Kind, "target", s.Target}
+		if s.LastSuccess != nil {
+			reg.gauge(metricBackupLastSuccess, "Time the last successful backup finished.",
+				float64(s.LastSuccess.FinishedAt.Unix()), labels...)
+			reg.gauge(metricBackupAge, "Time since the last successful backup.", s.Age(now).Seconds(), labels...)
+		}
+		if s.LastSuccess != nil || s.LastFailure != nil {
+			success := 1.0
+			if s.LastFailure != nil {
+				success = 0
+			}
+			reg.gauge(metricBackupSuccess, "Whether the last backup run succeeded.", success, labels...)
+		}
+		stale := 0.0
+		if s.Stale {
+			stale = 1
+		}
+		reg.gauge(metricBackupStale, "Whether a scheduled backup is overdue.", stale, labels...)
+	}
+	return nil
+}
+
+// collectSites adds the disk usage of every site
+func collectSites(reg *registry) error {
+	domains, err := site.List()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, domain := range domains {
+		size, err := site.DiskUsage(domain)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reg.gauge(metricSiteDiskUsage, "Size of the files of a site.", float64(size), "site", domain)
+	}
+	return lastErr
+}
+
+// collectCertificates adds the expiry of the certificate of every site.
+// Sites without a certificate, e.g. served over plain HTTP, are skipped.
+func collectCertificates(reg *registry) error {
+	domains, err := site.List()
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range domains {
+		expiry, err := caddy.CertificateExpiry(domain)
+		if err != nil {
+			continue
+		}
+		reg.gauge(metricTLSCertExpiry, "Time the TLS certificate of a site expires.", float64(expiry.Unix()), "site", domain)
+	}
+	return nil
+}
+
+// collectDatabases adds the size of every database
+func (e *Exporter) collectDatabases(reg *registry) error {
+	if !e.dbOpened {
+		if err := database.Initialize(); err != nil {
+			return err
+		}
+		e.dbOpened = true
+	}
+
+	sizes, err := database.Sizes()
+	if err != nil {
+		return err
+	}
+	for name, size := range sizes {
+		reg.gauge(metricDatabaseSize, "Size of the data and indexes of a database.", float64(size), "database", name)
+	}
+	return nil
+}
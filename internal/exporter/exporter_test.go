@@ -0,0 +1,180 @@
+package exporter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+// testExporter returns an exporter reading the procfs fixtures of the
+// monitoring package, for a panel without sites
+func testExporter(t *testing.T) *Exporter {
+	t.Helper()
+	root := t.TempDir()
+	cfg := &config.Config{
+		WebRoot:   filepath.Join(root, "sites"),
+		ConfigDir: filepath.Join(root, "etc"),
+		BackupDir: filepath.Join(root, "backup"),
+		LogDir:    filepath.Join(root, "log"),
+	}
+	for _, dir := range []string{cfg.WebRoot, cfg.ConfigDir, cfg.BackupDir, cfg.LogDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.SetConfig(cfg)
+
+	collector := monitoring.NewCollector()
+	collector.ProcRoot = filepath.Join("..", "monitoring", "testdata", "proc")
+	collector.Interval = time.Millisecond
+	collector.Paths = nil
+	collector.Services = nil
+
+	return &Exporter{
+		collector: collector,
+		logs:      monitoring.NewAccessLogWatcher(nil),
+		// Skip the slow collectors, they query MariaDB
+		slow:   newRegistry(),
+		slowAt: time.Now(),
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := httptest.NewServer(testExporter(t).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	text := string(body)
+	for _, want := range []string{
+		"# HELP " + metricCPUUsage + " CPU usage of all cores in percent.\n",
+		"# TYPE " + metricCPUUsage + " gauge\n",
+		"# TYPE " + metricNetRxBytes + " counter\n",
+		metricCollectorSuccess + `{collector="system"} 1` + "\n",
+		metricCollectorSuccess + `{collector="backups"} 1` + "\n",
+		metricLoadAverage + `{period="1m"} 0.52` + "\n",
+		metricLoadAverage + `{period="15m"} 0.25` + "\n",
+		metricMemoryTotal + " 2.097152e+09\n",
+		metricUptime + " 93784.25\n",
+		metricNetRxBytes + `{interface="eth0"} 9e+06` + "\n",
+		metricTCPConnections + `{state="ESTABLISHED"} 4` + "\n",
+		metricCollectorDuration + `{collector="access_logs"} `,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+
+	// Every family is announced once, before its samples
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if seen[name] {
+				t.Errorf("%s announced twice", name)
+			}
+			seen[name] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		if !seen[name] {
+			t.Errorf("sample before the TYPE line: %s", line)
+		}
+		if !strings.HasPrefix(name, "webpanel_") {
+			t.Errorf("metric outside the webpanel namespace: %s", line)
+		}
+	}
+}
+
+func TestIndexAndNotFound(t *testing.T) {
+	server := httptest.NewServer(testExporter(t).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `href="/metrics"`) {
+		t.Errorf("index: status %d, body %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(server.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/other: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestRegistryWrite(t *testing.T) {
+	reg := newRegistry()
+	reg.gauge("test_b", "Second family.", 2, "site", "b.example")
+	reg.gauge("test_b", "Second family.", 1, "site", "a.example")
+	reg.counter("test_a", "Help with a \\ backslash\nand a newline.", 3)
+	reg.gauge("test_c", "Escaped labels.", 0.5,
+		"path", `C:\sites`, "quote", `say "hi"`, "text", "two\nlines")
+
+	var b strings.Builder
+	if err := reg.write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_a Help with a \\ backslash\nand a newline.
+# TYPE test_a counter
+test_a 3
+# HELP test_b Second family.
+# TYPE test_b gauge
+test_b{site="a.example"} 1
+test_b{site="b.example"} 2
+# HELP test_c Escaped labels.
+# TYPE test_c gauge
+test_c{path="C:\\sites",quote="say \"hi\"",text="two\nlines"} 0.5
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := map[float64]string{
+		0:       "0",
+		1.5:     "1.5",
+		1e15:    "1e+15",
+		1234567: "1.234567e+06",
+	}
+	for v, want := range tests {
+		if got := formatValue(v); got != want {
+			t.Errorf("formatValue(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric names served on /metrics. Names and labels are part of the
+// interface to dashboards and alert rules, so existing ones must not be
+// renamed.
+const (
+	// System, from /proc and statfs
+	metricCPUUsage        = "webpanel_cpu_usage_percent"              // gauge
+	metricCPUCoreUsage    = "webpanel_cpu_core_usage_percent"         // gauge {core}
+	metricLoadAverage     = "webpanel_load_average"                   // gauge {period="1m|5m|15m"}
+	metricMemoryTotal     = "webpanel_memory_total_bytes"             // gauge
+	metricMemoryUsed      = "webpanel_memory_used_bytes"              // gauge, without buffers and cache
+	metricMemoryAvailable = "webpanel_memory_available_bytes"         // gauge
+	metricSwapTotal       = "webpanel_swap_total_bytes"               // gauge
+	metricSwapUsed        = "webpanel_swap_used_bytes"                // gauge
+	metricUptime          = "webpanel_uptime_seconds"                 // gauge
+	metricFSSize          = "webpanel_filesystem_size_bytes"          // gauge {mountpoint,device,fstype}
+	metricFSUsed          = "webpanel_filesystem_used_bytes"          // gauge {mountpoint,device,fstype}
+	metricFSAvailable     = "webpanel_filesystem_avail_bytes"         // gauge {mountpoint,device,fstype}
+	metricFSInodes        = "webpanel_filesystem_inodes"              // gauge {mountpoint,device,fstype}
+	metricFSInodesUsed    = "webpanel_filesystem_inodes_used"         // gauge {mountpoint,device,fstype}
+	metricDiskRead        = "webpanel_disk_read_bytes_per_second"     // gauge {device}
+	metricDiskWrite       = "webpanel_disk_write_bytes_per_second"    // gauge {device}
+	metricDiskUtil        = "webpanel_disk_utilization_percent"       // gauge {device}
+	metricNetRxBytes      = "webpanel_network_receive_bytes_total"    // counter {interface}
+	metricNetTxBytes      = "webpanel_network_transmit_bytes_total"   // counter {interface}
+	metricNetRxPackets    = "webpanel_network_receive_packets_total"  // counter {interface}
+	metricNetTxPackets    = "webpanel_network_transmit_packets_total" // counter {interface}
+	metricNetRxErrors     = "webpanel_network_receive_errors_total"   // counter {interface}
+	metricNetTxErrors     = "webpanel_network_transmit_errors_total"  // counter {interface}
+	metricTCPConnections  = "webpanel_tcp_connections"                // gauge {state}
+	metricTCPEstablished  = "webpanel_tcp_established_connections"    // gauge {port}
+
+	// Services and sites
+	metricServiceUp     = "webpanel_service_up"                               // gauge {service}, 1 when active
+	metricSiteDiskUsage = "webpanel_site_disk_usage_bytes"                    // gauge {site}
+	metricSiteRequests  = "webpanel_site_http_requests_total"                 // counter {site,code}
+	metricTLSCertExpiry = "webpanel_tls_certificate_expiry_timestamp_seconds" // gauge {site}
+	metricDatabaseSize  = "webpanel_database_size_bytes"                      // gauge {database}
+
+	// Backups
+	metricBackupLastSuccess = "webpanel_backup_last_success_timestamp_seconds" // gauge {kind,target}
+	metricBackupAge         = "webpanel_backup_age_seconds"                    // gauge {kind,target}
+	metricBackupSuccess     = "webpanel_backup_last_run_success"               // gauge {kind,target}, 0 after a failed run
+	metricBackupStale       = "webpanel_backup_stale"                          // gauge {kind,target}
+
+	// Exporter
+	metricCollectorSuccess  = "webpanel_exporter_collector_success"          // gauge {collector}
+	metricCollectorDuration = "webpanel_exporter_collector_duration_seconds" // gauge {collector}
+)
+
+// sample is one value of a metric with its labels as name/value pairs
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// family holds the samples of one metric
+type family struct {
+	name    string
+	help    string
+	kind    string // gauge or counter
+	samples []sample
+}
+
+// registry collects the metric families of one scrape
+type registry struct {
+	families map[string]*family
+}
+
+func newRegistry() *registry {
+	return &registry{families: make(map[string]*family)}
+}
+
+// add records a sample. labels alternate between names and values.
+func (r *registry) add(name, kind, help string, value float64, labels ...string) {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, kind: kind}
+		r.families[name] = f
+	}
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+func (r *registry) gauge(name, help string, value float64, labels ...string) {
+	r.add(name, "gauge", help, value, labels...)
+}
+
+func (r *registry) counter(name, help string, value float64, labels ...string) {
+	r.add(name, "counter", help, value, labels...)
+}
+
+// merge adds the samples of another registry
+func (r *registry) merge(other *registry) {
+	for _, f := range other.families {
+		for _, s := range f.samples {
+			r.add(f.name, f.kind, f.help, s.value, s.labels...)
+		}
+	}
+}
+
+// write renders every family in the Prometheus text format, sorted by name
+func (r *registry) write(w io.Writer) error {
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		sort.SliceStable(f.samples, func(i, j int) bool {
+			return strings.Join(f.samples[i].labels, "\x00") < strings.Join(f.samples[j].labels, "\x00")
+		})
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.samples {
+			b.WriteString(f.name)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i := 0; i+1 < len(s.labels); i += 2 {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", s.labels[i], escapeLabel(s.labels[i+1]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(s.value))
+			b.WriteByte('\n')
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
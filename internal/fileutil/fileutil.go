@@ -0,0 +1,123 @@
+// Package fileutil writes files so readers never see them half written
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Original is a file as it was before this process first changed it
+type Original struct {
+	Path   string
+	Data   []byte
+	Mode   os.FileMode
+	Exists bool
+}
+
+var (
+	journalMu    sync.Mutex
+	journalMatch func(path string) bool
+	journal      = make(map[string]Original)
+)
+
+// Journal makes WriteFile, Remove and Touch keep the original content of
+// the files accepted by match before this process first changes them, so
+// its own changes can be told from those of other processes
+func Journal(match func(path string) bool) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	journalMatch = match
+}
+
+// Originals returns the journaled files ordered by path
+func Originals() []Original {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	originals := make([]Original, 0, len(journal))
+	for _, o := range journal {
+		originals = append(originals, o)
+	}
+	sort.Slice(originals, func(i, j int) bool { return originals[i].Path < originals[j].Path })
+	return originals
+}
+
+// Touch journals a file the caller is about to change without WriteFile
+// or Remove
+func Touch(path string) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	path = filepath.Clean(path)
+	if journalMatch == nil || !journalMatch(path) {
+		return
+	}
+	if _, ok := journal[path]; ok {
+		return
+	}
+
+	o := Original{Path: path}
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		if data, err := os.ReadFile(path); err == nil {
+			o.Data, o.Mode, o.Exists = data, info.Mode().Perm(), true
+		}
+	}
+	journal[path] = o
+}
+
+// Remove removes a file like os.Remove, journaling it first
+func Remove(path string) error {
+	Touch(path)
+	return os.Remove(path)
+}
+
+// WriteFile replaces a file atomically: the data goes to a temporary file
+// in the same directory, which is synced and renamed over the file. After
+// a crash the file holds either the old or the new content.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	Touch(path)
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Removing fails harmlessly once the rename succeeded
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in a directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", dir, err)
+	}
+	return nil
+}
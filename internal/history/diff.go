@@ -0,0 +1,163 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// Diff returns the change to a file as a unified diff
+func (f File) Diff() string {
+	var before, after []string
+	from, to := "a"+f.Path, "b"+f.Path
+	if f.Before != nil {
+		before = splitLines(*f.Before)
+	} else {
+		from = "/dev/null"
+	}
+	if f.After != nil {
+		after = splitLines(*f.After)
+	} else {
+		to = "/dev/null"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+	for _, h := range hunks(editScript(before, after)) {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldLines), hunkRange(h.newStart, h.newLines))
+		for _, line := range h.lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edit is one line of an edit script: ' ' kept, '-' removed or '+' added
+type edit struct {
+	op   byte
+	line string
+}
+
+// editScript turns a into b through the longest common subsequence of
+// their lines. Configuration files are small enough for the quadratic
+// table.
+func editScript(a, b []string) []edit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+type hunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+	lines              []string
+}
+
+// hunks groups the changes of an edit script with their context lines
+func hunks(edits []edit) []hunk {
+	var result []hunk
+	var cur *hunk
+	oldLine, newLine := 1, 1
+	lastChange := -1
+
+	// closeHunk adds the context lines after the last change
+	closeHunk := func() {
+		end := min(lastChange+1+diffContext, len(edits))
+		for _, c := range edits[lastChange+1 : end] {
+			cur.add(c)
+		}
+		result = append(result, *cur)
+	}
+
+	for k, e := range edits {
+		if e.op != ' ' {
+			if cur == nil || k-lastChange > 2*diffContext+1 {
+				if cur != nil {
+					closeHunk()
+				}
+				start := max(k-diffContext, 0)
+				cur = &hunk{oldStart: oldLine - (k - start), newStart: newLine - (k - start)}
+				for _, c := range edits[start:k] {
+					cur.add(c)
+				}
+			} else {
+				for _, c := range edits[lastChange+1 : k] {
+					cur.add(c)
+				}
+			}
+			cur.add(e)
+			lastChange = k
+		}
+
+		if e.op != '+' {
+			oldLine++
+		}
+		if e.op != '-' {
+			newLine++
+		}
+	}
+	if cur != nil {
+		closeHunk()
+	}
+	return result
+}
+
+func (h *hunk) add(e edit) {
+	h.lines = append(h.lines, string(e.op)+e.line)
+	if e.op != '+' {
+		h.oldLines++
+	}
+	if e.op != '-' {
+		h.newLines++
+	}
+}
+
+// hunkRange formats the start and length of a hunk, where an empty range
+// starts at the line before it
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		start--
+	}
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
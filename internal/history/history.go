@@ -0,0 +1,369 @@
+// Package history keeps the configuration files changed by panel
+// operations, so an operation can be reviewed and rolled back. Web root
+// contents are not kept.
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
+	"github.com/doko/cli-webpanel/internal/logs"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+)
+
+// MaxEntries is the number of operations kept, older ones are removed
+const MaxEntries = 200
+
+// jobPrefix names the systemd units and crontab files the panel installs
+const jobPrefix = "webpanel-"
+
+// State holds the tracked files by path. Files that do not exist are left
+// out.
+type State map[string]content
+
+type content struct {
+	data []byte
+	mode os.FileMode
+}
+
+// File is a tracked file changed by an operation. A nil Before or After
+// means the file did not exist. Mode is the permission before the change.
+type File struct {
+	Path   string  `json:"path"`
+	Mode   uint32  `json:"mode"`
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// Entry is one operation that changed configuration files
+type Entry struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Command string    `json:"command"` // e.g. "site rm"
+	Args    []string  `json:"args"`    // command line with secrets redacted
+	Result  string    `json:"result"`
+	Files   []File    `json:"files"`
+}
+
+// Dir returns the directory holding the history entries
+func Dir() string {
+	return filepath.Join(config.GetConfigDir(), "history")
+}
+
+func entryPath(id int) string {
+	return filepath.Join(Dir(), fmt.Sprintf("%06d.json", id))
+}
+
+// patterns matches the tracked configuration files: site and module
+// configurations, the global Caddyfile, site metadata, the units and
+// crontab files of scheduled jobs and the logrotate configuration
+func patterns() []string {
+	configDir := config.GetConfigDir()
+	return []string{
+		filepath.Join(configDir, "global", "Caddyfile"),
+		filepath.Join(configDir, "sites", "*.conf"),
+		filepath.Join(configDir, "modules", "*.conf"),
+		filepath.Join(configDir, "meta", "*.json"),
+		filepath.Join(scheduler.DefaultUnitDir, jobPrefix+"*.service"),
+		filepath.Join(scheduler.DefaultUnitDir, jobPrefix+"*.timer"),
+		filepath.Join(scheduler.DefaultCronDir, jobPrefix+"*"),
+		logs.LogrotatePath,
+	}
+}
+
+// IsTracked reports whether path is a tracked configuration file
+func IsTracked(path string) bool {
+	for _, pattern := range patterns() {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Journaled returns the tracked files this process changed through
+// fileutil, as they were before its first change and as they are now. Other
+// processes changing other files at the same time are not included.
+func Journaled() (before, after State) {
+	before, after = make(State), make(State)
+	for _, o := range fileutil.Originals() {
+		if o.Exists {
+			before[o.Path] = content{data: o.Data, mode: o.Mode}
+		}
+		info, err := os.Stat(o.Path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if data, err := os.ReadFile(o.Path); err == nil {
+			after[o.Path] = content{data: data, mode: info.Mode().Perm()}
+		}
+	}
+	return before, after
+}
+
+// Changes compares two captures and returns the files that differ
+func Changes(before, after State) []File {
+	paths := make(map[string]bool)
+	for path := range before {
+		paths[path] = true
+	}
+	for path := range after {
+		paths[path] = true
+	}
+
+	var files []File
+	for path := range paths {
+		old, hadOld := before[path]
+		cur, hasCur := after[path]
+		if hadOld == hasCur && bytes.Equal(old.data, cur.data) {
+			continue
+		}
+
+		f := File{Path: path}
+		if hadOld {
+			f.Before, f.Mode = text(old.data), uint32(old.mode)
+		}
+		if hasCur {
+			f.After = text(cur.data)
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func text(data []byte) *string {
+	s := string(data)
+	return &s
+}
+
+// Save stores an entry under the next free ID and removes the oldest
+// entries beyond MaxEntries
+func Save(e *Entry) error {
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return err
+	}
+	// Concurrent runs would take the same ID
+	l, err := lock.Named("history")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	ids, err := ids()
+	if err != nil {
+		return err
+	}
+	e.ID = 1
+	if len(ids) > 0 {
+		e.ID = ids[len(ids)-1] + 1
+	}
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Site metadata and units may hold credentials, only root reads them
+	if err := fileutil.WriteFile(entryPath(e.ID), append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	for len(ids) >= MaxEntries {
+		os.Remove(entryPath(ids[0]))
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// ids returns the IDs of the stored entries in ascending order
+func ids() ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(Dir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, path := range matches {
+		if id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".json")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// Load reads an entry
+func Load(id int) (*Entry, error) {
+	data, err := os.ReadFile(entryPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no history entry %d", id)
+		}
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("invalid history entry %d: %v", id, err)
+	}
+	return &e, nil
+}
+
+// List returns the stored entries, oldest first
+func List() ([]*Entry, error) {
+	ids, err := ids()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, id := range ids {
+		e, err := Load(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Since returns the entry with an ID and every later entry, oldest first
+func Since(id int) ([]*Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if e.ID == id {
+			return entries[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("no history entry %d", id)
+}
+
+// Previous returns the state of every file changed by the entries as it was
+// before the first of them, so applying it undoes them all
+func Previous(entries []*Entry) []File {
+	seen := make(map[string]bool)
+	var files []File
+	for _, e := range entries {
+		for _, f := range e.Files {
+			if seen[f.Path] {
+				continue
+			}
+			seen[f.Path] = true
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// Apply writes the Before contents of files and removes those that did not
+// exist. Systemd units with an [Install] section are disabled before they
+// are removed and enabled again when restored.
+func Apply(files []File) error {
+	unitsChanged := false
+	for _, f := range files {
+		if filepath.Dir(f.Path) != scheduler.DefaultUnitDir {
+			continue
+		}
+		unitsChanged = true
+		if f.Before != nil || !installable(f.Path) {
+			continue
+		}
+		if err := scheduler.Systemctl("disable", "--now", filepath.Base(f.Path)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		if f.Before == nil {
+			if err := fileutil.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %v", f.Path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory of %s: %v", f.Path, err)
+		}
+		mode := os.FileMode(f.Mode)
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := fileutil.WriteFile(f.Path, []byte(*f.Before), mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", f.Path, err)
+		}
+		if err := os.Chmod(f.Path, mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", f.Path, err)
+		}
+	}
+
+	if !unitsChanged {
+		return nil
+	}
+	if err := scheduler.Systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if filepath.Dir(f.Path) == scheduler.DefaultUnitDir && f.Before != nil && installable(f.Path) {
+			if err := scheduler.Systemctl("enable", "--now", filepath.Base(f.Path)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// installable reports whether a unit file can be enabled
+func installable(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(data), "[Install]")
+}
+
+// Current returns the present state of the paths of files in the form
+// Apply takes, to undo applying them
+func Current(files []File) []File {
+	current := make([]File, 0, len(files))
+	for _, f := range files {
+		c := File{Path: f.Path}
+		if info, err := os.Stat(f.Path); err == nil {
+			if data, err := os.ReadFile(f.Path); err == nil {
+				c.Before, c.Mode = text(data), uint32(info.Mode().Perm())
+			}
+		}
+		current = append(current, c)
+	}
+	return current
+}
+
+// Modified returns the files changed by the entries that were edited
+// outside the panel since the last of them
+func Modified(entries []*Entry) []string {
+	latest := make(map[string]*string)
+	for _, e := range entries {
+		for _, f := range e.Files {
+			latest[f.Path] = f.After
+		}
+	}
+
+	var paths []string
+	for path, after := range latest {
+		data, err := os.ReadFile(path)
+		switch {
+		case err != nil && after == nil:
+		case err != nil, after == nil, string(data) != *after:
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
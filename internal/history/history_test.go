@@ -0,0 +1,88 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+)
+
+func TestJournaledOnlyHoldsOwnChanges(t *testing.T) {
+	dir := t.TempDir()
+	config.SetConfig(&config.Config{ConfigDir: dir})
+	for _, sub := range []string{"sites", "meta"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	own := filepath.Join(dir, "sites", "a.example.conf")
+	removed := filepath.Join(dir, "meta", "a.example.json")
+	other := filepath.Join(dir, "sites", "b.example.conf")
+	untracked := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{own, removed, other} {
+		if err := os.WriteFile(path, []byte("old "+filepath.Base(path)), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileutil.Journal(IsTracked)
+	if err := fileutil.WriteFile(own, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.WriteFile(own, []byte("newer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.WriteFile(untracked, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Another run changes a file of its own at the same time
+	if err := os.WriteFile(other, []byte("changed elsewhere"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := Changes(Journaled())
+	if len(files) != 2 {
+		t.Fatalf("recorded %d files, want 2: %+v", len(files), files)
+	}
+
+	meta, site := files[0], files[1]
+	if meta.Path != removed || meta.Before == nil || *meta.Before != "old a.example.json" || meta.After != nil {
+		t.Errorf("removed file recorded as %+v", meta)
+	}
+	if site.Path != own || site.Before == nil || *site.Before != "old a.example.conf" || site.After == nil || *site.After != "newer" {
+		t.Errorf("written file recorded as %+v", site)
+	}
+	if site.Mode != 0640 {
+		t.Errorf("mode before = %o, want 640", site.Mode)
+	}
+}
+
+func TestIsTracked(t *testing.T) {
+	config.SetConfig(&config.Config{ConfigDir: "/etc/webpanel"})
+
+	tests := map[string]bool{
+		"/etc/webpanel/global/Caddyfile":        true,
+		"/etc/webpanel/sites/example.com.conf":  true,
+		"/etc/webpanel/modules/php83.conf":      true,
+		"/etc/webpanel/meta/example.com.json":   true,
+		"/etc/systemd/system/webpanel-x.timer":  true,
+		"/etc/cron.d/webpanel-backup-x":         true,
+		"/etc/webpanel/sites/example.com.conf~": false,
+		"/etc/webpanel/history/000001.json":     false,
+		"/etc/webpanel/sites/sub/example.conf":  false,
+		"/etc/systemd/system/caddy.service":     false,
+		"/etc/cron.d/other":                     false,
+		"/apps/sites/example.com/public/x.conf": false,
+	}
+	for path, want := range tests {
+		if got := IsTracked(path); got != want {
+			t.Errorf("IsTracked(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
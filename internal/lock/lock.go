@@ -0,0 +1,169 @@
+// Package lock keeps concurrent panel runs, such as scheduled backups, an
+// admin and an automation script, from changing the same state at once.
+// Commands changing the server hold the global lock, shared, and commands
+// changing the whole server hold it exclusively. Changes to one site or
+// database also hold the lock of that resource.
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+const (
+	// DefaultTimeout is how long to wait for a lock held by another run
+	DefaultTimeout = 30 * time.Second
+
+	pollInterval = 100 * time.Millisecond
+)
+
+// Timeout is how long to wait for a lock, set by the --lock-timeout flag
+var Timeout = DefaultTimeout
+
+// Command names the running command in the lock files it holds, e.g.
+// "webpanel dbuser create". Arguments are left out, they may hold secrets.
+var Command = filepath.Base(os.Args[0])
+
+// Lock is a held lock
+type Lock struct {
+	name string
+}
+
+// held counts the holders of each lock in this process, so a function
+// taking a lock its caller holds does not wait for itself
+var (
+	mu   sync.Mutex
+	held = make(map[string]*holder)
+)
+
+type holder struct {
+	file  *os.File
+	count int
+}
+
+// Dir returns the directory holding the lock files
+func Dir() string {
+	return filepath.Join(config.GetConfigDir(), "locks")
+}
+
+// Global takes the global lock, exclusively for commands changing the
+// whole server
+func Global(exclusive bool) (*Lock, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return acquire("global", "the panel", how)
+}
+
+// Site takes the lock of a site
+func Site(domain string) (*Lock, error) {
+	return acquire("site-"+domain, "site "+domain, syscall.LOCK_EX)
+}
+
+// Database takes the lock of a database
+func Database(name string) (*Lock, error) {
+	return acquire("database-"+name, "database "+name, syscall.LOCK_EX)
+}
+
+// Named takes a lock guarding other shared state, such as the history
+func Named(name string) (*Lock, error) {
+	return acquire(name, name, syscall.LOCK_EX)
+}
+
+func acquire(name, description string, how int) (*Lock, error) {
+	mu.Lock()
+	if h, ok := held[name]; ok {
+		h.count++
+		mu.Unlock()
+		return &Lock{name: name}, nil
+	}
+	mu.Unlock()
+
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(Dir(), name+".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock of %s: %v", description, err)
+	}
+
+	deadline := time.Now().Add(Timeout)
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", description, err)
+		}
+		if time.Now().After(deadline) {
+			holder := holderOf(f)
+			f.Close()
+			return nil, fmt.Errorf("%s is %s, gave up after %s (see --lock-timeout)", description, holder, Timeout)
+		}
+		if !waiting {
+			fmt.Printf("Waiting for %s, %s\n", description, holderOf(f))
+			waiting = true
+		}
+		time.Sleep(pollInterval)
+	}
+
+	// Name the holder for runs waiting for the lock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), Command)), 0)
+	}
+
+	mu.Lock()
+	held[name] = &holder{file: f, count: 1}
+	mu.Unlock()
+	return &Lock{name: name}, nil
+}
+
+// holderOf describes the process named in a lock file
+func holderOf(f *os.File) string {
+	data := make([]byte, 4096)
+	n, _ := f.ReadAt(data, 0)
+	lines := strings.SplitN(string(data[:n]), "\n", 3)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	// Shared holders overwrite each other, the named one may have finished
+	if err != nil || len(lines) < 2 || syscall.Kill(pid, 0) == syscall.ESRCH {
+		return "locked by another webpanel run"
+	}
+	return fmt.Sprintf("locked by PID %d running %q", pid, lines[1])
+}
+
+// Release releases the lock. Releasing a nil lock does nothing, so callers
+// may defer it before checking the error of the acquisition.
+func (l *Lock) Release() {
+	if l == nil || l.name == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	name := l.name
+	l.name = ""
+	h, ok := held[name]
+	if !ok {
+		return
+	}
+	h.count--
+	if h.count > 0 {
+		return
+	}
+	syscall.Flock(int(h.file.Fd()), syscall.LOCK_UN)
+	h.file.Close()
+	delete(held, name)
+}
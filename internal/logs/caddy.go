@@ -0,0 +1,201 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+// Entry is a line of a Caddy JSON access or error log
+type Entry struct {
+	Level    string    `json:"level"`
+	Time     Timestamp `json:"ts"`
+	Logger   string    `json:"logger"`
+	Msg      string    `json:"msg"`
+	Request  Request   `json:"request"`
+	Status   int       `json:"status"`
+	Size     uint64    `json:"size"`
+	Duration float64   `json:"duration"` // seconds
+	Error    string    `json:"error"`
+}
+
+// Request holds the request fields of a log entry
+type Request struct {
+	RemoteIP string              `json:"remote_ip"`
+	ClientIP string              `json:"client_ip"`
+	Proto    string              `json:"proto"`
+	Method   string              `json:"method"`
+	Host     string              `json:"host"`
+	URI      string              `json:"uri"`
+	Headers  map[string][]string `json:"headers"`
+}
+
+// IP returns the client address, which differs from the remote address
+// behind trusted proxies
+func (r Request) IP() string {
+	if r.ClientIP != "" {
+		return r.ClientIP
+	}
+	return r.RemoteIP
+}
+
+// Timestamp is the ts field, unix seconds by default or a formatted time
+// when the log sets time_format
+type Timestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON accepts unix seconds and RFC 3339 strings
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if seconds, err := strconv.ParseFloat(string(data), 64); err == nil {
+		t.Time = time.Unix(0, int64(seconds*float64(time.Second)))
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return fmt.Errorf("unsupported timestamp %q", text)
+	}
+	t.Time = parsed
+	return nil
+}
+
+// ParseEntry decodes a log line
+func ParseEntry(line string) (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Filter selects log entries. Zero fields match everything.
+type Filter struct {
+	StatusMin  int // inclusive
+	StatusMax  int // inclusive
+	Network    *net.IPNet
+	PathPrefix string
+	Method     string
+	Since      time.Time
+}
+
+// Empty reports whether the filter matches every entry
+func (f *Filter) Empty() bool {
+	return f.StatusMin == 0 && f.StatusMax == 0 && f.Network == nil &&
+		f.PathPrefix == "" && f.Method == "" && f.Since.IsZero()
+}
+
+// SetStatus parses a status code such as 404 or a class such as 5xx
+func (f *Filter) SetStatus(status string) error {
+	status = strings.ToLower(status)
+	if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+		class := int(status[0]-'0') * 100
+		f.StatusMin, f.StatusMax = class, class+99
+		return nil
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return fmt.Errorf("invalid status %q, use a code like 404 or a class like 5xx", status)
+	}
+	f.StatusMin, f.StatusMax = code, code
+	return nil
+}
+
+// SetIP parses a client address or a CIDR network
+func (f *Filter) SetIP(ip string) error {
+	if !strings.Contains(ip, "/") {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return fmt.Errorf("invalid IP address: %s", ip)
+		}
+		bits := 128
+		if addr.To4() != nil {
+			addr, bits = addr.To4(), 32
+		}
+		f.Network = &net.IPNet{IP: addr, Mask: net.CIDRMask(bits, bits)}
+		return nil
+	}
+
+	_, network, err := net.ParseCIDR(ip)
+	if err != nil {
+		return fmt.Errorf("invalid IP network: %s", ip)
+	}
+	f.Network = network
+	return nil
+}
+
+// Match reports whether an entry passes the filter
+func (f *Filter) Match(e *Entry) bool {
+	if f.StatusMin > 0 && (e.Status < f.StatusMin || e.Status > f.StatusMax) {
+		return false
+	}
+	if f.Network != nil {
+		ip := net.ParseIP(e.Request.IP())
+		if ip == nil || !f.Network.Contains(ip) {
+			return false
+		}
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(e.Request.URI, f.PathPrefix) {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(e.Request.Method, f.Method) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// Format returns an entry as a readable line:
+//
+//	2006-01-02 15:04:05  203.0.113.7  GET example.com/path  200  1.2 KB  35ms
+//
+// Entries above info level are prefixed with their level and end with the
+// error message.
+func (e *Entry) Format() string {
+	var b strings.Builder
+	b.WriteString(e.Time.Local().Format("2006-01-02 15:04:05"))
+	if level := strings.ToUpper(e.Level); level != "" && level != "INFO" {
+		fmt.Fprintf(&b, "  %s", level)
+	}
+
+	if e.Request.Method == "" {
+		// Not a request, e.g. a startup message
+		fmt.Fprintf(&b, "  %s", e.Msg)
+	} else {
+		fmt.Fprintf(&b, "  %s  %s %s%s  %d  %s  %s", e.Request.IP(), e.Request.Method, e.Request.Host, e.Request.URI,
+			e.Status, monitoring.FormatBytes(e.Size), formatDuration(e.Duration))
+		if e.Msg != "" && e.Msg != "handled request" {
+			fmt.Fprintf(&b, "  %s", e.Msg)
+		}
+	}
+
+	if e.Error != "" {
+		fmt.Fprintf(&b, ": %s", e.Error)
+	}
+	return b.String()
+}
+
+// formatDuration rounds a duration in seconds for display
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
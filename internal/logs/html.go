@@ -0,0 +1,113 @@
+package logs
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/doko/cli-webpanel/internal/monitoring"
+)
+
+// reportTemplate renders a report as a single HTML file without external
+// resources
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": func(n uint64) string { return monitoring.FormatBytes(n) },
+	"time": func(r *Report) string {
+		return r.Since.Format("2006-01-02 15:04") + " – " + r.Until.Format("2006-01-02 15:04")
+	},
+	"share": func(n, total uint64) string {
+		if total == 0 {
+			return "0"
+		}
+		return fmt.Sprintf("%.1f", float64(n)*100/float64(total))
+	},
+	"bar": func(n, max uint64, height int) int {
+		if max == 0 {
+			return 0
+		}
+		return int(float64(n) * float64(height) / float64(max))
+	},
+	"sub": func(a, b int) int { return a - b },
+	"mul": func(a, b int) int { return a * b },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Report.Domain}} access report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 1100px; color: #222; }
+h1 { margin-bottom: 0; }
+.period { color: #666; margin-top: .3em; }
+.cards { display: flex; gap: 1em; margin: 1.5em 0; flex-wrap: wrap; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: .8em 1.2em; min-width: 9em; }
+.card b { display: block; font-size: 1.4em; }
+.grid { display: grid; grid-template-columns: 1fr 1fr; gap: 1.5em; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { text-align: left; padding: .3em .5em; border-bottom: 1px solid #eee; }
+td.num { text-align: right; white-space: nowrap; }
+td.key { word-break: break-all; }
+svg rect.req { fill: #4a7bd0; }
+svg rect.err { fill: #d04a4a; }
+</style>
+</head>
+<body>
+{{with .Report}}
+<h1>{{.Domain}}</h1>
+<p class="period">{{time .}}</p>
+
+<div class="cards">
+<div class="card">Requests<b>{{.Requests}}</b></div>
+<div class="card">Bandwidth<b>{{bytes .Bytes}}</b></div>
+<div class="card">Latency p50<b>{{.Latency.P50}} ms</b></div>
+<div class="card">Latency p95<b>{{.Latency.P95}} ms</b></div>
+<div class="card">Latency p99<b>{{.Latency.P99}} ms</b></div>
+</div>
+
+<h2>Requests per {{.Interval}}</h2>
+{{if .Timeline}}
+<svg width="100%" height="{{$.ChartHeight}}" viewBox="0 0 {{mul (len .Timeline) 10}} {{$.ChartHeight}}" preserveAspectRatio="none">
+{{range $i, $b := .Timeline}}{{$h := bar $b.Requests $.MaxBucket $.ChartHeight}}{{$e := bar $b.Errors $.MaxBucket $.ChartHeight}}<rect class="req" x="{{mul $i 10}}" y="{{sub $.ChartHeight $h}}" width="8" height="{{$h}}"><title>{{$b.Start.Format "2006-01-02 15:04"}}: {{$b.Requests}} requests, {{$b.Errors}} errors</title></rect><rect class="err" x="{{mul $i 10}}" y="{{sub $.ChartHeight $e}}" width="8" height="{{$e}}"></rect>
+{{end}}</svg>
+{{else}}<p>No requests in this period.</p>{{end}}
+
+<div class="grid">
+<div>
+<h2>Status codes</h2>
+<table>{{range .StatusCodes}}<tr><td class="key">{{.Key}}</td><td class="num">{{.Requests}}</td><td class="num">{{share .Requests $.Report.Requests}}%</td></tr>{{end}}</table>
+</div>
+<div>
+<h2>Top paths</h2>
+<table>{{range .Paths}}<tr><td class="key">{{.Key}}</td><td class="num">{{.Requests}}</td></tr>{{end}}</table>
+</div>
+<div>
+<h2>Top client IPs</h2>
+<table>{{range .ClientIPs}}<tr><td class="key">{{.Key}}</td><td class="num">{{.Requests}}</td></tr>{{end}}</table>
+</div>
+<div>
+<h2>Top referrers</h2>
+<table>{{range .Referrers}}<tr><td class="key">{{.Key}}</td><td class="num">{{.Requests}}</td></tr>{{end}}</table>
+</div>
+</div>
+
+<h2>Top user agents</h2>
+<table>{{range .UserAgents}}<tr><td class="key">{{.Key}}</td><td class="num">{{.Requests}}</td></tr>{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a report as a self-contained HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	var max uint64
+	for _, b := range r.Timeline {
+		if b.Requests > max {
+			max = b.Requests
+		}
+	}
+
+	return reportTemplate.Execute(w, struct {
+		Report      *Report
+		MaxBucket   uint64
+		ChartHeight int
+	}{r, max, 200})
+}
@@ -0,0 +1,79 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// LogrotatePath is where the generated logrotate configuration is written
+const LogrotatePath = "/etc/logrotate.d/webpanel"
+
+// distroDatabaseLogrotate are the logrotate files MariaDB packages ship.
+// logrotate refuses a log listed twice, so the database section is left
+// out when one exists.
+var distroDatabaseLogrotate = []string{"/etc/logrotate.d/mariadb", "/etc/logrotate.d/mysql-server"}
+
+// DatabaseLogrotateShipped reports whether the MariaDB package rotates its
+// logs already
+func DatabaseLogrotateShipped() bool {
+	for _, path := range distroDatabaseLogrotate {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Logrotate returns a logrotate configuration for the panel logs and, with
+// database, the MariaDB logs. Caddy rolls the site logs itself.
+func Logrotate(cfg config.LoggingConfig, database bool) string {
+	var b strings.Builder
+	b.WriteString("# Generated by \"webpanel logs setup\" from the logging settings.\n")
+	b.WriteString("# Caddy rolls the site logs itself.\n\n")
+
+	// The audit log names the commands run on the server and is not world
+	// readable
+	writeSection(&b, filepath.Join(config.GetLogDir(), "*.log"), cfg, "create 0640 root adm")
+	if database {
+		b.WriteString("\n")
+		writeSection(&b, filepath.Join(config.DatabaseLogDir, "*.log"), cfg,
+			"create 0640 mysql adm",
+			"sharedscripts",
+			"postrotate",
+			"    mysqladmin --local flush-error-log flush-slow-log >/dev/null 2>&1 || true",
+			"endscript")
+	}
+	return b.String()
+}
+
+func writeSection(b *strings.Builder, pattern string, cfg config.LoggingConfig, extra ...string) {
+	lines := []string{"missingok", "notifempty"}
+	if cfg.MaxSize > 0 {
+		lines = append(lines, fmt.Sprintf("size %dM", cfg.MaxSize))
+	} else {
+		lines = append(lines, "daily")
+	}
+	// logrotate removes every old log without a count, -1 keeps them all
+	if cfg.MaxFiles > 0 {
+		lines = append(lines, fmt.Sprintf("rotate %d", cfg.MaxFiles))
+	} else {
+		lines = append(lines, "rotate -1")
+	}
+	if cfg.MaxAgeDays > 0 {
+		lines = append(lines, fmt.Sprintf("maxage %d", cfg.MaxAgeDays))
+	}
+	if cfg.CompressLogs {
+		lines = append(lines, "compress", "delaycompress")
+	}
+	lines = append(lines, extra...)
+
+	fmt.Fprintf(b, "%s {\n", pattern)
+	for _, line := range lines {
+		fmt.Fprintf(b, "    %s\n", line)
+	}
+	b.WriteString("}\n")
+}
@@ -0,0 +1,316 @@
+package logs
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDistinct caps the keys a top list counts. When it is reached the
+// rarest half is dropped, so the top entries of huge logs are approximate
+// but memory stays bounded.
+const maxDistinct = 50000
+
+// Latency histogram buckets grow by latencyGrowth from latencyMin seconds
+const (
+	latencyMin     = 0.0001
+	latencyGrowth  = 1.1
+	latencyBuckets = 160
+)
+
+// Count is a key of a top list with its number of requests
+type Count struct {
+	Key      string `json:"key"`
+	Requests uint64 `json:"requests"`
+}
+
+// Bucket is the traffic of one interval of the timeline
+type Bucket struct {
+	Start    time.Time `json:"start"`
+	Requests uint64    `json:"requests"`
+	Errors   uint64    `json:"errors"` // 5xx responses
+	Bytes    uint64    `json:"bytes"`
+}
+
+// Latency holds the response time percentiles in milliseconds
+type Latency struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// Report summarizes the access log of a site over a period
+type Report struct {
+	Domain      string    `json:"domain"`
+	Since       time.Time `json:"since"`
+	Until       time.Time `json:"until"`
+	Requests    uint64    `json:"requests"`
+	Bytes       uint64    `json:"bytes"`
+	Interval    string    `json:"interval"`
+	Timeline    []Bucket  `json:"timeline"`
+	StatusCodes []Count   `json:"status_codes"`
+	Paths       []Count   `json:"top_paths"`
+	ClientIPs   []Count   `json:"top_client_ips"`
+	UserAgents  []Count   `json:"top_user_agents"`
+	Referrers   []Count   `json:"top_referrers"`
+	Latency     Latency   `json:"latency"`
+}
+
+// counter counts the requests per key with at most maxDistinct keys
+type counter map[string]uint64
+
+func (c counter) add(key string) {
+	c[key]++
+	if len(c) <= maxDistinct {
+		return
+	}
+
+	counts := make([]uint64, 0, len(c))
+	for _, n := range c {
+		counts = append(counts, n)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] < counts[j] })
+	median := counts[len(counts)/2]
+	for k, n := range c {
+		if n <= median {
+			delete(c, k)
+		}
+	}
+}
+
+// top returns the n keys with the most requests
+func (c counter) top(n int) []Count {
+	list := make([]Count, 0, len(c))
+	for k, requests := range c {
+		list = append(list, Count{Key: k, Requests: requests})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Requests != list[j].Requests {
+			return list[i].Requests > list[j].Requests
+		}
+		return list[i].Key < list[j].Key
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// Analyzer aggregates access log entries one at a time
+type Analyzer struct {
+	Since    time.Time
+	Interval time.Duration // timeline bucket size
+
+	requests, bytes uint64
+	timeline        map[int64]*Bucket
+	status          counter
+	paths           counter
+	ips             counter
+	agents          counter
+	referrers       counter
+	latency         [latencyBuckets]uint64
+	maxLatency      float64
+	first, last     time.Time
+}
+
+// NewAnalyzer returns an analyzer of the entries since a point in time.
+// The timeline interval is chosen for the length of the period.
+func NewAnalyzer(since time.Time) *Analyzer {
+	interval := 24 * time.Hour
+	switch period := time.Since(since); {
+	case period <= 2*time.Hour:
+		interval = 5 * time.Minute
+	case period <= 48*time.Hour:
+		interval = time.Hour
+	case period <= 8*24*time.Hour:
+		interval = 6 * time.Hour
+	}
+
+	return &Analyzer{
+		Since:     since,
+		Interval:  interval,
+		timeline:  make(map[int64]*Bucket),
+		status:    make(counter),
+		paths:     make(counter),
+		ips:       make(counter),
+		agents:    make(counter),
+		referrers: make(counter),
+	}
+}
+
+// Add counts an entry when it is a request within the period
+func (a *Analyzer) Add(e *Entry) {
+	if e.Request.Method == "" || e.Time.Before(a.Since) {
+		return
+	}
+
+	a.requests++
+	a.bytes += e.Size
+	if a.first.IsZero() || e.Time.Before(a.first) {
+		a.first = e.Time.Time
+	}
+	if e.Time.After(a.last) {
+		a.last = e.Time.Time
+	}
+
+	start := e.Time.Truncate(a.Interval)
+	bucket := a.timeline[start.Unix()]
+	if bucket == nil {
+		bucket = &Bucket{Start: start}
+		a.timeline[start.Unix()] = bucket
+	}
+	bucket.Requests++
+	bucket.Bytes += e.Size
+	if e.Status >= 500 {
+		bucket.Errors++
+	}
+
+	path, _, _ := strings.Cut(e.Request.URI, "?")
+	a.status.add(strconv.Itoa(e.Status))
+	a.paths.add(path)
+	a.ips.add(e.Request.IP())
+	if agent := header(e.Request.Headers, "User-Agent"); agent != "" {
+		a.agents.add(agent)
+	}
+	if referrer := header(e.Request.Headers, "Referer"); referrer != "" {
+		a.referrers.add(referrer)
+	}
+
+	a.latency[latencyBucket(e.Duration)]++
+	if e.Duration > a.maxLatency {
+		a.maxLatency = e.Duration
+	}
+}
+
+// Report returns the aggregated statistics with top lists of n entries
+func (a *Analyzer) Report(domain string, n int) *Report {
+	r := &Report{
+		Domain:      domain,
+		Since:       a.Since,
+		Until:       time.Now(),
+		Requests:    a.requests,
+		Bytes:       a.bytes,
+		Interval:    strings.TrimSuffix(strings.TrimSuffix(a.Interval.String(), "0s"), "0m"),
+		StatusCodes: a.status.top(0),
+		Paths:       a.paths.top(n),
+		ClientIPs:   a.ips.top(n),
+		UserAgents:  a.agents.top(n),
+		Referrers:   a.referrers.top(n),
+	}
+	sort.Slice(r.StatusCodes, func(i, j int) bool { return r.StatusCodes[i].Key < r.StatusCodes[j].Key })
+
+	// The timeline has an entry for every interval, including quiet ones
+	if a.requests > 0 {
+		for t := a.first.Truncate(a.Interval); !t.After(a.last); t = t.Add(a.Interval) {
+			bucket := Bucket{Start: t}
+			if b := a.timeline[t.Unix()]; b != nil {
+				bucket = *b
+			}
+			r.Timeline = append(r.Timeline, bucket)
+		}
+
+		r.Latency = Latency{
+			P50: a.percentile(50),
+			P90: a.percentile(90),
+			P95: a.percentile(95),
+			P99: a.percentile(99),
+			Max: math.Round(a.maxLatency*1e5) / 100,
+		}
+	}
+	return r
+}
+
+// percentile returns the upper bound of the histogram bucket holding the
+// pth percentile, in milliseconds
+func (a *Analyzer) percentile(p float64) float64 {
+	rank := uint64(math.Ceil(p / 100 * float64(a.requests)))
+	var seen uint64
+	for i, n := range a.latency {
+		seen += n
+		if seen >= rank {
+			bound := latencyMin * math.Pow(latencyGrowth, float64(i))
+			return math.Round(math.Min(bound, a.maxLatency)*1e5) / 100
+		}
+	}
+	return math.Round(a.maxLatency*1e5) / 100
+}
+
+func latencyBucket(seconds float64) int {
+	if seconds <= latencyMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(seconds/latencyMin) / math.Log(latencyGrowth)))
+	if i >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return i
+}
+
+// header returns the first value of a request header
+func header(headers map[string][]string, name string) string {
+	if values := headers[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// AccessLogFiles returns the access log of a site directory and its rolled
+// files, skipping those last written before since
+func AccessLogFiles(dir string, since time.Time) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "access*.log*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		files = append(files, match)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// AnalyzeFile streams a log file, gzip compressed or not, into the
+// analyzer
+func (a *Analyzer) AnalyzeFile(path string) error {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	reader := bufio.NewReaderSize(r, 64<<10)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// An overlong line is skipped
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			continue
+		}
+		if len(line) > 0 {
+			if entry, perr := ParseEntry(string(line)); perr == nil {
+				a.Add(entry)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotatedSuffix matches the suffix Rotate gives rotated files, .1 or .1.gz
+var rotatedSuffix = regexp.MustCompile(`^\.(\d+)(\.gz)?$`)
+
+// Rotate moves a log to path.1, shifting older rotations to path.2 and so
+// on and removing those beyond keep. A keep of 0 keeps every rotated file.
+// The writer of the log must reopen it afterwards. Rotate returns the path
+// of the rotated file.
+func Rotate(path string, keep int) (string, error) {
+	if keep < 1 {
+		// One more than the oldest rotation, so none is removed
+		keep = 1
+		for _, match := range RotatedFiles(path) {
+			if m := rotatedSuffix.FindStringSubmatch(strings.TrimPrefix(match, path)); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				keep = max(keep, n+1)
+			}
+		}
+	}
+
+	for i := keep; i >= 1; i-- {
+		for _, ext := range []string{"", ".gz"} {
+			src := fmt.Sprintf("%s.%d%s", path, i, ext)
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			if i == keep {
+				if err := os.Remove(src); err != nil {
+					return "", err
+				}
+				continue
+			}
+			if err := os.Rename(src, fmt.Sprintf("%s.%d%s", path, i+1, ext)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		return "", err
+	}
+	return rotated, nil
+}
+
+// RotatedFiles returns a log and its rotated files, oldest first
+func RotatedFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+
+	type rotated struct {
+		path string
+		n    int
+	}
+	var files []rotated
+	for _, match := range matches {
+		if m := rotatedSuffix.FindStringSubmatch(strings.TrimPrefix(match, path)); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			files = append(files, rotated{match, n})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].n > files[j].n })
+
+	paths := make([]string, 0, len(files)+1)
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Open opens a log for reading, decompressing it when it ends in .gz
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{gz, f}, nil
+}
+
+// gzipFile closes both the decompressor and the file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// Compress gzips a rotated log to path.gz and removes the original
+func Compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Prune removes the rotated files of a log numbered beyond keep or last
+// written longer than maxAge ago. A zero keep keeps any number of files and
+// a zero maxAge keeps files of any age.
+// With dryRun nothing is removed. The files that were or would be removed
+// are returned.
+func Prune(path string, keep int, maxAge time.Duration, now time.Time, dryRun bool) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, match := range matches {
+		m := rotatedSuffix.FindStringSubmatch(strings.TrimPrefix(match, path))
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if (keep <= 0 || n <= keep) && (maxAge <= 0 || now.Sub(info.ModTime()) <= maxAge) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(match); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, match)
+	}
+	return removed, nil
+}
+
+// PruneRolled removes the files Caddy rolled in a site log directory, such
+// as access-2006-01-02T15-04-05.000.log.gz, beyond the newest keep of each
+// log or last written longer than maxAge ago, with the same zero values as
+// Prune. Caddy prunes them itself when the logging module sets roll_keep,
+// this catches files it left behind.
+func PruneRolled(dir string, keep int, maxAge time.Duration, now time.Time, dryRun bool) ([]string, error) {
+	type rolled struct {
+		path    string
+		modTime time.Time
+	}
+	byLog := make(map[string][]rolled)
+
+	for _, name := range []string{"access", "error"} {
+		matches, err := filepath.Glob(filepath.Join(dir, name+"-*.log*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil {
+				byLog[name] = append(byLog[name], rolled{match, info.ModTime()})
+			}
+		}
+	}
+
+	var removed []string
+	for _, files := range byLog {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+		for i, f := range files {
+			if (keep <= 0 || i < keep) && (maxAge <= 0 || now.Sub(f.modTime) <= maxAge) {
+				continue
+			}
+			if !dryRun {
+				if err := os.Remove(f.path); err != nil {
+					return removed, err
+				}
+			}
+			removed = append(removed, f.path)
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
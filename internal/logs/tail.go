@@ -0,0 +1,165 @@
+// Package logs reads, follows and filters the panel, service and Caddy
+// site logs
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// PollInterval is how often a followed file is checked for new lines
+const PollInterval = 250 * time.Millisecond
+
+// tailChunk is how much is read at a time when searching lines backwards
+const tailChunk = 64 << 10
+
+// Tail returns the last n lines of a file and the offset following them,
+// where Follow continues
+func Tail(path string, n int) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	if n <= 0 {
+		return nil, size, nil
+	}
+
+	// Read chunks from the end until n complete lines are found. A final
+	// line without newline counts as a line too.
+	var data []byte
+	pos := size
+	for pos > 0 && bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) < n {
+		chunk := int64(tailChunk)
+		if pos < chunk {
+			chunk = pos
+		}
+		pos -= chunk
+		buf := make([]byte, chunk)
+		if _, err := f.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(buf, data...)
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil, size, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, size, nil
+}
+
+// Scan calls fn for every line of a file and returns the offset after the
+// last complete line
+func Scan(path string, fn func(line string)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partly written last line is left for Follow
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		offset += int64(len(line))
+		fn(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// Follow calls fn for every line appended to a file after offset until stop
+// is closed. When the file is rotated, the rest of the old file is read
+// before continuing with the new one from its start. A truncated file is
+// read again from the start.
+func Follow(path string, offset int64, stop <-chan struct{}, fn func(line string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var partial string
+
+	// drain reads every complete line available in the current file
+	drain := func() {
+		for {
+			line, err := reader.ReadString('\n')
+			offset += int64(len(line))
+			if err != nil {
+				partial += line
+				return
+			}
+			fn(strings.TrimSuffix(partial+line, "\n"))
+			partial = ""
+		}
+	}
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		drain()
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		latest, err := os.Stat(path)
+		switch {
+		case err != nil:
+			// Between the rotation and the creation of the new file
+			continue
+		case !os.SameFile(current, latest):
+			// Rotated: finish the old file, then continue with the new one
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			drain()
+			if partial != "" {
+				fn(partial)
+			}
+			f.Close()
+			f, offset, partial = next, 0, ""
+			reader.Reset(f)
+		case current.Size() < offset:
+			// Truncated in place, e.g. by copytruncate
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			offset, partial = 0, ""
+			reader.Reset(f)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
 )
 
 // ModuleConfig represents a module's configuration
@@ -80,7 +82,9 @@ var availableModules = map[string]Module{
 		Name: "access_log",
 		Template: `(access_log) {
     log access {
-        output file /var/log/webpanel/caddy/{args.0}/access.log
+        output file /var/log/webpanel/caddy/{args.0}/access.log {
+{{roll}}
+        }
         format json
     }
 }`,
@@ -90,7 +94,9 @@ var availableModules = map[string]Module{
 		Name: "error_log",
 		Template: `(error_log) {
     log error {
-        output file /var/log/webpanel/caddy/{args.0}/error.log
+        output file /var/log/webpanel/caddy/{args.0}/error.log {
+{{roll}}
+        }
         format json
         level ERROR
     }
@@ -98,6 +104,74 @@ var availableModules = map[string]Module{
 	},
 }
 
+// LoggingModulesFile is the module configuration holding the access_log
+// and error_log snippets
+const LoggingModulesFile = "logging.conf"
+
+// Content returns the Caddy snippet of a module. The logging modules roll
+// their files as set in the logging section of the configuration.
+func (m Module) Content() string {
+	return strings.Replace(m.Template, "{{roll}}", rollOptions(config.GetLoggingConfig()), 1)
+}
+
+// rollOptions returns the Caddy file output options for the log limits
+func rollOptions(cfg config.LoggingConfig) string {
+	var options []string
+	if cfg.MaxSize > 0 {
+		options = append(options, fmt.Sprintf("roll_size %dMiB", cfg.MaxSize))
+	}
+	if cfg.MaxFiles > 0 {
+		options = append(options, fmt.Sprintf("roll_keep %d", cfg.MaxFiles))
+	}
+	if cfg.MaxAgeDays > 0 {
+		options = append(options, fmt.Sprintf("roll_keep_for %dh", cfg.MaxAgeDays*24))
+	}
+	if !cfg.CompressLogs {
+		options = append(options, "roll_uncompressed")
+	}
+
+	for i, option := range options {
+		options[i] = "            " + option
+	}
+	return strings.Join(options, "\n")
+}
+
+// WriteLoggingModules writes the access_log and error_log snippets with the
+// current logging settings
+func WriteLoggingModules() error {
+	modulesDir := filepath.Join(config.GetConfigDir(), "modules")
+	if err := os.MkdirAll(modulesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create modules directory: %v", err)
+	}
+
+	content := availableModules["access_log"].Content() + "\n\n" + availableModules["error_log"].Content() + "\n"
+	if err := fileutil.WriteFile(filepath.Join(modulesDir, LoggingModulesFile), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write logging modules: %v", err)
+	}
+	return nil
+}
+
+// WriteConfig writes the configuration file of a module. The logging
+// modules share one file.
+func WriteConfig(name string) error {
+	if name == "access_log" || name == "error_log" {
+		return WriteLoggingModules()
+	}
+	m, err := GetModule(name)
+	if err != nil {
+		return err
+	}
+
+	modulesDir := filepath.Join(config.GetConfigDir(), "modules")
+	if err := os.MkdirAll(modulesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create modules directory: %v", err)
+	}
+	if err := fileutil.WriteFile(filepath.Join(modulesDir, name+".conf"), []byte(m.Content()+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write module configuration %s: %v", name, err)
+	}
+	return nil
+}
+
 // InitializeModules sets up the module configuration files
 func InitializeModules() error {
 	modulesDir := filepath.Join(config.GetConfigDir(), "modules")
@@ -107,7 +181,7 @@ func InitializeModules() error {
 
 	for name, module := range availableModules {
 		configPath := filepath.Join(modulesDir, name+".conf")
-		err := os.WriteFile(configPath, []byte(module.Template), 0644)
+		err := fileutil.WriteFile(configPath, []byte(module.Content()), 0644)
 		if err != nil {
 			return fmt.Errorf("failed to write module configuration %s: %v", name, err)
 		}
@@ -134,6 +208,28 @@ func GetModule(name string) (Module, error) {
 	return module, nil
 }
 
+// Snippet returns the name of the Caddy snippet a module defines, e.g.
+// php_config
+func (m Module) Snippet() string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(m.Template, "("), ")")
+	return name
+}
+
+// ForImport returns the module of an import in a site configuration. The
+// import names the snippet of the module, or the module itself when it was
+// added by "module add".
+func ForImport(name string) (Module, bool) {
+	if m, ok := availableModules[name]; ok {
+		return m, true
+	}
+	for _, m := range availableModules {
+		if m.Snippet() == name {
+			return m, true
+		}
+	}
+	return Module{}, false
+}
+
 // IsModuleEnabled checks if a module is enabled for a domain
 func IsModuleEnabled(moduleName, domain string) bool {
 	configPath := filepath.Join(config.GetConfigDir(), "sites", domain+".conf")
@@ -171,6 +267,12 @@ func EnableModule(moduleName, domain string, params []string) error {
 		return err
 	}
 
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
 	// Create site config directory if it doesn't exist
 	sitesDir := filepath.Join(config.GetConfigDir(), "sites")
 	if err := os.MkdirAll(sitesDir, 0755); err != nil {
@@ -179,7 +281,7 @@ func EnableModule(moduleName, domain string, params []string) error {
 
 	// Create log directory for the domain if using logging modules
 	if moduleName == "access_log" || moduleName == "error_log" {
-		logDir := filepath.Join("/var/log/webpanel/caddy", domain)
+		logDir := config.GetSiteLogDirectory(domain)
 		if err := os.MkdirAll(logDir, 0755); err != nil {
 			return fmt.Errorf("failed to create log directory: %v", err)
 		}
@@ -213,7 +315,7 @@ func EnableModule(moduleName, domain string, params []string) error {
 		}
 		content = []byte(strings.Join(newLines, "\n"))
 
-		if err := os.WriteFile(configPath, content, 0644); err != nil {
+		if err := fileutil.WriteFile(configPath, content, 0644); err != nil {
 			return fmt.Errorf("failed to update site configuration: %v", err)
 		}
 	}
@@ -223,6 +325,12 @@ func EnableModule(moduleName, domain string, params []string) error {
 
 // DisableModule disables a module for a domain
 func DisableModule(moduleName, domain string) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
 	configPath := filepath.Join(config.GetConfigDir(), "sites", domain+".conf")
 	content, err := os.ReadFile(configPath)
 	if err != nil {
@@ -238,7 +346,7 @@ func DisableModule(moduleName, domain string) error {
 		}
 	}
 
-	if err := os.WriteFile(configPath, []byte(strings.Join(newLines, "\n")), 0644); err != nil {
+	if err := fileutil.WriteFile(configPath, []byte(strings.Join(newLines, "\n")), 0644); err != nil {
 		return fmt.Errorf("failed to update site configuration: %v", err)
 	}
 
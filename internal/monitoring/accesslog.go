@@ -0,0 +1,152 @@
+package monitoring
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxAccessLogRead caps how much of a log is read per poll, so a burst of
+// traffic cannot stall the dashboard
+const maxAccessLogRead = 16 << 20
+
+// SiteRequestRate is the request rate of a site between two polls
+type SiteRequestRate struct {
+	Domain         string
+	RequestsSec    float64
+	ServerErrorSec float64 // 5xx responses per second
+}
+
+// AccessLogWatcher follows Caddy JSON access logs and counts the requests
+// written since the previous poll
+type AccessLogWatcher struct {
+	logs     map[string]string // domain -> access log path
+	offsets  map[string]int64
+	totals   map[string]map[int]uint64 // domain -> status code -> requests
+	lastPoll time.Time
+}
+
+// accessLogEntry holds the fields of a Caddy access log line used here
+type accessLogEntry struct {
+	Status int `json:"status"`
+}
+
+// NewAccessLogWatcher starts following the access logs of the given sites
+// at their current end
+func NewAccessLogWatcher(logs map[string]string) *AccessLogWatcher {
+	w := &AccessLogWatcher{
+		logs:     make(map[string]string),
+		offsets:  make(map[string]int64),
+		totals:   make(map[string]map[int]uint64),
+		lastPoll: time.Now(),
+	}
+	for domain, path := range logs {
+		w.Add(domain, path)
+	}
+	return w
+}
+
+// Add starts following the access log of another site at its current end.
+// A site already followed is left unchanged.
+func (w *AccessLogWatcher) Add(domain, path string) {
+	if current, ok := w.logs[domain]; ok && current == path {
+		return
+	}
+	w.logs[domain] = path
+	if info, err := os.Stat(path); err == nil {
+		w.offsets[domain] = info.Size()
+	}
+}
+
+// Poll returns the request rate of every site since the previous poll
+func (w *AccessLogWatcher) Poll() []SiteRequestRate {
+	now := time.Now()
+	seconds := now.Sub(w.lastPoll).Seconds()
+	w.lastPoll = now
+
+	rates := make([]SiteRequestRate, 0, len(w.logs))
+	for domain, path := range w.logs {
+		requests, errors := w.readNew(domain, path)
+		rate := SiteRequestRate{Domain: domain}
+		if seconds > 0 {
+			rate.RequestsSec = float64(requests) / seconds
+			rate.ServerErrorSec = float64(errors) / seconds
+		}
+		rates = append(rates, rate)
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].RequestsSec != rates[j].RequestsSec {
+			return rates[i].RequestsSec > rates[j].RequestsSec
+		}
+		return rates[i].Domain < rates[j].Domain
+	})
+	return rates
+}
+
+// readNew counts the requests and 5xx responses appended to a log since
+// the last read. A log that shrank was rotated and is read from the start.
+func (w *AccessLogWatcher) readNew(domain, path string) (int, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0
+	}
+
+	offset := w.offsets[domain]
+	if info.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0
+	}
+
+	if w.totals[domain] == nil {
+		w.totals[domain] = make(map[int]uint64)
+	}
+
+	requests, errors := 0, 0
+	reader := bufio.NewReader(io.LimitReader(f, maxAccessLogRead))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// Leave a partly written line for the next poll
+			break
+		}
+		offset += int64(len(line))
+
+		var entry accessLogEntry
+		if json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		requests++
+		w.totals[domain][entry.Status]++
+		if entry.Status >= 500 {
+			errors++
+		}
+	}
+
+	w.offsets[domain] = offset
+	return requests, errors
+}
+
+// Totals returns the number of requests per status code of every site
+// counted since the watcher was created
+func (w *AccessLogWatcher) Totals() map[string]map[int]uint64 {
+	totals := make(map[string]map[int]uint64, len(w.totals))
+	for domain, codes := range w.totals {
+		totals[domain] = make(map[int]uint64, len(codes))
+		for code, count := range codes {
+			totals[domain][code] = count
+		}
+	}
+	return totals
+}
@@ -0,0 +1,274 @@
+package monitoring
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sparkBlocks are the bar heights of a sparkline, lowest first
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Dashboard keeps the state of the full-screen monitor: the previous
+// samples used for rates and a short history of every metric
+type Dashboard struct {
+	Collector *Collector
+	Logs      *AccessLogWatcher
+	Interval  time.Duration
+
+	prev      *Snapshot
+	prevProcs *ProcessSample
+
+	stats     *SystemStats
+	processes []ProcessStats
+	sites     []SiteRequestRate
+	history   map[string][]float64
+	updated   time.Time
+}
+
+// NewDashboard takes the first samples of a dashboard. Rates are shown
+// from the first Update on.
+func NewDashboard(c *Collector, logs *AccessLogWatcher, interval time.Duration) (*Dashboard, error) {
+	d := &Dashboard{
+		Collector: c,
+		Logs:      logs,
+		Interval:  interval,
+		history:   make(map[string][]float64),
+	}
+
+	var err error
+	if d.prev, err = c.Snapshot(); err != nil {
+		return nil, err
+	}
+	if d.prevProcs, err = c.SampleProcesses(); err != nil {
+		return nil, fmt.Errorf("failed to read processes: %v", err)
+	}
+
+	return d, nil
+}
+
+// Update samples everything again and records the new values
+func (d *Dashboard) Update() error {
+	cur, err := d.Collector.Snapshot()
+	if err != nil {
+		return err
+	}
+	stats, err := d.Collector.CollectBetween(d.prev, cur)
+	if err != nil {
+		return err
+	}
+	procs, err := d.Collector.SampleProcesses()
+	if err != nil {
+		return fmt.Errorf("failed to read processes: %v", err)
+	}
+
+	d.processes = ProcessesBetween(d.prevProcs, procs)
+	d.prev, d.prevProcs = cur, procs
+	d.stats = stats
+	d.updated = time.Now()
+
+	var rx, tx, diskIO float64
+	for _, iface := range stats.Network {
+		rx += iface.RxBytesSec
+		tx += iface.TxBytesSec
+	}
+	for _, dev := range stats.DiskIO {
+		diskIO += dev.ReadBytesSec + dev.WriteBytesSec
+	}
+	d.record("cpu", stats.CPU)
+	d.record("mem", stats.Memory.UsagePerc)
+	d.record("disk", diskIO)
+	d.record("rx", rx)
+	d.record("tx", tx)
+
+	if d.Logs != nil {
+		d.sites = d.Logs.Poll()
+		for _, site := range d.sites {
+			d.record("site:"+site.Domain, site.RequestsSec)
+		}
+	}
+
+	return nil
+}
+
+// Preload fills the history with samples recorded earlier, e.g. by the
+// agent
+func (d *Dashboard) Preload(samples []MetricSample) {
+	for _, s := range samples {
+		d.record("cpu", s.CPU)
+		d.record("mem", s.Memory)
+		d.record("disk", s.DiskRead+s.DiskWrite)
+		d.record("rx", s.NetRx)
+		d.record("tx", s.NetTx)
+	}
+}
+
+// record appends a value to the history of a metric
+func (d *Dashboard) record(name string, value float64) {
+	const maxHistory = 120
+	values := append(d.history[name], value)
+	if len(values) > maxHistory {
+		values = values[len(values)-maxHistory:]
+	}
+	d.history[name] = values
+}
+
+// spark renders the newest width values of a metric. A max of 0 scales
+// the line to its own peak.
+func (d *Dashboard) spark(name string, width int, max float64) string {
+	values := d.history[name]
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	return Sparkline(values, max)
+}
+
+// Sparkline renders values as a line of block characters scaled to max.
+// A max of 0 scales the line to its highest value.
+func Sparkline(values []float64, max float64) string {
+	if max <= 0 {
+		for _, v := range values {
+			if v > max {
+				max = v
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v / max * float64(len(sparkBlocks)-1))
+		}
+		if level < 0 {
+			level = 0
+		}
+		if level >= len(sparkBlocks) {
+			level = len(sparkBlocks) - 1
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
+
+// Render draws one frame of the dashboard for a terminal of the given size
+func (d *Dashboard) Render(w io.Writer, width, height int) {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	host, _ := os.Hostname()
+	add("\033[1mwebpanel monitor\033[0m  %s  %s  every %s  (q to quit)",
+		host, time.Now().Format("2006-01-02 15:04:05"), d.Interval)
+	add("")
+
+	s := d.stats
+	if s == nil {
+		add("Collecting...")
+		writeFrame(w, lines, height)
+		return
+	}
+
+	sparkWidth := width - 48
+	if sparkWidth < 10 {
+		sparkWidth = 10
+	}
+
+	add("CPU   %5.1f%%  %-*s  load %.2f %.2f %.2f",
+		s.CPU, sparkWidth, d.spark("cpu", sparkWidth, 100), s.Load.Load1, s.Load.Load5, s.Load.Load15)
+	add("MEM   %5.1f%%  %-*s  %s / %s",
+		s.Memory.UsagePerc, sparkWidth, d.spark("mem", sparkWidth, 100),
+		FormatBytes(s.Memory.Used), FormatBytes(s.Memory.Total))
+	add("DISK  %5.1f%%  %-*s  io %s/s",
+		s.Disk.UsagePerc, sparkWidth, d.spark("disk", sparkWidth, 0), FormatBytes(uint64(d.last("disk"))))
+	add("NET RX        %-*s  %s/s", sparkWidth, d.spark("rx", sparkWidth, 0), FormatBytes(uint64(d.last("rx"))))
+	add("NET TX        %-*s  %s/s", sparkWidth, d.spark("tx", sparkWidth, 0), FormatBytes(uint64(d.last("tx"))))
+	add("")
+
+	services := make([]string, 0, len(s.Services))
+	for name := range s.Services {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	var states []string
+	for _, name := range services {
+		color := "\033[32m"
+		if s.Services[name] != "active" {
+			color = "\033[31m"
+		}
+		states = append(states, fmt.Sprintf("%s %s%s\033[0m", name, color, s.Services[name]))
+	}
+	add("Services: %s", strings.Join(states, "   "))
+	add("")
+
+	add("\033[1m%-24s %6s %10s %6s\033[0m", "GROUP", "CPU%", "MEMORY", "PROCS")
+	for _, g := range GroupProcesses(d.processes) {
+		add("%-24s %6.1f %10s %6d", truncate(g.Name, 24), g.CPUPerc, FormatBytes(g.RSS), g.Processes)
+	}
+	add("")
+
+	add("\033[1m%-7s %-16s %-16s %6s %10s\033[0m", "PID", "TOP CPU", "GROUP", "CPU%", "MEMORY")
+	for _, p := range TopProcesses(d.processes, 5, false) {
+		add("%-7d %-16s %-16s %6.1f %10s", p.PID, truncate(p.Name, 16), truncate(p.Group, 16), p.CPUPerc, FormatBytes(p.RSS))
+	}
+	add("\033[1m%-7s %-16s %-16s %6s %10s\033[0m", "PID", "TOP MEMORY", "GROUP", "CPU%", "MEMORY")
+	for _, p := range TopProcesses(d.processes, 5, true) {
+		add("%-7d %-16s %-16s %6.1f %10s", p.PID, truncate(p.Name, 16), truncate(p.Group, 16), p.CPUPerc, FormatBytes(p.RSS))
+	}
+
+	if len(d.sites) > 0 {
+		add("")
+		siteSpark := width - 56
+		if siteSpark < 10 {
+			siteSpark = 10
+		}
+		add("\033[1m%-30s %8s %8s  %s\033[0m", "SITE", "REQ/s", "5XX/s", "REQUESTS")
+		for _, site := range d.sites {
+			add("%-30s %8.1f %8.1f  %s", truncate(site.Domain, 30), site.RequestsSec, site.ServerErrorSec,
+				d.spark("site:"+site.Domain, siteSpark, 0))
+		}
+	}
+
+	writeFrame(w, lines, height)
+}
+
+// last returns the newest value of a metric
+func (d *Dashboard) last(name string) float64 {
+	values := d.history[name]
+	if len(values) == 0 {
+		return 0
+	}
+	return values[len(values)-1]
+}
+
+// writeFrame draws lines from the top left corner, clearing what is left
+// of the previous frame
+func writeFrame(w io.Writer, lines []string, height int) {
+	if height > 0 && len(lines) > height {
+		lines = lines[:height]
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\033[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\033[J")
+	io.WriteString(w, b.String())
+}
+
+// truncate shortens s to n characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
@@ -0,0 +1,279 @@
+package monitoring
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// diskSectorSize is the unit of the sector counters in /proc/diskstats,
+// independent of the device's real sector size
+const diskSectorSize = 512
+
+// MountStats is the usage of a mounted file system holding one or more
+// of the watched directories
+type MountStats struct {
+	MountPoint string
+	Device     string
+	FSType     string
+	Paths      []string // watched directories on this mount
+	DiskStats
+	major, minor uint32
+}
+
+// DiskIOStats is the I/O activity of a block device between two samples
+type DiskIOStats struct {
+	Device         string
+	ReadBytesSec   float64
+	WriteBytesSec  float64
+	ReadsSec       float64
+	WritesSec      float64
+	UtilizationPct float64 // share of time the device was busy
+}
+
+// diskCounters is one line of /proc/diskstats
+type diskCounters struct {
+	major, minor  uint32
+	name          string
+	reads, writes uint64
+	sectorsRead   uint64
+	sectorsWrite  uint64
+	busyMillis    uint64
+}
+
+// mountEntry is one line of the mount table
+type mountEntry struct {
+	device, mountPoint, fsType string
+}
+
+// Mounts returns the usage of every file system holding one of paths.
+// Paths that do not exist are skipped.
+func (c *Collector) Mounts(paths []string) ([]MountStats, error) {
+	table, err := c.readMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	byMount := make(map[string]*MountStats)
+	var order []string
+	for _, path := range paths {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+
+		entry, ok := findMount(table, resolved)
+		if !ok {
+			continue
+		}
+
+		m, ok := byMount[entry.mountPoint]
+		if !ok {
+			usage, err := c.Disk(entry.mountPoint)
+			if err != nil {
+				continue
+			}
+			m = &MountStats{
+				MountPoint: entry.mountPoint,
+				Device:     entry.device,
+				FSType:     entry.fsType,
+				DiskStats:  usage,
+			}
+			m.major, m.minor = deviceNumbers(entry.mountPoint)
+			byMount[entry.mountPoint] = m
+			order = append(order, entry.mountPoint)
+		}
+		m.Paths = append(m.Paths, path)
+	}
+
+	sort.Strings(order)
+	mounts := make([]MountStats, 0, len(order))
+	for _, mountPoint := range order {
+		mounts = append(mounts, *byMount[mountPoint])
+	}
+	return mounts, nil
+}
+
+// readMounts parses the mount table of the current process
+func (c *Collector) readMounts() ([]mountEntry, error) {
+	f, err := os.Open(c.path("self/mounts"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, mountEntry{
+			device:     unescapeMountField(fields[0]),
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+		})
+	}
+
+	return entries, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes the kernel uses for spaces
+// and other special characters in the mount table
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// findMount returns the mount holding path, i.e. the longest mount point
+// that is a prefix of it. Later entries win, as they shadow earlier ones.
+func findMount(table []mountEntry, path string) (mountEntry, bool) {
+	var best mountEntry
+	found := false
+	for _, entry := range table {
+		mp := entry.mountPoint
+		if path != mp && mp != "/" && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if !found || len(mp) >= len(best.mountPoint) {
+			best = entry
+			found = true
+		}
+	}
+	return best, found
+}
+
+// deviceNumbers returns the major and minor number of the device holding
+// path
+func deviceNumbers(path string) (uint32, uint32) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+
+	dev := uint64(st.Dev)
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
+}
+
+// sampleDisks reads /proc/diskstats
+func (c *Collector) sampleDisks() (map[string]diskCounters, error) {
+	f, err := os.Open(c.path("diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := make(map[string]diskCounters)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+
+		values := make([]uint64, 14)
+		for i := 3; i < 14; i++ {
+			values[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		major, _ := strconv.ParseUint(fields[0], 10, 32)
+		minor, _ := strconv.ParseUint(fields[1], 10, 32)
+
+		counters[fields[2]] = diskCounters{
+			major:        uint32(major),
+			minor:        uint32(minor),
+			name:         fields[2],
+			reads:        values[3],
+			sectorsRead:  values[5],
+			writes:       values[7],
+			sectorsWrite: values[9],
+			busyMillis:   values[12],
+		}
+	}
+
+	return counters, scanner.Err()
+}
+
+// diskIOBetween computes the activity of the devices backing mounts from
+// two samples taken elapsed apart
+func diskIOBetween(prev, cur map[string]diskCounters, mounts []MountStats, elapsed time.Duration) []DiskIOStats {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+
+	var result []DiskIOStats
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		var dev diskCounters
+		found := false
+		for _, d := range cur {
+			if d.major == m.major && d.minor == m.minor {
+				dev = d
+				found = true
+				break
+			}
+		}
+		if !found || seen[dev.name] {
+			continue
+		}
+		seen[dev.name] = true
+
+		old, ok := prev[dev.name]
+		if !ok {
+			continue
+		}
+
+		util := float64(counterDelta(old.busyMillis, dev.busyMillis)) / (seconds * 1000) * 100
+		if util > 100 {
+			util = 100
+		}
+		result = append(result, DiskIOStats{
+			Device:         dev.name,
+			ReadBytesSec:   float64(counterDelta(old.sectorsRead, dev.sectorsRead)*diskSectorSize) / seconds,
+			WriteBytesSec:  float64(counterDelta(old.sectorsWrite, dev.sectorsWrite)*diskSectorSize) / seconds,
+			ReadsSec:       float64(counterDelta(old.reads, dev.reads)) / seconds,
+			WritesSec:      float64(counterDelta(old.writes, dev.writes)) / seconds,
+			UtilizationPct: util,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device < result[j].Device
+	})
+	return result
+}
+
+// counterDelta returns the increase of a kernel counter, treating a reset
+// or wrap as no activity
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
@@ -0,0 +1,189 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadMounts(t *testing.T) {
+	table, err := fixtureCollector().readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 7 {
+		t.Fatalf("got %d entries, want 7", len(table))
+	}
+
+	// Spaces are escaped as \040
+	want := mountEntry{device: "/dev/sdc1", mountPoint: "/apps/sites/big site", fsType: "xfs"}
+	if table[4] != want {
+		t.Errorf("got %+v, want %+v", table[4], want)
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	tests := map[string]string{
+		"/plain":         "/plain",
+		`/with\040space`: "/with space",
+		`/tab\011here`:   "/tab\there",
+		`/back\134slash`: `/back\slash`,
+		`/short\04`:      `/short\04`,
+		`/not\08octal`:   `/not\08octal`,
+	}
+	for in, want := range tests {
+		if got := unescapeMountField(in); got != want {
+			t.Errorf("unescapeMountField(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	table, err := fixtureCollector().readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, mountPoint, device string
+	}{
+		{"/", "/", "/dev/sda1"},
+		{"/etc/caddy", "/", "/dev/sda1"},
+		// /apps is mounted twice, the later mount shadows the earlier
+		{"/apps", "/apps", "/dev/sdd1"},
+		{"/apps/sites/example.com", "/apps", "/dev/sdd1"},
+		{"/apps/sites/big site/public", "/apps/sites/big site", "/dev/sdc1"},
+		// A mount point is not a prefix of a sibling directory
+		{"/apps-old", "/", "/dev/sda1"},
+		{"/run/php/php8.2-fpm.sock", "/run", "tmpfs"},
+	}
+	for _, tt := range tests {
+		entry, ok := findMount(table, tt.path)
+		if !ok {
+			t.Errorf("%s: no mount found", tt.path)
+			continue
+		}
+		if entry.mountPoint != tt.mountPoint || entry.device != tt.device {
+			t.Errorf("%s: got %s on %s, want %s on %s", tt.path, entry.device, entry.mountPoint, tt.device, tt.mountPoint)
+		}
+	}
+
+	if _, ok := findMount([]mountEntry{{mountPoint: "/apps"}}, "/backup"); ok {
+		t.Error("found a mount for a path outside the table")
+	}
+}
+
+func TestMounts(t *testing.T) {
+	c := writeProc(t, map[string]string{"self/mounts": "/dev/root / ext4 rw 0 0\n"})
+	dir := t.TempDir()
+
+	mounts, err := c.Mounts([]string{dir, "/does/not/exist", "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both existing paths are on the only mount, the missing one is skipped
+	if len(mounts) != 1 {
+		t.Fatalf("got %d mounts, want 1", len(mounts))
+	}
+	m := mounts[0]
+	if m.MountPoint != "/" || m.Device != "/dev/root" || m.FSType != "ext4" {
+		t.Errorf("got %s on %s (%s)", m.Device, m.MountPoint, m.FSType)
+	}
+	if len(m.Paths) != 2 || m.Paths[0] != dir || m.Paths[1] != "/" {
+		t.Errorf("paths = %v", m.Paths)
+	}
+	if m.Total == 0 {
+		t.Error("usage of / not read")
+	}
+}
+
+func TestSampleDisks(t *testing.T) {
+	counters, err := fixtureCollector().sampleDisks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loop0 has too few columns
+	if _, ok := counters["loop0"]; ok {
+		t.Error("loop0 should be skipped")
+	}
+	if len(counters) != 3 {
+		t.Errorf("got %d devices, want 3", len(counters))
+	}
+
+	want := diskCounters{
+		major: 8, minor: 1, name: "sda1",
+		reads: 900, writes: 1900,
+		sectorsRead: 18000, sectorsWrite: 38000,
+		busyMillis: 1100,
+	}
+	if counters["sda1"] != want {
+		t.Errorf("got %+v, want %+v", counters["sda1"], want)
+	}
+	// Kernels before 4.18 report 14 columns
+	if counters["sdb"].busyMillis != 120 {
+		t.Errorf("sdb busy = %d, want 120", counters["sdb"].busyMillis)
+	}
+}
+
+func TestDiskIOBetween(t *testing.T) {
+	prev, err := fixtureCollector().sampleDisks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur := make(map[string]diskCounters)
+	for name, d := range prev {
+		cur[name] = d
+	}
+	sda1 := prev["sda1"]
+	sda1.reads += 100
+	sda1.sectorsRead += 2000
+	sda1.writes += 50
+	sda1.sectorsWrite += 4000
+	sda1.busyMillis += 500
+	cur["sda1"] = sda1
+	sdb := prev["sdb"]
+	sdb.busyMillis += 5000
+	cur["sdb"] = sdb
+
+	mounts := []MountStats{
+		{MountPoint: "/", major: 8, minor: 1},
+		{MountPoint: "/var", major: 8, minor: 1}, // same device, reported once
+		{MountPoint: "/apps", major: 8, minor: 16},
+		{MountPoint: "/run", major: 0, minor: 25}, // no block device
+	}
+	stats := diskIOBetween(prev, cur, mounts, time.Second)
+	if len(stats) != 2 {
+		t.Fatalf("got %d devices, want 2: %+v", len(stats), stats)
+	}
+
+	want := DiskIOStats{
+		Device:         "sda1",
+		ReadBytesSec:   2000 * diskSectorSize,
+		WriteBytesSec:  4000 * diskSectorSize,
+		ReadsSec:       100,
+		WritesSec:      50,
+		UtilizationPct: 50,
+	}
+	if stats[0] != want {
+		t.Errorf("got %+v, want %+v", stats[0], want)
+	}
+	// Busy time beyond the interval is capped
+	if stats[1].Device != "sdb" || stats[1].UtilizationPct != 100 {
+		t.Errorf("got %+v, want sdb at 100%%", stats[1])
+	}
+
+	if got := diskIOBetween(prev, cur, mounts, 0); got != nil {
+		t.Errorf("got %+v without elapsed time", got)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	if got := counterDelta(10, 25); got != 15 {
+		t.Errorf("got %d, want 15", got)
+	}
+	// A reset or wrap counts as no activity
+	if got := counterDelta(25, 10); got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+}
@@ -0,0 +1,298 @@
+package monitoring
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metricsFileLayout names the daily files of a metrics store
+const metricsFileLayout = "2006-01-02"
+
+// MetricSample is one entry of the metrics history. Keys are short to keep
+// the files small.
+type MetricSample struct {
+	Time        int64   `json:"t"` // unix seconds
+	CPU         float64 `json:"cpu"`
+	Memory      float64 `json:"mem"`
+	Swap        float64 `json:"swap"`
+	Load        float64 `json:"load"`
+	Disk        float64 `json:"disk"` // usage of the fullest watched mount
+	DiskRead    float64 `json:"rd"`
+	DiskWrite   float64 `json:"wr"`
+	NetRx       float64 `json:"rx"`
+	NetTx       float64 `json:"tx"`
+	Connections int     `json:"conn"` // established connections to the watched ports
+}
+
+// NewMetricSample reduces system statistics to a history entry
+func NewMetricSample(at time.Time, stats *SystemStats) MetricSample {
+	sample := MetricSample{
+		Time:   at.Unix(),
+		CPU:    stats.CPU,
+		Memory: stats.Memory.UsagePerc,
+		Load:   stats.Load.Load1,
+		Disk:   stats.Disk.UsagePerc,
+	}
+	if stats.Memory.SwapTotal > 0 {
+		sample.Swap = float64(stats.Memory.SwapUsed) / float64(stats.Memory.SwapTotal) * 100
+	}
+	for _, mount := range stats.Mounts {
+		sample.Disk = math.Max(sample.Disk, mount.UsagePerc)
+	}
+	for _, dev := range stats.DiskIO {
+		sample.DiskRead += dev.ReadBytesSec
+		sample.DiskWrite += dev.WriteBytesSec
+	}
+	for _, iface := range stats.Network {
+		sample.NetRx += iface.RxBytesSec
+		sample.NetTx += iface.TxBytesSec
+	}
+	for _, count := range stats.TCP.Established {
+		sample.Connections += count
+	}
+	return sample
+}
+
+// Metric describes one value of MetricSample for summaries and charts
+type Metric struct {
+	Name   string
+	Label  string
+	Scale  float64 // fixed top of charts, 0 scales to the peak
+	Value  func(MetricSample) float64
+	Format func(float64) string
+}
+
+func formatPercent(v float64) string { return fmt.Sprintf("%.1f%%", v) }
+func formatRate(v float64) string    { return FormatBytes(uint64(v)) + "/s" }
+func formatNumber(v float64) string  { return fmt.Sprintf("%.2f", v) }
+func formatCount(v float64) string   { return fmt.Sprintf("%.0f", v) }
+
+// Metrics lists every recorded metric in display order
+var Metrics = []Metric{
+	{"cpu", "CPU", 100, func(s MetricSample) float64 { return s.CPU }, formatPercent},
+	{"mem", "Memory", 100, func(s MetricSample) float64 { return s.Memory }, formatPercent},
+	{"swap", "Swap", 100, func(s MetricSample) float64 { return s.Swap }, formatPercent},
+	{"load", "Load (1m)", 0, func(s MetricSample) float64 { return s.Load }, formatNumber},
+	{"disk", "Disk", 100, func(s MetricSample) float64 { return s.Disk }, formatPercent},
+	{"rd", "Disk Read", 0, func(s MetricSample) float64 { return s.DiskRead }, formatRate},
+	{"wr", "Disk Write", 0, func(s MetricSample) float64 { return s.DiskWrite }, formatRate},
+	{"rx", "Net RX", 0, func(s MetricSample) float64 { return s.NetRx }, formatRate},
+	{"tx", "Net TX", 0, func(s MetricSample) float64 { return s.NetTx }, formatRate},
+	{"conn", "Connections", 0, func(s MetricSample) float64 { return float64(s.Connections) }, formatCount},
+}
+
+// MetricSummary holds the statistics of one metric over a period
+type MetricSummary struct {
+	Min, Avg, Max, P95 float64
+	Samples            int
+}
+
+// Summarize computes min, average, max and 95th percentile of a metric
+func Summarize(samples []MetricSample, metric Metric) MetricSummary {
+	if len(samples) == 0 {
+		return MetricSummary{}
+	}
+
+	values := make([]float64, len(samples))
+	sum := 0.0
+	for i, s := range samples {
+		values[i] = metric.Value(s)
+		sum += values[i]
+	}
+	sort.Float64s(values)
+
+	// Nearest-rank percentile
+	rank := int(math.Ceil(0.95*float64(len(values)))) - 1
+	return MetricSummary{
+		Min:     values[0],
+		Avg:     sum / float64(len(values)),
+		Max:     values[len(values)-1],
+		P95:     values[max(rank, 0)],
+		Samples: len(values),
+	}
+}
+
+// MetricsStore keeps the metrics history as one JSON lines file per day,
+// so expired data is dropped by deleting whole files
+type MetricsStore struct {
+	Dir       string
+	Retention time.Duration
+}
+
+// NewMetricsStore returns a store in dir keeping retentionDays of history
+func NewMetricsStore(dir string, retentionDays int) *MetricsStore {
+	return &MetricsStore{
+		Dir:       dir,
+		Retention: time.Duration(retentionDays) * 24 * time.Hour,
+	}
+}
+
+// dayPath returns the file holding the samples of the day of t
+func (m *MetricsStore) dayPath(t time.Time) string {
+	return filepath.Join(m.Dir, t.Format(metricsFileLayout)+".jsonl")
+}
+
+// Append adds a sample to the file of its day
+func (m *MetricsStore) Append(sample MetricSample) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.dayPath(time.Unix(sample.Time, 0)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// days returns the dates of the stored files, oldest first
+func (m *MetricsStore) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(m.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var days []time.Time
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		day, err := time.ParseInLocation(metricsFileLayout, name, time.Local)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Prune deletes the files of days that ended before the retention period
+// and returns how many were removed
+func (m *MetricsStore) Prune(now time.Time) (int, error) {
+	if m.Retention <= 0 {
+		return 0, nil
+	}
+
+	days, err := m.days()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := now.Add(-m.Retention)
+	removed := 0
+	for _, day := range days {
+		if !day.AddDate(0, 0, 1).Before(cutoff) {
+			break
+		}
+		if err := os.Remove(m.dayPath(day)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Load returns the samples taken at or after since, oldest first. Lines
+// that cannot be parsed are skipped.
+func (m *MetricsStore) Load(since time.Time) ([]MetricSample, error) {
+	days, err := m.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []MetricSample
+	for _, day := range days {
+		if day.AddDate(0, 0, 1).Before(since) {
+			continue
+		}
+
+		f, err := os.Open(m.dayPath(day))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var sample MetricSample
+			if json.Unmarshal(scanner.Bytes(), &sample) != nil {
+				continue
+			}
+			if sample.Time >= since.Unix() {
+				samples = append(samples, sample)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time < samples[j].Time })
+	return samples, nil
+}
+
+// Chart draws values as an ASCII bar chart of the given size. Values are
+// averaged into width columns and scaled to the highest column, or to max
+// when it is positive.
+func Chart(values []float64, width, height int, max float64) []string {
+	if width <= 0 || height <= 0 || len(values) == 0 {
+		return nil
+	}
+	if len(values) < width {
+		width = len(values)
+	}
+
+	columns := make([]float64, width)
+	for i := range columns {
+		from := i * len(values) / width
+		to := (i + 1) * len(values) / width
+		sum := 0.0
+		for _, v := range values[from:to] {
+			sum += v
+		}
+		columns[i] = sum / float64(to-from)
+	}
+
+	if max <= 0 {
+		for _, v := range columns {
+			max = math.Max(max, v)
+		}
+	}
+
+	rows := make([]string, height)
+	for row := range rows {
+		// Row 0 is the top of the chart
+		threshold := float64(height-row-1) / float64(height) * max
+		var b strings.Builder
+		for _, v := range columns {
+			if max > 0 && v > threshold {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		rows[row] = b.String()
+	}
+	return rows
+}
@@ -3,176 +3,170 @@ package monitoring
 import (
 	"fmt"
 	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 )
 
 type SystemStats struct {
 	CPU      float64
+	Cores    []float64 // usage per core
+	Load     LoadAverage
 	Memory   MemoryStats
 	Disk     DiskStats
+	Mounts   []MountStats
+	DiskIO   []DiskIOStats
+	Network  []InterfaceStats
+	TCP      TCPStats
 	Uptime   time.Duration
 	Services map[string]string // service name -> status
 }
 
 type MemoryStats struct {
 	Total     uint64
-	Used      uint64
+	Used      uint64 // excluding reclaimable buffers and cache
 	Free      uint64
+	Available uint64
+	Buffers   uint64
+	Cached    uint64
 	UsagePerc float64
+	SwapTotal uint64
+	SwapUsed  uint64
+	SwapFree  uint64
 }
 
 type DiskStats struct {
-	Total     uint64
-	Used      uint64
-	Free      uint64
-	UsagePerc float64
+	Total          uint64
+	Used           uint64
+	Free           uint64
+	UsagePerc      float64
+	Inodes         uint64
+	InodesUsed     uint64
+	InodesFree     uint64
+	InodeUsagePerc float64
 }
 
-// GetSystemStats returns current system statistics
-func GetSystemStats() (*SystemStats, error) {
-	stats := &SystemStats{
-		Services: make(map[string]string),
-	}
+// DefaultServices are the units reported when no other list is configured
+var DefaultServices = []string{"caddy", "mariadb"}
 
-	var err error
+// GetSystemStats returns current system statistics, including the file
+// systems holding paths
+func GetSystemStats(paths ...string) (*SystemStats, error) {
+	c := NewCollector()
+	c.Paths = paths
+	return c.Collect()
+}
+
+// Snapshot is a reading of the cumulative kernel counters. Rates are
+// computed from two snapshots.
+type Snapshot struct {
+	Time  time.Time
+	CPU   CPUSample
+	Disks map[string]diskCounters // nil where diskstats is unavailable
+	Net   map[string]InterfaceStats
+}
+
+// Snapshot reads the current CPU, disk and network counters
+func (c *Collector) Snapshot() (*Snapshot, error) {
+	snap := &Snapshot{Time: time.Now()}
 
-	// Get CPU usage
-	if stats.CPU, err = getCPUUsage(); err != nil {
+	var err error
+	if snap.CPU, err = c.SampleCPU(); err != nil {
 		return nil, fmt.Errorf("failed to get CPU usage: %v", err)
 	}
 
-	// Get memory stats
-	if stats.Memory, err = getMemoryStats(); err != nil {
-		return nil, fmt.Errorf("failed to get memory stats: %v", err)
-	}
+	// diskstats is missing in some containers, I/O stats are skipped there
+	snap.Disks, _ = c.sampleDisks()
 
-	// Get disk stats
-	if stats.Disk, err = getDiskStats(); err != nil {
-		return nil, fmt.Errorf("failed to get disk stats: %v", err)
+	if snap.Net, err = c.sampleNetDev(); err != nil {
+		return nil, fmt.Errorf("failed to get network stats: %v", err)
 	}
 
-	// Get uptime
-	if stats.Uptime, err = getUptime(); err != nil {
-		return nil, fmt.Errorf("failed to get uptime: %v", err)
-	}
+	return snap, nil
+}
 
-	// Get service status
-	services := []string{"caddy", "mariadb"}
-	for _, service := range services {
-		status, _ := getServiceStatus(service)
-		stats.Services[service] = status
+// Collect gathers all system statistics, sampling counters over the
+// collector interval
+func (c *Collector) Collect() (*SystemStats, error) {
+	prev, err := c.Snapshot()
+	if err != nil {
+		return nil, err
 	}
 
-	return stats, nil
-}
+	time.Sleep(c.Interval)
 
-// getCPUUsage returns current CPU usage percentage
-func getCPUUsage() (float64, error) {
-	cmd := exec.Command("top", "-bn1")
-	output, err := cmd.Output()
+	cur, err := c.Snapshot()
 	if err != nil {
-		return 0, err
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Cpu(s)") {
-			fields := strings.Fields(line)
-			for _, field := range fields {
-				if strings.HasSuffix(field, "id,") {
-					idle, err := strconv.ParseFloat(strings.TrimSuffix(field, "id,"), 64)
-					if err != nil {
-						return 0, err
-					}
-					return 100.0 - idle, nil
-				}
-			}
-		}
-	}
-
-	return 0, fmt.Errorf("CPU usage not found in top output")
+		return nil, err
+	}
+
+	return c.CollectBetween(prev, cur)
 }
 
-// getMemoryStats returns current memory statistics
-func getMemoryStats() (MemoryStats, error) {
-	stats := MemoryStats{}
-	cmd := exec.Command("free", "-b")
-	output, err := cmd.Output()
-	if err != nil {
-		return stats, err
+// CollectBetween gathers all system statistics, computing rates from two
+// snapshots
+func (c *Collector) CollectBetween(prev, cur *Snapshot) (*SystemStats, error) {
+	stats := &SystemStats{
+		Services: make(map[string]string),
 	}
+	elapsed := cur.Time.Sub(prev.Time)
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "Mem:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 4 {
-				stats.Total, _ = strconv.ParseUint(fields[1], 10, 64)
-				stats.Used, _ = strconv.ParseUint(fields[2], 10, 64)
-				stats.Free, _ = strconv.ParseUint(fields[3], 10, 64)
-				stats.UsagePerc = float64(stats.Used) / float64(stats.Total) * 100
-				return stats, nil
-			}
-		}
+	cpu := CPUUsageBetween(prev.CPU, cur.CPU)
+	stats.CPU = cpu.UsagePerc
+	stats.Cores = cpu.Cores
+
+	// Get file systems of the watched directories
+	var err error
+	if stats.Mounts, err = c.Mounts(c.Paths); err != nil {
+		return nil, fmt.Errorf("failed to get mounts: %v", err)
+	}
+	if prev.Disks != nil && cur.Disks != nil {
+		stats.DiskIO = diskIOBetween(prev.Disks, cur.Disks, stats.Mounts, elapsed)
 	}
 
-	return stats, fmt.Errorf("memory stats not found in free output")
-}
+	// Get network stats
+	stats.Network = networkBetween(prev.Net, cur.Net, elapsed)
+	if stats.TCP, err = c.TCP(c.Ports); err != nil {
+		return nil, fmt.Errorf("failed to get TCP connections: %v", err)
+	}
 
-// getDiskStats returns current disk usage statistics
-func getDiskStats() (DiskStats, error) {
-	stats := DiskStats{}
-	cmd := exec.Command("df", "-B1", "/")
-	output, err := cmd.Output()
-	if err != nil {
-		return stats, err
+	// Get load average
+	if stats.Load, err = c.LoadAverage(); err != nil {
+		return nil, fmt.Errorf("failed to get load average: %v", err)
 	}
 
-	lines := strings.Split(string(output), "\n")
-	if len(lines) >= 2 {
-		fields := strings.Fields(lines[1])
-		if len(fields) >= 4 {
-			stats.Total, _ = strconv.ParseUint(fields[1], 10, 64)
-			stats.Used, _ = strconv.ParseUint(fields[2], 10, 64)
-			stats.Free, _ = strconv.ParseUint(fields[3], 10, 64)
-			stats.UsagePerc = float64(stats.Used) / float64(stats.Total) * 100
-			return stats, nil
-		}
+	// Get memory stats
+	if stats.Memory, err = c.Memory(); err != nil {
+		return nil, fmt.Errorf("failed to get memory stats: %v", err)
 	}
 
-	return stats, fmt.Errorf("disk stats not found in df output")
-}
+	// Get disk stats
+	if stats.Disk, err = c.Disk("/"); err != nil {
+		return nil, fmt.Errorf("failed to get disk stats: %v", err)
+	}
 
-// getUptime returns system uptime
-func getUptime() (time.Duration, error) {
-	cmd := exec.Command("cat", "/proc/uptime")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
+	// Get uptime
+	if stats.Uptime, err = c.Uptime(); err != nil {
+		return nil, fmt.Errorf("failed to get uptime: %v", err)
 	}
 
-	fields := strings.Fields(string(output))
-	if len(fields) > 0 {
-		uptime, err := strconv.ParseFloat(fields[0], 64)
-		if err != nil {
-			return 0, err
-		}
-		return time.Duration(uptime) * time.Second, nil
+	// Get service status
+	for _, service := range c.Services {
+		stats.Services[service] = ServiceStatus(service)
 	}
 
-	return 0, fmt.Errorf("uptime not found")
+	return stats, nil
 }
 
-// getServiceStatus returns the status of a system service
-func getServiceStatus(service string) (string, error) {
-	cmd := exec.Command("systemctl", "is-active", service)
-	output, err := cmd.Output()
-	if err != nil {
-		return "inactive", nil
+// ServiceStatus returns the state of a systemd unit, e.g. active, failed
+// or inactive
+func ServiceStatus(service string) string {
+	// is-active exits non-zero for every state but active, the state is
+	// still printed
+	output, _ := exec.Command("systemctl", "is-active", service).Output()
+	if status := strings.TrimSpace(string(output)); status != "" {
+		return status
 	}
-	return strings.TrimSpace(string(output)), nil
+	return "inactive"
 }
 
 // FormatBytes formats bytes into human readable format
@@ -0,0 +1,186 @@
+package monitoring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWatchedPorts are the local ports whose established connections are
+// counted: HTTP, HTTPS and MariaDB
+var DefaultWatchedPorts = []int{80, 443, 3306}
+
+// tcpStates maps the state codes of /proc/net/tcp to their names
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// InterfaceStats holds the counters of a network interface and their rates
+// between two samples
+type InterfaceStats struct {
+	Name         string
+	RxBytes      uint64
+	RxPackets    uint64
+	RxErrors     uint64
+	RxDropped    uint64
+	TxBytes      uint64
+	TxPackets    uint64
+	TxErrors     uint64
+	TxDropped    uint64
+	RxBytesSec   float64
+	TxBytesSec   float64
+	RxPacketsSec float64
+	TxPacketsSec float64
+}
+
+// TCPStats counts TCP connections over IPv4 and IPv6
+type TCPStats struct {
+	States      map[string]int // connections per state
+	Established map[int]int    // established connections per watched local port
+}
+
+// sampleNetDev reads the interface counters from /proc/net/dev
+func (c *Collector) sampleNetDev() (map[string]InterfaceStats, error) {
+	f, err := os.Open(c.path("net/dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	interfaces := make(map[string]InterfaceStats)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The first two lines are headers without a colon
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 16 {
+			continue
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+
+		name = strings.TrimSpace(name)
+		interfaces[name] = InterfaceStats{
+			Name:      name,
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+		}
+	}
+
+	return interfaces, scanner.Err()
+}
+
+// networkBetween returns the current counters of every interface except
+// loopback, with rates computed from the previous sample
+func networkBetween(prev, cur map[string]InterfaceStats, elapsed time.Duration) []InterfaceStats {
+	seconds := elapsed.Seconds()
+
+	var result []InterfaceStats
+	for name, iface := range cur {
+		if name == "lo" {
+			continue
+		}
+		if old, ok := prev[name]; ok && seconds > 0 {
+			iface.RxBytesSec = float64(counterDelta(old.RxBytes, iface.RxBytes)) / seconds
+			iface.TxBytesSec = float64(counterDelta(old.TxBytes, iface.TxBytes)) / seconds
+			iface.RxPacketsSec = float64(counterDelta(old.RxPackets, iface.RxPackets)) / seconds
+			iface.TxPacketsSec = float64(counterDelta(old.TxPackets, iface.TxPackets)) / seconds
+		}
+		result = append(result, iface)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// TCP counts the TCP connections in /proc/net/tcp and /proc/net/tcp6 by
+// state, and the established connections to each of ports
+func (c *Collector) TCP(ports []int) (TCPStats, error) {
+	stats := TCPStats{
+		States:      make(map[string]int),
+		Established: make(map[int]int),
+	}
+
+	watched := make(map[int]bool)
+	for _, port := range ports {
+		watched[port] = true
+		stats.Established[port] = 0
+	}
+
+	found := false
+	for _, name := range []string{"net/tcp", "net/tcp6"} {
+		f, err := os.Open(c.path(name))
+		if err != nil {
+			// tcp6 is missing when IPv6 is disabled
+			continue
+		}
+		found = true
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+
+			state, ok := tcpStates[strings.ToUpper(fields[3])]
+			if !ok {
+				state = "UNKNOWN"
+			}
+			stats.States[state]++
+
+			if state != "ESTABLISHED" {
+				continue
+			}
+			// local_address is ADDR:PORT in hex
+			_, portHex, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			port, err := strconv.ParseUint(portHex, 16, 16)
+			if err == nil && watched[int(port)] {
+				stats.Established[int(port)]++
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	if !found {
+		return stats, fmt.Errorf("%s not found", c.path("net/tcp"))
+	}
+	return stats, nil
+}
@@ -0,0 +1,114 @@
+package monitoring
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleNetDev(t *testing.T) {
+	interfaces, err := fixtureCollector().sampleNetDev()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wlan0 has too few columns
+	if len(interfaces) != 2 {
+		t.Fatalf("got %d interfaces, want 2: %v", len(interfaces), interfaces)
+	}
+
+	want := InterfaceStats{
+		Name:      "eth0",
+		RxBytes:   9000000,
+		RxPackets: 12000,
+		RxErrors:  2,
+		RxDropped: 5,
+		TxBytes:   4000000,
+		TxPackets: 8000,
+		TxErrors:  1,
+		TxDropped: 3,
+	}
+	if interfaces["eth0"] != want {
+		t.Errorf("got %+v, want %+v", interfaces["eth0"], want)
+	}
+}
+
+func TestNetworkBetween(t *testing.T) {
+	prev, err := fixtureCollector().sampleNetDev()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur := make(map[string]InterfaceStats)
+	for name, iface := range prev {
+		cur[name] = iface
+	}
+	eth0 := prev["eth0"]
+	eth0.RxBytes += 20000
+	eth0.TxBytes += 10000
+	eth0.RxPackets += 40
+	eth0.TxPackets += 20
+	cur["eth0"] = eth0
+	// An interface that appeared since the previous sample has no rates
+	cur["eth1"] = InterfaceStats{Name: "eth1", RxBytes: 100}
+
+	stats := networkBetween(prev, cur, 2*time.Second)
+	if len(stats) != 2 || stats[0].Name != "eth0" || stats[1].Name != "eth1" {
+		t.Fatalf("got %+v, want eth0 and eth1 without lo", stats)
+	}
+	got := stats[0]
+	if got.RxBytesSec != 10000 || got.TxBytesSec != 5000 || got.RxPacketsSec != 20 || got.TxPacketsSec != 10 {
+		t.Errorf("eth0 rates = %v/%v bytes, %v/%v packets", got.RxBytesSec, got.TxBytesSec, got.RxPacketsSec, got.TxPacketsSec)
+	}
+	if got.RxBytes != eth0.RxBytes {
+		t.Errorf("eth0 rx bytes = %d, want the current counter %d", got.RxBytes, eth0.RxBytes)
+	}
+	if stats[1].RxBytesSec != 0 {
+		t.Errorf("eth1 rx rate = %v, want 0", stats[1].RxBytesSec)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	stats, err := fixtureCollector().TCP([]int{80, 443, 3306, 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStates := map[string]int{
+		"LISTEN":       3,
+		"ESTABLISHED":  4,
+		"TIME_WAIT":    1,
+		"NEW_SYN_RECV": 1,
+		"UNKNOWN":      1,
+	}
+	if !reflect.DeepEqual(stats.States, wantStates) {
+		t.Errorf("states = %v, want %v", stats.States, wantStates)
+	}
+
+	// Only established connections to watched local ports count, over IPv4
+	// and IPv6. Watched ports without connections are reported as 0.
+	wantEstablished := map[int]int{80: 1, 443: 1, 3306: 1, 8080: 0}
+	if !reflect.DeepEqual(stats.Established, wantEstablished) {
+		t.Errorf("established = %v, want %v", stats.Established, wantEstablished)
+	}
+}
+
+func TestTCPWithoutIPv6(t *testing.T) {
+	c := writeProc(t, map[string]string{"net/tcp": `  sl  local_address rem_address   st
+   0: 0A00000F:0050 0A000001:C350 01
+`})
+	stats, err := c.TCP([]int{80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Established[80] != 1 {
+		t.Errorf("established = %v", stats.Established)
+	}
+}
+
+func TestTCPMissing(t *testing.T) {
+	c := &Collector{ProcRoot: t.TempDir()}
+	if _, err := c.TCP(nil); err == nil {
+		t.Error("expected an error without net/tcp")
+	}
+}
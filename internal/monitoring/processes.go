@@ -0,0 +1,198 @@
+package monitoring
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit of the CPU time fields in /proc/<pid>/stat. It is
+// fixed at 100 for user space on every architecture Linux supports.
+const userHZ = 100
+
+// Process groups shown by the dashboard
+const (
+	GroupCaddy   = "caddy"
+	GroupMariaDB = "mariadb"
+	GroupPHPFPM  = "php-fpm"
+	GroupOther   = "other"
+)
+
+// ProcessStats is the resource usage of one process between two samples
+type ProcessStats struct {
+	PID     int
+	Name    string
+	Group   string // caddy, mariadb, php-fpm:<pool> or other
+	CPUPerc float64
+	RSS     uint64
+}
+
+// ProcessGroup sums the usage of the processes of one group
+type ProcessGroup struct {
+	Name      string
+	Processes int
+	CPUPerc   float64
+	RSS       uint64
+}
+
+// processCounters is one reading of /proc/<pid>/stat
+type processCounters struct {
+	name  string
+	group string
+	ticks uint64
+	rss   uint64
+}
+
+// ProcessSample is a reading of every process at one point in time
+type ProcessSample struct {
+	Time      time.Time
+	processes map[int]processCounters
+}
+
+// SampleProcesses reads the CPU time and memory of every process.
+// Processes that exit while being read are skipped.
+func (c *Collector) SampleProcesses() (*ProcessSample, error) {
+	entries, err := os.ReadDir(c.ProcRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &ProcessSample{
+		Time:      time.Now(),
+		processes: make(map[int]processCounters),
+	}
+	pageSize := uint64(os.Getpagesize())
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(c.path(entry.Name() + "/stat"))
+		if err != nil {
+			continue
+		}
+
+		// The command name is in parentheses and may contain spaces
+		stat := string(data)
+		open := strings.IndexByte(stat, '(')
+		end := strings.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		name := stat[open+1 : end]
+		fields := strings.Fields(stat[end+1:])
+		// fields[0] is the state, utime and stime are fields 14 and 15 and
+		// rss is field 24 of the full line
+		if len(fields) < 22 {
+			continue
+		}
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+		cmdline, _ := os.ReadFile(c.path(entry.Name() + "/cmdline"))
+		sample.processes[pid] = processCounters{
+			name:  name,
+			group: processGroup(name, string(cmdline)),
+			ticks: utime + stime,
+			rss:   rss * pageSize,
+		}
+	}
+
+	return sample, nil
+}
+
+// processGroup assigns a process to one of the dashboard groups. PHP-FPM
+// workers name their pool in the process title, e.g. "php-fpm: pool www".
+func processGroup(name, cmdline string) string {
+	title := strings.TrimSpace(strings.ReplaceAll(cmdline, "\x00", " "))
+	switch {
+	case name == "caddy":
+		return GroupCaddy
+	case name == "mariadbd" || name == "mysqld" || name == "mariadb":
+		return GroupMariaDB
+	case strings.HasPrefix(name, "php-fpm"):
+		if _, pool, ok := strings.Cut(title, "pool "); ok {
+			return GroupPHPFPM + ":" + strings.TrimSpace(pool)
+		}
+		return GroupPHPFPM
+	default:
+		return GroupOther
+	}
+}
+
+// ProcessesBetween computes the usage of every process present in both
+// samples
+func ProcessesBetween(prev, cur *ProcessSample) []ProcessStats {
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+
+	result := make([]ProcessStats, 0, len(cur.processes))
+	for pid, p := range cur.processes {
+		stats := ProcessStats{
+			PID:   pid,
+			Name:  p.name,
+			Group: p.group,
+			RSS:   p.rss,
+		}
+		if old, ok := prev.processes[pid]; ok && seconds > 0 {
+			stats.CPUPerc = float64(counterDelta(old.ticks, p.ticks)) / userHZ / seconds * 100
+		}
+		result = append(result, stats)
+	}
+	return result
+}
+
+// TopProcesses returns the n processes using the most CPU, or the most
+// memory when byMemory is set
+func TopProcesses(processes []ProcessStats, n int, byMemory bool) []ProcessStats {
+	sorted := make([]ProcessStats, len(processes))
+	copy(sorted, processes)
+	sort.Slice(sorted, func(i, j int) bool {
+		if byMemory {
+			return sorted[i].RSS > sorted[j].RSS
+		}
+		if sorted[i].CPUPerc != sorted[j].CPUPerc {
+			return sorted[i].CPUPerc > sorted[j].CPUPerc
+		}
+		return sorted[i].RSS > sorted[j].RSS
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// GroupProcesses sums process usage per group, highest CPU first. Processes
+// outside the panel services are left out.
+func GroupProcesses(processes []ProcessStats) []ProcessGroup {
+	groups := make(map[string]*ProcessGroup)
+	for _, p := range processes {
+		if p.Group == GroupOther {
+			continue
+		}
+		g, ok := groups[p.Group]
+		if !ok {
+			g = &ProcessGroup{Name: p.Group}
+			groups[p.Group] = g
+		}
+		g.Processes++
+		g.CPUPerc += p.CPUPerc
+		g.RSS += p.RSS
+	}
+
+	result := make([]ProcessGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CPUPerc != result[j].CPUPerc {
+			return result[i].CPUPerc > result[j].CPUPerc
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,330 @@
+package monitoring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultProcRoot is where the kernel mounts procfs
+const DefaultProcRoot = "/proc"
+
+// DefaultSampleInterval is how long CPU usage is sampled for
+const DefaultSampleInterval = 500 * time.Millisecond
+
+// Collector reads system statistics straight from procfs and statfs.
+// ProcRoot can point at a directory of fixture files instead of /proc.
+type Collector struct {
+	ProcRoot string
+	Interval time.Duration
+	Paths    []string // directories whose file systems are reported
+	Ports    []int    // local ports whose established connections are counted
+	Services []string // systemd units whose state is reported
+}
+
+// NewCollector returns a collector reading the live /proc
+func NewCollector() *Collector {
+	return &Collector{
+		ProcRoot: DefaultProcRoot,
+		Interval: DefaultSampleInterval,
+		Ports:    DefaultWatchedPorts,
+		Services: DefaultServices,
+	}
+}
+
+// CPUTimes holds the cumulative jiffies of a CPU line in /proc/stat
+type CPUTimes struct {
+	User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal uint64
+}
+
+// Total returns the sum of all counted jiffies
+func (t CPUTimes) Total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.IOWait + t.IRQ + t.SoftIRQ + t.Steal
+}
+
+// idle returns the jiffies spent doing nothing, including waiting for I/O
+func (t CPUTimes) idle() uint64 {
+	return t.Idle + t.IOWait
+}
+
+// CPUSample is one reading of /proc/stat
+type CPUSample struct {
+	Total CPUTimes
+	Cores []CPUTimes
+}
+
+// CPUStats is the CPU usage between two samples
+type CPUStats struct {
+	UsagePerc float64
+	Cores     []float64 // usage per core
+}
+
+// LoadAverage is the content of /proc/loadavg
+type LoadAverage struct {
+	Load1     float64
+	Load5     float64
+	Load15    float64
+	Running   int
+	Processes int
+}
+
+// path returns the path of a file below the procfs root
+func (c *Collector) path(name string) string {
+	return filepath.Join(c.ProcRoot, name)
+}
+
+// SampleCPU reads the current CPU counters
+func (c *Collector) SampleCPU() (CPUSample, error) {
+	sample := CPUSample{}
+
+	f, err := os.Open(c.path("stat"))
+	if err != nil {
+		return sample, err
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		times := parseCPUTimes(fields[1:])
+		if fields[0] == "cpu" {
+			sample.Total = times
+			found = true
+		} else {
+			sample.Cores = append(sample.Cores, times)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sample, err
+	}
+	if !found {
+		return sample, fmt.Errorf("cpu line not found in %s", c.path("stat"))
+	}
+
+	return sample, nil
+}
+
+// parseCPUTimes parses the counters of a cpu line. Kernels before 2.6.11
+// report fewer columns, missing ones stay zero.
+func parseCPUTimes(fields []string) CPUTimes {
+	values := make([]uint64, 8)
+	for i := 0; i < len(fields) && i < len(values); i++ {
+		values[i], _ = strconv.ParseUint(fields[i], 10, 64)
+	}
+	return CPUTimes{
+		User:    values[0],
+		Nice:    values[1],
+		System:  values[2],
+		Idle:    values[3],
+		IOWait:  values[4],
+		IRQ:     values[5],
+		SoftIRQ: values[6],
+		Steal:   values[7],
+	}
+}
+
+// CPUUsageBetween computes the CPU usage from two samples
+func CPUUsageBetween(prev, cur CPUSample) CPUStats {
+	stats := CPUStats{UsagePerc: usageBetween(prev.Total, cur.Total)}
+	for i := range cur.Cores {
+		if i < len(prev.Cores) {
+			stats.Cores = append(stats.Cores, usageBetween(prev.Cores[i], cur.Cores[i]))
+		}
+	}
+	return stats
+}
+
+func usageBetween(prev, cur CPUTimes) float64 {
+	total := float64(cur.Total()) - float64(prev.Total())
+	if total <= 0 {
+		return 0
+	}
+	idle := float64(cur.idle()) - float64(prev.idle())
+	usage := (total - idle) / total * 100
+	if usage < 0 {
+		return 0
+	}
+	return usage
+}
+
+// CPU samples /proc/stat over the collector interval
+func (c *Collector) CPU() (CPUStats, error) {
+	prev, err := c.SampleCPU()
+	if err != nil {
+		return CPUStats{}, err
+	}
+
+	time.Sleep(c.Interval)
+
+	cur, err := c.SampleCPU()
+	if err != nil {
+		return CPUStats{}, err
+	}
+
+	return CPUUsageBetween(prev, cur), nil
+}
+
+// Memory reads /proc/meminfo. Used memory excludes buffers and page cache
+// the kernel can reclaim.
+func (c *Collector) Memory() (MemoryStats, error) {
+	stats := MemoryStats{}
+
+	values, err := c.readMeminfo()
+	if err != nil {
+		return stats, err
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok || total == 0 {
+		return stats, fmt.Errorf("MemTotal not found in %s", c.path("meminfo"))
+	}
+
+	stats.Total = total
+	stats.Free = values["MemFree"]
+	stats.Buffers = values["Buffers"]
+	stats.Cached = values["Cached"] + values["SReclaimable"]
+
+	// MemAvailable exists since Linux 3.14, estimate it on older kernels
+	if available, ok := values["MemAvailable"]; ok {
+		stats.Available = available
+	} else {
+		stats.Available = stats.Free + stats.Buffers + stats.Cached
+	}
+	if stats.Available > total {
+		stats.Available = total
+	}
+
+	stats.Used = total - stats.Available
+	stats.UsagePerc = float64(stats.Used) / float64(total) * 100
+
+	stats.SwapTotal = values["SwapTotal"]
+	stats.SwapFree = values["SwapFree"]
+	if stats.SwapTotal > stats.SwapFree {
+		stats.SwapUsed = stats.SwapTotal - stats.SwapFree
+	}
+
+	return stats, nil
+}
+
+// readMeminfo returns the fields of /proc/meminfo in bytes
+func (c *Collector) readMeminfo() (map[string]uint64, error) {
+	f, err := os.Open(c.path("meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			value *= 1024
+		}
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// LoadAverage reads /proc/loadavg
+func (c *Collector) LoadAverage() (LoadAverage, error) {
+	load := LoadAverage{}
+
+	data, err := os.ReadFile(c.path("loadavg"))
+	if err != nil {
+		return load, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return load, fmt.Errorf("unexpected format of %s", c.path("loadavg"))
+	}
+
+	if load.Load1, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return load, err
+	}
+	if load.Load5, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return load, err
+	}
+	if load.Load15, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return load, err
+	}
+
+	// The fourth field is "running/total"
+	if running, total, ok := strings.Cut(fields[3], "/"); ok {
+		load.Running, _ = strconv.Atoi(running)
+		load.Processes, _ = strconv.Atoi(total)
+	}
+
+	return load, nil
+}
+
+// Uptime reads /proc/uptime
+func (c *Collector) Uptime() (time.Duration, error) {
+	data, err := os.ReadFile(c.path("uptime"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("uptime not found in %s", c.path("uptime"))
+	}
+
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Disk returns the usage of the file system holding path
+func (c *Collector) Disk(path string) (DiskStats, error) {
+	stats := DiskStats{}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return stats, err
+	}
+
+	bsize := uint64(st.Bsize)
+	stats.Total = st.Blocks * bsize
+	stats.Free = st.Bavail * bsize
+	// Blocks reserved for root count as used, matching df
+	stats.Used = (st.Blocks - st.Bfree) * bsize
+	if size := stats.Used + stats.Free; size > 0 {
+		stats.UsagePerc = float64(stats.Used) / float64(size) * 100
+	}
+
+	// Some file systems, e.g. btrfs, do not have a fixed number of inodes
+	stats.Inodes = st.Files
+	stats.InodesFree = st.Ffree
+	if st.Files > 0 {
+		stats.InodesUsed = st.Files - st.Ffree
+		stats.InodeUsagePerc = float64(stats.InodesUsed) / float64(st.Files) * 100
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,186 @@
+package monitoring
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixtureCollector reads the procfs fixture files in testdata/proc
+func fixtureCollector() *Collector {
+	return &Collector{ProcRoot: filepath.Join("testdata", "proc")}
+}
+
+// writeProc writes procfs files to a temporary root
+func writeProc(t *testing.T, files map[string]string) *Collector {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Collector{ProcRoot: root}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSampleCPU(t *testing.T) {
+	sample, err := fixtureCollector().SampleCPU()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := CPUTimes{User: 1000, Nice: 50, System: 300, Idle: 8000, IOWait: 400, IRQ: 20, SoftIRQ: 30}
+	if sample.Total != want {
+		t.Errorf("total = %+v, want %+v", sample.Total, want)
+	}
+	if len(sample.Cores) != 2 {
+		t.Fatalf("got %d cores, want 2", len(sample.Cores))
+	}
+	if sample.Cores[1].Idle != 4100 {
+		t.Errorf("cpu1 idle = %d, want 4100", sample.Cores[1].Idle)
+	}
+}
+
+func TestSampleCPUWithoutCPULine(t *testing.T) {
+	c := writeProc(t, map[string]string{"stat": "intr 1 2 3\nctxt 4\n"})
+	if _, err := c.SampleCPU(); err == nil {
+		t.Error("expected an error without a cpu line")
+	}
+}
+
+func TestParseCPUTimesOldKernel(t *testing.T) {
+	// Kernels before 2.6.11 report four columns
+	got := parseCPUTimes([]string{"10", "20", "30", "40"})
+	want := CPUTimes{User: 10, Nice: 20, System: 30, Idle: 40}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCPUUsageBetween(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur CPUTimes
+		want      float64
+	}{
+		{"half busy", CPUTimes{User: 100, Idle: 100}, CPUTimes{User: 150, Idle: 150}, 50},
+		{"iowait is idle", CPUTimes{User: 0, IOWait: 0}, CPUTimes{User: 25, IOWait: 75}, 25},
+		{"no time passed", CPUTimes{User: 10}, CPUTimes{User: 10}, 0},
+		{"counter reset", CPUTimes{User: 500, Idle: 500}, CPUTimes{User: 10, Idle: 10}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := CPUSample{Total: tt.prev, Cores: []CPUTimes{tt.prev}}
+			cur := CPUSample{Total: tt.cur, Cores: []CPUTimes{tt.cur, tt.cur}}
+			stats := CPUUsageBetween(prev, cur)
+			if !almostEqual(stats.UsagePerc, tt.want) {
+				t.Errorf("usage = %v, want %v", stats.UsagePerc, tt.want)
+			}
+			// Cores missing from the previous sample are left out
+			if len(stats.Cores) != 1 || !almostEqual(stats.Cores[0], tt.want) {
+				t.Errorf("cores = %v, want [%v]", stats.Cores, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	stats, err := fixtureCollector().Memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const kB = 1024
+	if stats.Total != 2048000*kB {
+		t.Errorf("total = %d", stats.Total)
+	}
+	if stats.Available != 1024000*kB || stats.Used != 1024000*kB {
+		t.Errorf("available = %d, used = %d", stats.Available, stats.Used)
+	}
+	if !almostEqual(stats.UsagePerc, 50) {
+		t.Errorf("usage = %v, want 50", stats.UsagePerc)
+	}
+	// Reclaimable slab counts as cache
+	if stats.Cached != (307200+51200)*kB {
+		t.Errorf("cached = %d", stats.Cached)
+	}
+	if stats.SwapUsed != 256000*kB {
+		t.Errorf("swap used = %d", stats.SwapUsed)
+	}
+}
+
+func TestMemoryWithoutMemAvailable(t *testing.T) {
+	c := writeProc(t, map[string]string{"meminfo": `MemTotal:        1000 kB
+MemFree:          100 kB
+Buffers:          200 kB
+Cached:           300 kB
+`})
+	stats, err := c.Memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Available != 600*1024 || stats.Used != 400*1024 {
+		t.Errorf("available = %d, used = %d", stats.Available, stats.Used)
+	}
+}
+
+func TestMemoryWithoutMemTotal(t *testing.T) {
+	c := writeProc(t, map[string]string{"meminfo": "MemFree: 100 kB\n"})
+	if _, err := c.Memory(); err == nil {
+		t.Error("expected an error without MemTotal")
+	}
+}
+
+func TestLoadAverage(t *testing.T) {
+	load, err := fixtureCollector().LoadAverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LoadAverage{Load1: 0.52, Load5: 0.38, Load15: 0.25, Running: 3, Processes: 412}
+	if load != want {
+		t.Errorf("got %+v, want %+v", load, want)
+	}
+}
+
+func TestLoadAverageMalformed(t *testing.T) {
+	for _, content := range []string{"", "0.1 0.2\n", "x 0.2 0.3 1/2 5\n"} {
+		c := writeProc(t, map[string]string{"loadavg": content})
+		if _, err := c.LoadAverage(); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	uptime, err := fixtureCollector().Uptime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 26*time.Hour + 3*time.Minute + 4*time.Second + 250*time.Millisecond
+	if uptime != want {
+		t.Errorf("got %v, want %v", uptime, want)
+	}
+}
+
+func TestMissingProcFiles(t *testing.T) {
+	c := &Collector{ProcRoot: t.TempDir()}
+	if _, err := c.SampleCPU(); err == nil {
+		t.Error("SampleCPU: expected an error")
+	}
+	if _, err := c.Memory(); err == nil {
+		t.Error("Memory: expected an error")
+	}
+	if _, err := c.Uptime(); err == nil {
+		t.Error("Uptime: expected an error")
+	}
+}
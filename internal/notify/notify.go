@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// Message is a notification sent through one or more channels
+type Message struct {
+	Event   string            `json:"event"`
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"`
+	Host    string            `json:"host"`
+	Time    time.Time         `json:"time"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Notifier delivers messages through one channel
+type Notifier interface {
+	Name() string
+	Send(msg Message) error
+}
+
+// NewMessage returns a message stamped with the host name and current time
+func NewMessage(event, subject, body string) Message {
+	host, _ := os.Hostname()
+	return Message{
+		Event:   event,
+		Subject: subject,
+		Body:    body,
+		Host:    host,
+		Time:    time.Now(),
+		Fields:  make(map[string]string),
+	}
+}
+
+// FromConfig builds the notifiers enabled in the configuration
+func FromConfig(cfg config.NotificationConfig) []Notifier {
+	var notifiers []Notifier
+
+	if cfg.Sendmail.Enabled && len(cfg.Sendmail.To) > 0 {
+		notifiers = append(notifiers, &Sendmail{
+			Path: cfg.Sendmail.Path,
+			From: cfg.Sendmail.From,
+			To:   cfg.Sendmail.To,
+		})
+	}
+
+	if cfg.SMTP.Enabled && cfg.SMTP.Host != "" && len(cfg.SMTP.To) > 0 {
+		notifiers = append(notifiers, &SMTP{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			To:       cfg.SMTP.To,
+		})
+	}
+
+	if cfg.Webhook.Enabled && cfg.Webhook.URL != "" {
+		notifiers = append(notifiers, &Webhook{
+			URL:     cfg.Webhook.URL,
+			Headers: cfg.Webhook.Headers,
+		})
+	}
+
+	if cfg.LogFile.Enabled {
+		path := cfg.LogFile.Path
+		if path == "" {
+			path = filepath.Join(config.GetLogDir(), "alerts.log")
+		}
+		notifiers = append(notifiers, &LogFile{Path: path})
+	}
+
+	return notifiers
+}
+
+// SendAll delivers a message through every notifier, collecting failures
+func SendAll(notifiers []Notifier, msg Message) error {
+	var failures []string
+	for _, n := range notifiers {
+		if err := n.Send(msg); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", n.Name(), err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send notification: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Sendmail delivers messages through the local sendmail binary
+type Sendmail struct {
+	Path string
+	From string
+	To   []string
+}
+
+// Name returns the channel name
+func (s *Sendmail) Name() string {
+	return "sendmail"
+}
+
+// Send pipes the message to sendmail
+func (s *Sendmail) Send(msg Message) error {
+	path := s.Path
+	if path == "" {
+		path = "/usr/sbin/sendmail"
+	}
+
+	var mail bytes.Buffer
+	if s.From != "" {
+		fmt.Fprintf(&mail, "From: %s\r\n", s.From)
+	}
+	writeMail(&mail, s.To, msg)
+
+	cmd := exec.Command(path, append([]string{"-t", "-oi"}, s.To...)...)
+	cmd.Stdin = &mail
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// SMTP delivers messages through an SMTP server
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Name returns the channel name
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+// Send delivers the message. Credentials are only sent when a username is
+// configured.
+func (s *SMTP) Send(msg Message) error {
+	port := s.Port
+	if port == 0 {
+		port = 25
+	}
+
+	from := s.From
+	if from == "" {
+		from = "webpanel@" + msg.Host
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", from)
+	writeMail(&mail, s.To, msg)
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+	return smtp.SendMail(addr, auth, from, s.To, mail.Bytes())
+}
+
+// Webhook posts messages as JSON to a URL
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// Name returns the channel name
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Send posts the message
+func (w *Webhook) Send(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// LogFile appends messages as JSON lines to a local file
+type LogFile struct {
+	Path string
+}
+
+// Name returns the channel name
+func (l *LogFile) Name() string {
+	return "logfile"
+}
+
+// Send appends the message
+func (l *LogFile) Send(msg Message) error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// writeMail writes the headers after From and the body of a mail
+func writeMail(mail *bytes.Buffer, to []string, msg Message) {
+	fmt.Fprintf(mail, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(mail, "Subject: [%s] %s\r\n", msg.Host, msg.Subject)
+	fmt.Fprintf(mail, "Date: %s\r\n", msg.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(mail, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	mail.WriteString(formatText(msg))
+}
+
+// formatText renders a message as plain text
+func formatText(msg Message) string {
+	var b strings.Builder
+	b.WriteString(msg.Body)
+	b.WriteString("\n")
+	if len(msg.Fields) > 0 {
+		b.WriteString("\n")
+		for _, key := range sortedKeys(msg.Fields) {
+			fmt.Fprintf(&b, "%s: %s\n", key, msg.Fields[key])
+		}
+	}
+	fmt.Fprintf(&b, "\nHost: %s\nTime: %s\n", msg.Host, msg.Time.Format(time.RFC3339))
+	return b.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
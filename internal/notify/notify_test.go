@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is a local SMTP server accepting one mail
+type smtpSink struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan error
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpSink{listener: listener, done: make(chan error, 1)}
+	go func() { s.done <- s.serve() }()
+	return s
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve() error {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 sink")
+		case "MAIL":
+			s.from = line
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return nil
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		Event:   "alert_firing",
+		Subject: "FIRING [critical]: caddy-down",
+		Body:    "Alert caddy-down is firing.",
+		Host:    "web1",
+		Time:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Fields:  map[string]string{"value": "failed", "rule": "caddy-down"},
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	s := &SMTP{
+		Host: "127.0.0.1",
+		Port: sink.port(),
+		To:   []string{"ops@example.com", "admin@example.com"},
+	}
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sink.done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a configured sender the host name is used
+	if sink.from != "MAIL FROM:<webpanel@web1>" {
+		t.Errorf("from = %q", sink.from)
+	}
+	if len(sink.to) != 2 || sink.to[1] != "RCPT TO:<admin@example.com>" {
+		t.Errorf("recipients = %q", sink.to)
+	}
+	for _, want := range []string{
+		"From: webpanel@web1\r\n",
+		"To: ops@example.com, admin@example.com\r\n",
+		"Subject: [web1] FIRING [critical]: caddy-down\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n\r\nAlert caddy-down is firing.\r\n",
+		"rule: caddy-down\r\nvalue: failed\r\n",
+		"Host: web1\r\n",
+	} {
+		if !strings.Contains(sink.data, want) {
+			t.Errorf("mail lacks %q:\n%s", want, sink.data)
+		}
+	}
+}
+
+func TestSMTPSendRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "554 No service\r\n")
+	}()
+
+	s := &SMTP{Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, To: []string{"ops@example.com"}}
+	if err := s.Send(testMessage()); err == nil {
+		t.Error("expected an error from a rejecting server")
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var got Message
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	w := &Webhook{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := w.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if header != "Bearer token" {
+		t.Errorf("authorization = %q", header)
+	}
+	if got.Event != "alert_firing" || got.Fields["value"] != "failed" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestWebhookSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := (&Webhook{URL: server.URL}).Send(testMessage())
+	if err == nil || !strings.Contains(err.Error(), strconv.Itoa(http.StatusBadGateway)) {
+		t.Errorf("got %v, want the status", err)
+	}
+}
+
+func TestLogFileSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "alerts.log")
+	l := &LogFile{Path: path}
+	for i := 0; i < 2; i++ {
+		if err := l.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var msg Message
+	if err := json.Unmarshal([]byte(lines[1]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "FIRING [critical]: caddy-down" {
+		t.Errorf("got %+v", msg)
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/fileutil"
+)
+
+// DefaultCronDir is where generated crontab files are installed
+const DefaultCronDir = "/etc/cron.d"
+
+// Cron schedules jobs as files in /etc/cron.d. It is used on systems
+// without systemd.
+type Cron struct {
+	Dir string
+}
+
+// Name returns the backend name
+func (c *Cron) Name() string {
+	return "cron"
+}
+
+// path returns the crontab file of a job. cron ignores files in cron.d
+// whose names contain dots, so they are replaced.
+func (c *Cron) path(name string) string {
+	return filepath.Join(c.Dir, cronFileName(name))
+}
+
+func cronFileName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// Install writes the crontab file for a job
+func (c *Cron) Install(job Job) error {
+	content := fmt.Sprintf(`# %s
+# job: %s
+SHELL=/bin/sh
+PATH=/usr/local/sbin:/usr/local/bin:/sbin:/bin:/usr/sbin:/usr/bin
+
+%s root %s
+`, job.Description, job.Name, job.Schedule.CronSpec(job.Name),
+		quoteCronArgs(append(PriorityArgs(job.Nice, job.IOClass), job.Command...)))
+
+	if err := fileutil.WriteFile(c.path(job.Name), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to create cron job: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the crontab file of a job
+func (c *Cron) Remove(name string) error {
+	if err := fileutil.Remove(c.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cron job: %v", err)
+	}
+	return nil
+}
+
+// Exists reports whether a job is installed
+func (c *Cron) Exists(name string) bool {
+	_, err := os.Stat(c.path(name))
+	return err == nil
+}
+
+// Status returns the cron schedule of a job. cron does not record run
+// times, so only the schedule is known.
+func (c *Cron) Status(name string) (JobStatus, error) {
+	status := JobStatus{Name: name, Backend: c.Name(), LastRun: "-", Result: "-"}
+
+	content, err := os.ReadFile(c.path(name))
+	if err != nil {
+		return status, err
+	}
+
+	status.NextRun = "-"
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 6 && fields[5] == "root" {
+			status.NextRun = "cron: " + strings.Join(fields[:5], " ")
+			break
+		}
+	}
+
+	return status, nil
+}
+
+// List returns the names of installed jobs starting with prefix
+func (c *Cron) List(prefix string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(c.Dir, cronFileName(prefix)+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, match := range matches {
+		name, err := cronJobName(match)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// cronJobName reads the original job name recorded in a crontab file
+func cronJobName(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if name, ok := strings.CutPrefix(line, "# job: "); ok {
+			return strings.TrimSpace(name), nil
+		}
+	}
+	return "", fmt.Errorf("%s was not created by webpanel", path)
+}
@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job is a command run on a recurring schedule
+type Job struct {
+	Name        string
+	Description string
+	Command     []string
+	Schedule    Schedule
+	Nice        int    // CPU priority adjustment, 0 leaves it unchanged
+	IOClass     string // "idle" or "best-effort", empty leaves it unchanged
+}
+
+// Schedule describes when a job runs. An empty Weekday means every day.
+// A non-zero Every runs the job repeatedly at that interval instead.
+type Schedule struct {
+	Weekday     string
+	Hour        int
+	Minute      int
+	Every       time.Duration
+	RandomDelay time.Duration
+}
+
+// JobStatus reports what the scheduler knows about a job
+type JobStatus struct {
+	Name    string
+	Backend string
+	NextRun string
+	LastRun string
+	Result  string
+}
+
+// Backend installs and removes scheduled jobs
+type Backend interface {
+	Name() string
+	Install(job Job) error
+	Remove(name string) error
+	Exists(name string) bool
+	Status(name string) (JobStatus, error)
+	List(prefix string) ([]string, error)
+}
+
+var weekdays = map[string]string{
+	"monday":    "Mon",
+	"tuesday":   "Tue",
+	"wednesday": "Wed",
+	"thursday":  "Thu",
+	"friday":    "Fri",
+	"saturday":  "Sat",
+	"sunday":    "Sun",
+}
+
+var cronWeekdays = map[string]int{
+	"sunday":    0,
+	"monday":    1,
+	"tuesday":   2,
+	"wednesday": 3,
+	"thursday":  4,
+	"friday":    5,
+	"saturday":  6,
+}
+
+// ioClasses maps the I/O scheduling classes accepted in Job.IOClass to the
+// class numbers used by ionice
+var ioClasses = map[string]int{
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// ValidIOClass reports whether class can be used as Job.IOClass
+func ValidIOClass(class string) bool {
+	_, ok := ioClasses[class]
+	return class == "" || ok
+}
+
+// PriorityArgs returns the nice and ionice command prefix applying a CPU
+// and I/O priority to a command. Tools that are not installed are left out.
+func PriorityArgs(nice int, ioClass string) []string {
+	var args []string
+	if nice != 0 {
+		if _, err := exec.LookPath("nice"); err == nil {
+			args = append(args, "nice", "-n", strconv.Itoa(nice))
+		}
+	}
+	if class, ok := ioClasses[ioClass]; ok {
+		if _, err := exec.LookPath("ionice"); err == nil {
+			args = append(args, "ionice", "-c", strconv.Itoa(class))
+		}
+	}
+	return args
+}
+
+// Detect returns the systemd backend when systemd is running and the
+// cron.d backend otherwise
+func Detect() Backend {
+	if _, err := os.Stat("/run/systemd/system"); err == nil {
+		return &Systemd{UnitDir: DefaultUnitDir}
+	}
+	return &Cron{Dir: DefaultCronDir}
+}
+
+// ParseSchedule builds a schedule from a weekday name (empty for daily) and
+// a 24h "HH:MM" time as used in the configuration file
+func ParseSchedule(day, clock string, randomDelay time.Duration) (Schedule, error) {
+	s := Schedule{RandomDelay: randomDelay}
+
+	if day != "" {
+		day = strings.ToLower(day)
+		if _, ok := weekdays[day]; !ok {
+			return s, fmt.Errorf("invalid weekday: %s", day)
+		}
+		s.Weekday = day
+	}
+
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return s, fmt.Errorf("invalid time %q (must be HH:MM)", clock)
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return s, fmt.Errorf("invalid hour in time %q", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return s, fmt.Errorf("invalid minute in time %q", clock)
+	}
+
+	s.Hour = hour
+	s.Minute = minute
+	return s, nil
+}
+
+// EverySchedule returns a schedule running a job at a fixed interval
+func EverySchedule(interval time.Duration) Schedule {
+	return Schedule{Every: interval}
+}
+
+// OnCalendar returns the schedule as a systemd calendar expression
+func (s Schedule) OnCalendar() string {
+	if s.Every > 0 {
+		switch {
+		case s.Every < time.Minute:
+			return fmt.Sprintf("*:*:0/%d", max(int(s.Every/time.Second), 1))
+		case s.Every < time.Hour:
+			return fmt.Sprintf("*:0/%d", int(s.Every/time.Minute))
+		default:
+			return fmt.Sprintf("0/%d:00", min(int(s.Every/time.Hour), 23))
+		}
+	}
+
+	expr := fmt.Sprintf("*-*-* %02d:%02d:00", s.Hour, s.Minute)
+	if s.Weekday != "" {
+		expr = weekdays[s.Weekday] + " " + expr
+	}
+	return expr
+}
+
+// CronSpec returns the schedule as the five time fields of a crontab line.
+// cron has no randomized delay, so the job is shifted by a fixed offset
+// derived from its name, spreading jobs over the delay window.
+func (s Schedule) CronSpec(jobName string) string {
+	// cron cannot run a job more often than once a minute
+	if s.Every > 0 {
+		if s.Every < time.Hour {
+			return fmt.Sprintf("*/%d * * * *", max(int(s.Every/time.Minute), 1))
+		}
+		return fmt.Sprintf("0 */%d * * *", min(int(s.Every/time.Hour), 23))
+	}
+
+	start := s.Hour*60 + s.Minute
+	if window := int(s.RandomDelay / time.Minute); window > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(jobName))
+		start += int(h.Sum32() % uint32(window))
+	}
+
+	// Keep the job on the configured day even if the offset crosses midnight
+	if start >= 24*60 {
+		start = 24*60 - 1
+	}
+
+	dow := "*"
+	if s.Weekday != "" {
+		dow = strconv.Itoa(cronWeekdays[s.Weekday])
+	}
+
+	return fmt.Sprintf("%d %d * * %s", start%60, start/60, dow)
+}
+
+// QuoteArgs joins command arguments for the ExecStart line of a systemd
+// unit, quoting any that contain whitespace or quotes. systemd expands %
+// specifiers and $ variables even inside quotes, so both are doubled.
+func QuoteArgs(args []string) string {
+	escape := strings.NewReplacer("%", "%%", "$", "$$")
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = escape.Replace(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteCronArgs joins command arguments for a crontab line, which cron
+// passes to /bin/sh. Arguments with shell metacharacters are single
+// quoted, and % is escaped because cron turns it into a newline.
+func quoteCronArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`;&|<>()*?[]{}~#!%") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = strings.ReplaceAll(arg, "%", `\%`)
+	}
+	return strings.Join(quoted, " ")
+}
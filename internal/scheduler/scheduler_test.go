@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"webpanel", "backup", "run", "example.com", "daily"}, "webpanel backup run example.com daily"},
+		{[]string{"echo", "a b"}, `echo "a b"`},
+		{[]string{"echo", ""}, `echo ""`},
+		{[]string{"echo", `say "hi"`}, `echo "say \"hi\""`},
+		{[]string{"date", "+%Y-%m-%d"}, "date +%%Y-%%m-%%d"},
+		{[]string{"echo", "$HOME"}, "echo $$HOME"},
+		{[]string{"echo", "50% of $PATH"}, `echo "50%% of $$PATH"`},
+	}
+
+	for _, tt := range tests {
+		if got := QuoteArgs(tt.args); got != tt.want {
+			t.Errorf("QuoteArgs(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteCronArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"webpanel", "backup", "run", "example.com", "daily"}, "webpanel backup run example.com daily"},
+		{[]string{"echo", "a b"}, "echo 'a b'"},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"date", "+%Y"}, `date '+\%Y'`},
+		{[]string{"echo", "$HOME"}, "echo '$HOME'"},
+		{[]string{"rm", "*"}, "rm '*'"},
+		{[]string{"echo", "a;b", "`id`"}, "echo 'a;b' '`id`'"},
+	}
+
+	for _, tt := range tests {
+		if got := quoteCronArgs(tt.args); got != tt.want {
+			t.Errorf("quoteCronArgs(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteCronArgsShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell")
+	}
+
+	args := []string{"a b", "", "it's", "+%Y", "$HOME", "*", "a;b", "`id`", `back\slash`}
+	// cron unescapes \% before it hands the line to the shell
+	line := strings.ReplaceAll(quoteCronArgs(args), `\%`, "%")
+	out, err := exec.Command(sh, "-c", `printargs() { for a do printf '[%s]\n' "$a"; done; }; printargs `+line).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	for _, arg := range args {
+		want.WriteString("[" + arg + "]\n")
+	}
+	if string(out) != want.String() {
+		t.Errorf("shell read %s as\n%s\nwant\n%s", line, out, want.String())
+	}
+}
+
+func TestCronSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     string
+	}{
+		{"daily", Schedule{Hour: 2, Minute: 30}, "30 2 * * *"},
+		{"weekly on sunday", Schedule{Weekday: "sunday", Hour: 3}, "0 3 * * 0"},
+		{"weekly on saturday", Schedule{Weekday: "saturday", Hour: 23, Minute: 5}, "5 23 * * 6"},
+		{"every 5 minutes", Schedule{Every: 5 * time.Minute}, "*/5 * * * *"},
+		{"every 30 seconds", Schedule{Every: 30 * time.Second}, "*/1 * * * *"},
+		{"every 6 hours", Schedule{Every: 6 * time.Hour}, "0 */6 * * *"},
+		{"every 2 days", Schedule{Every: 48 * time.Hour}, "0 */23 * * *"},
+		{"delay past midnight", Schedule{Hour: 23, Minute: 59, RandomDelay: 2 * time.Hour}, "59 23 * * *"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.schedule.CronSpec("webpanel-backup-example.com-daily"); got != tt.want {
+			t.Errorf("%s: CronSpec = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCronSpecRandomDelay(t *testing.T) {
+	s := Schedule{Hour: 1, RandomDelay: 30 * time.Minute}
+
+	spreads := make(map[string]bool)
+	for _, job := range []string{"webpanel-backup-a.example-daily", "webpanel-backup-b.example-daily", "webpanel-backup-c.example-daily", "webpanel-backup-d.example-daily"} {
+		spec := s.CronSpec(job)
+		if spec != s.CronSpec(job) {
+			t.Errorf("%s: offset is not stable", job)
+		}
+		fields := strings.Fields(spec)
+		minute, _ := strconv.Atoi(fields[0])
+		if len(fields) != 5 || minute >= 30 || fields[1] != "1" || strings.Join(fields[2:], " ") != "* * *" {
+			t.Errorf("%s: CronSpec = %q, want a minute within 01:00-01:29", job, spec)
+			continue
+		}
+		spreads[fields[0]] = true
+	}
+	if len(spreads) < 2 {
+		t.Errorf("jobs are not spread over the delay window: %v", spreads)
+	}
+}
@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/fileutil"
+)
+
+// DefaultUnitDir is where generated systemd units are installed
+const DefaultUnitDir = "/etc/systemd/system"
+
+// Systemd schedules jobs as a oneshot .service started by a .timer
+type Systemd struct {
+	UnitDir string
+}
+
+// Name returns the backend name
+func (s *Systemd) Name() string {
+	return "systemd"
+}
+
+func (s *Systemd) servicePath(name string) string {
+	return filepath.Join(s.UnitDir, name+".service")
+}
+
+func (s *Systemd) timerPath(name string) string {
+	return filepath.Join(s.UnitDir, name+".timer")
+}
+
+// Install writes the unit files for a job and enables its timer
+func (s *Systemd) Install(job Job) error {
+	service := fmt.Sprintf(`[Unit]
+Description=%s
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, job.Description, QuoteArgs(job.Command))
+	if job.Nice != 0 {
+		service += fmt.Sprintf("Nice=%d\n", job.Nice)
+	}
+	if job.IOClass != "" {
+		service += fmt.Sprintf("IOSchedulingClass=%s\n", job.IOClass)
+	}
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Timer for %s
+
+[Timer]
+OnCalendar=%s
+RandomizedDelaySec=%d
+Persistent=true
+Unit=%s.service
+
+[Install]
+WantedBy=timers.target
+`, job.Description, job.Schedule.OnCalendar(), int(job.Schedule.RandomDelay.Seconds()), job.Name)
+
+	if err := fileutil.WriteFile(s.servicePath(job.Name), []byte(service), 0644); err != nil {
+		return fmt.Errorf("failed to write service unit: %v", err)
+	}
+	if err := fileutil.WriteFile(s.timerPath(job.Name), []byte(timer), 0644); err != nil {
+		return fmt.Errorf("failed to write timer unit: %v", err)
+	}
+
+	if err := Systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return Systemctl("enable", "--now", job.Name+".timer")
+}
+
+// Remove disables a job's timer and deletes its unit files
+func (s *Systemd) Remove(name string) error {
+	if s.Exists(name) {
+		if err := Systemctl("disable", "--now", name+".timer"); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range []string{s.timerPath(name), s.servicePath(name)} {
+		if err := fileutil.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+	}
+
+	return Systemctl("daemon-reload")
+}
+
+// Exists reports whether a job's timer is installed
+func (s *Systemd) Exists(name string) bool {
+	_, err := os.Stat(s.timerPath(name))
+	return err == nil
+}
+
+// Status returns the next and last run times of a job as reported by systemd
+func (s *Systemd) Status(name string) (JobStatus, error) {
+	status := JobStatus{Name: name, Backend: s.Name()}
+
+	timer, err := ShowUnit(name+".timer", "NextElapseUSecRealtime", "LastTriggerUSec")
+	if err != nil {
+		return status, err
+	}
+	service, err := ShowUnit(name+".service", "Result")
+	if err != nil {
+		return status, err
+	}
+
+	status.NextRun = valueOrDash(timer["NextElapseUSecRealtime"])
+	status.LastRun = valueOrDash(timer["LastTriggerUSec"])
+	status.Result = valueOrDash(service["Result"])
+	return status, nil
+}
+
+// List returns the names of installed jobs starting with prefix
+func (s *Systemd) List(prefix string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.UnitDir, prefix+"*.timer"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".timer"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Systemctl runs a systemctl command
+func Systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ShowUnit returns properties of a systemd unit
+func ShowUnit(unit string, properties ...string) (map[string]string, error) {
+	args := []string{"show", unit}
+	for _, p := range properties {
+		args = append(args, "--property="+p)
+	}
+
+	output, err := exec.Command("systemctl", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %v", unit, err)
+	}
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func valueOrDash(v string) string {
+	if v == "" || v == "n/a" {
+		return "-"
+	}
+	return v
+}
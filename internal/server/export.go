@@ -0,0 +1,278 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/site"
+	"github.com/doko/cli-webpanel/internal/version"
+	"github.com/spf13/viper"
+)
+
+// Export writes the selected parts of this server to a single tar.gz
+// archive at path
+func Export(path string, parts map[string]bool) (*Manifest, error) {
+	manifest, err := buildManifest(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	partial := path + ".partial"
+	// The archive holds every database dump and the password hashes
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %v", err)
+	}
+
+	if err := writeArchive(f, manifest, parts); err != nil {
+		f.Close()
+		os.Remove(partial)
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return nil, fmt.Errorf("failed to finalize archive: %v", err)
+	}
+
+	return manifest, nil
+}
+
+// buildManifest takes an inventory of the selected parts
+func buildManifest(parts map[string]bool) (*Manifest, error) {
+	host, _ := os.Hostname()
+	manifest := &Manifest{
+		FormatVersion: formatVersion,
+		ToolVersion:   version.Version,
+		Host:          host,
+		CreatedAt:     time.Now(),
+		Parts:         partList(parts),
+	}
+
+	if parts[PartSites] {
+		sites, err := site.List()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list websites: %v", err)
+		}
+		manifest.Sites = sites
+	}
+
+	if parts[PartDatabases] {
+		databases, err := database.ListDatabases()
+		if err != nil {
+			return nil, err
+		}
+		users, err := database.ListUsers()
+		if err != nil {
+			return nil, err
+		}
+		manifest.Databases = databases
+		manifest.Users = users
+	}
+
+	if parts[PartPHP] {
+		for _, v := range installedPHPVersions() {
+			manifest.PHP = append(manifest.PHP, PHPVersion{Version: v, Extensions: phpExtensions(v)})
+		}
+	}
+
+	if parts[PartSchedules] {
+		schedules, err := backup.ListSchedules()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backup schedules: %v", err)
+		}
+		for _, s := range schedules {
+			manifest.Schedules = append(manifest.Schedules, Schedule{Kind: s.Kind, Type: s.Type, Target: s.Target})
+		}
+	}
+
+	return manifest, nil
+}
+
+// writeArchive streams the manifest followed by every selected part
+func writeArchive(w io.Writer, manifest *Manifest, parts map[string]bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBytes(tw, manifestEntry, data); err != nil {
+		return err
+	}
+
+	if parts[PartConfig] {
+		if cfgFile := viper.ConfigFileUsed(); cfgFile != "" {
+			if err := addFile(tw, panelConfigEntry, cfgFile); err != nil {
+				return err
+			}
+		}
+		if _, err := os.Stat(SystemCaddyfile); err == nil {
+			if err := addFile(tw, systemCaddyEntry, SystemCaddyfile); err != nil {
+				return err
+			}
+		}
+		for _, dir := range []string{"global", "modules"} {
+			if err := addTree(tw, configPrefix+dir, filepath.Join(config.GetConfigDir(), dir)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if parts[PartSites] {
+		for _, domain := range manifest.Sites {
+			if err := addFile(tw, configPrefix+"sites/"+domain+".conf", config.GetSiteConfigPath(domain)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := addFile(tw, configPrefix+"meta/"+domain+".json", config.GetSiteMetaPath(domain)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := addTree(tw, sitesPrefix+domain, config.GetSiteDirectory(domain)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if parts[PartDatabases] {
+		for _, name := range manifest.Databases {
+			if err := addDatabaseDump(tw, name); err != nil {
+				return err
+			}
+		}
+
+		grants, err := database.ExportUsersAndGrants()
+		if err != nil {
+			return err
+		}
+		if err := addBytes(tw, grantsEntry, []byte(grants)); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addDatabaseDump dumps a database to a temporary file and adds it to the
+// archive. tar needs the size up front, so the dump cannot be streamed.
+func addDatabaseDump(tw *tar.Writer, name string) error {
+	tmp, err := os.CreateTemp(config.GetBackupDir(), "export-"+name+"-*.sql.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary dump file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	if err := database.Dump(name, gz); err != nil {
+		return fmt.Errorf("failed to dump database %s: %v", name, err)
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return addFile(tw, databasesPrefix+name+databaseDumpSuffix, tmp.Name())
+}
+
+// addBytes adds an in-memory file to the archive
+func addBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// addFile adds a single file from disk to the archive under name
+func addFile(tw *tar.Writer, name, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	return addEntry(tw, name, path, info)
+}
+
+// addTree adds a directory and everything below it to the archive under
+// prefix. A missing directory is skipped.
+func addTree(tw *tar.Writer, prefix, dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := prefix
+		if rel != "." {
+			name = prefix + "/" + filepath.ToSlash(rel)
+		}
+		return addEntry(tw, name, path, info)
+	})
+}
+
+// addEntry writes the header and content of one file system entry
+func addEntry(tw *tar.Writer, name, path string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to add %s: %v", path, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to add %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/spf13/viper"
+)
+
+// ImportOptions controls a server import
+type ImportOptions struct {
+	Parts map[string]bool
+	Force bool // import even if the preflight found conflicts
+}
+
+// Preflight lists what is missing on this server and what an import would
+// overwrite
+type Preflight struct {
+	MissingCommands []string
+	MissingPackages []string
+	Conflicts       []string
+}
+
+// OK reports whether the import can proceed without --force
+func (p *Preflight) OK() bool {
+	return len(p.MissingCommands) == 0 && len(p.Conflicts) == 0
+}
+
+// ReadManifest reads the manifest at the start of a server archive
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a server archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestEntry {
+		return nil, fmt.Errorf("not a server archive: manifest missing")
+	}
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid server manifest: %v", err)
+	}
+	if manifest.FormatVersion > formatVersion {
+		return nil, fmt.Errorf("archive format %d is newer than this webpanel supports (%d)", manifest.FormatVersion, formatVersion)
+	}
+
+	return manifest, nil
+}
+
+// Check compares an archive manifest with this server
+func Check(manifest *Manifest, parts map[string]bool) (*Preflight, error) {
+	p := &Preflight{}
+
+	// The names become paths and mysql arguments
+	for _, domain := range manifest.Sites {
+		if err := config.ValidateSiteName(domain); err != nil {
+			return nil, fmt.Errorf("invalid server archive: %v", err)
+		}
+	}
+	for _, name := range manifest.Databases {
+		if err := config.ValidateDatabaseName(name); err != nil {
+			return nil, fmt.Errorf("invalid server archive: %v", err)
+		}
+	}
+
+	var commands []string
+	if parts[PartConfig] || parts[PartSites] {
+		commands = append(commands, "caddy")
+	}
+	if parts[PartDatabases] {
+		commands = append(commands, "mysql")
+	}
+	if parts[PartPHP] && len(manifest.PHP) > 0 {
+		commands = append(commands, "apt-get")
+	}
+	for _, c := range commands {
+		if _, err := exec.LookPath(c); err != nil {
+			p.MissingCommands = append(p.MissingCommands, c)
+		}
+	}
+
+	if parts[PartPHP] {
+		installed := make(map[string]bool)
+		for _, v := range installedPHPVersions() {
+			installed[v] = true
+		}
+		for _, php := range manifest.PHP {
+			if !installed[php.Version] {
+				p.MissingPackages = append(p.MissingPackages, phpPackages(php)...)
+				continue
+			}
+			loaded := make(map[string]bool)
+			for _, ext := range phpExtensions(php.Version) {
+				loaded[ext] = true
+			}
+			for _, ext := range php.Extensions {
+				if !loaded[ext] {
+					p.MissingPackages = append(p.MissingPackages, fmt.Sprintf("php%s-%s", php.Version, ext))
+				}
+			}
+		}
+	}
+
+	if parts[PartSites] {
+		for _, domain := range manifest.Sites {
+			if _, err := os.Stat(config.GetSiteDirectory(domain)); err == nil {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("site %s already exists", domain))
+			} else if _, err := os.Stat(config.GetSiteConfigPath(domain)); err == nil {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("site configuration for %s already exists", domain))
+			}
+		}
+	}
+
+	if parts[PartDatabases] && len(manifest.Databases) > 0 {
+		existing, err := database.ListDatabases()
+		if err != nil {
+			return nil, err
+		}
+		have := make(map[string]bool)
+		for _, name := range existing {
+			have[name] = true
+		}
+		for _, name := range manifest.Databases {
+			if have[name] {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("database %s already exists", name))
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// phpPackages returns the packages providing a PHP version and its extensions
+func phpPackages(php PHPVersion) []string {
+	packages := []string{fmt.Sprintf("php%s-fpm", php.Version)}
+	for _, ext := range php.Extensions {
+		packages = append(packages, fmt.Sprintf("php%s-%s", php.Version, ext))
+	}
+	return packages
+}
+
+// Import recreates the selected parts of a server archive on this server.
+// The manifest is the one read from the archive, the preflight the result
+// of Check for it.
+func Import(path string, manifest *Manifest, preflight *Preflight, opts ImportOptions) error {
+	if !preflight.OK() && !opts.Force {
+		return fmt.Errorf("preflight check failed, use --force to import anyway")
+	}
+
+	if opts.Parts[PartPHP] && len(preflight.MissingPackages) > 0 {
+		if err := installPackages(preflight.MissingPackages); err != nil {
+			return err
+		}
+	}
+
+	if err := extractArchive(path, opts.Parts); err != nil {
+		return err
+	}
+
+	if opts.Parts[PartSchedules] {
+		for _, s := range manifest.Schedules {
+			if err := restoreSchedule(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.Parts[PartConfig] || opts.Parts[PartSites] {
+		if err := caddy.Validate(); err != nil {
+			return fmt.Errorf("import finished, but %v", err)
+		}
+		if err := caddy.Reload(); err != nil {
+			return fmt.Errorf("import finished, but %v", err)
+		}
+	}
+
+	return nil
+}
+
+// installPackages installs missing packages with apt-get
+func installPackages(packages []string) error {
+	cmd := exec.Command("apt-get", append([]string{"install", "-y"}, packages...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to install packages: %v", err)
+	}
+	return nil
+}
+
+// restoreSchedule recreates a backup schedule unless it already exists
+func restoreSchedule(s Schedule) error {
+	if s.Kind == backup.KindDatabase {
+		if backup.IsDatabaseBackupEnabled(s.Target, s.Type) {
+			return nil
+		}
+		return backup.EnableDatabaseBackup(s.Target, s.Type)
+	}
+
+	if backup.IsSiteBackupEnabled(s.Target, s.Type) {
+		return nil
+	}
+	return backup.EnableSiteBackup(s.Target, s.Type)
+}
+
+// extractArchive walks the archive once and restores every entry that
+// belongs to a selected part
+func extractArchive(path string, parts map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var grants []byte
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("server archive is damaged: %v", err)
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		switch {
+		case name == manifestEntry:
+			continue
+
+		case name == panelConfigEntry:
+			if parts[PartConfig] {
+				if err := restorePanelConfig(tr); err != nil {
+					return err
+				}
+			}
+
+		case name == systemCaddyEntry:
+			if parts[PartConfig] {
+				if err := writeEntry(hdr, tr, SystemCaddyfile); err != nil {
+					return err
+				}
+			}
+
+		case strings.HasPrefix(name, configPrefix):
+			rel := strings.TrimPrefix(name, configPrefix)
+			part := PartConfig
+			if strings.HasPrefix(rel, "sites/") || strings.HasPrefix(rel, "meta/") {
+				part = PartSites
+			}
+			if parts[part] {
+				if err := restoreEntry(hdr, tr, config.GetConfigDir(), rel); err != nil {
+					return err
+				}
+			}
+
+		case strings.HasPrefix(name, sitesPrefix):
+			if parts[PartSites] {
+				if err := restoreEntry(hdr, tr, config.GetWebRoot(), strings.TrimPrefix(name, sitesPrefix)); err != nil {
+					return err
+				}
+			}
+
+		case name == grantsEntry:
+			if parts[PartDatabases] {
+				if grants, err = io.ReadAll(tr); err != nil {
+					return err
+				}
+			}
+
+		case strings.HasPrefix(name, databasesPrefix) && strings.HasSuffix(name, databaseDumpSuffix):
+			if parts[PartDatabases] {
+				dbname := strings.TrimSuffix(strings.TrimPrefix(name, databasesPrefix), databaseDumpSuffix)
+				if err := restoreDatabaseDump(dbname, tr); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Grants reference the databases, so they go last
+	if len(grants) > 0 {
+		if err := database.ExecSQL(bytes.NewReader(grants)); err != nil {
+			return fmt.Errorf("failed to restore database users and grants: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// restorePanelConfig writes the exported panel config file unless this
+// server already has one
+func restorePanelConfig(r io.Reader) error {
+	if used := viper.ConfigFileUsed(); used != "" {
+		fmt.Printf("Keeping existing %s\n", used)
+		return nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(filepath.Join(home, ".webpanel.yaml"), data, 0644)
+}
+
+// restoreDatabaseDump loads a gzip compressed dump into a database
+func restoreDatabaseDump(name string, r io.Reader) error {
+	if err := config.ValidateDatabaseName(name); err != nil {
+		return fmt.Errorf("invalid dump in server archive: %v", err)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid dump for database %s: %v", name, err)
+	}
+	defer gz.Close()
+
+	if err := database.Restore(name, gz); err != nil {
+		return fmt.Errorf("failed to restore database %s: %v", name, err)
+	}
+	return nil
+}
+
+// restoreEntry writes an archive entry below root, refusing paths that
+// would escape it, directly or through a symlink
+func restoreEntry(hdr *tar.Header, r io.Reader, root, rel string) error {
+	root = filepath.Clean(root)
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+		return fmt.Errorf("refusing to extract %s outside %s", hdr.Name, root)
+	}
+
+	// An export never holds entries below a symlink, so one in the way was
+	// restored from an earlier entry to redirect this one. Only a symlink
+	// entry may replace a symlink.
+	path := target
+	if hdr.Typeflag == tar.TypeSymlink {
+		path = filepath.Dir(target)
+	}
+	for ; len(path) > len(root); path = filepath.Dir(path) {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through symlink %s", hdr.Name, path)
+		}
+	}
+	return writeEntry(hdr, r, target)
+}
+
+// writeEntry creates the file, directory or symlink described by hdr
+func writeEntry(hdr *tar.Header, r io.Reader, target string) error {
+	mode := os.FileMode(hdr.Mode).Perm()
+	// Restored configuration files go into the history of the import
+	fileutil.Touch(target)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write %s: %v", target, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+	default:
+		return nil
+	}
+
+	// Keep the original owner, e.g. www-data, when running as root
+	os.Lchown(target, hdr.Uid, hdr.Gid)
+	if hdr.Typeflag != tar.TypeSymlink {
+		os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreEntrySymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	restore := func(typ byte, name, link, content string) error {
+		hdr := &tar.Header{Name: name, Typeflag: typ, Linkname: link, Mode: 0644, Size: int64(len(content))}
+		return restoreEntry(hdr, strings.NewReader(content), root, name)
+	}
+
+	if err := restore(tar.TypeSymlink, "example.com/dir", outside, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := restore(tar.TypeSymlink, "example.com/file", filepath.Join(outside, "file"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries must not be written through the symlinks
+	if err := restore(tar.TypeReg, "example.com/dir/x", "", "data"); err == nil {
+		t.Error("file written through a directory symlink")
+	}
+	if err := restore(tar.TypeDir, "example.com/dir/sub", "", ""); err == nil {
+		t.Error("directory created through a directory symlink")
+	}
+	if err := restore(tar.TypeReg, "example.com/file", "", "data"); err == nil {
+		t.Error("file written through a file symlink")
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("restored outside the target: %v", entries)
+	}
+
+	// A later symlink entry may replace an earlier one
+	if err := restore(tar.TypeSymlink, "example.com/file", "index.html", ""); err != nil {
+		t.Errorf("replacing a symlink: %v", err)
+	}
+	if link, _ := os.Readlink(filepath.Join(root, "example.com", "file")); link != "index.html" {
+		t.Errorf("symlink points at %q", link)
+	}
+
+	if err := restore(tar.TypeReg, "example.com/index.html", "", "data"); err != nil {
+		t.Errorf("regular file: %v", err)
+	}
+	if err := restore(tar.TypeReg, "../escape", "", "data"); err == nil {
+		t.Error("file written outside the root")
+	}
+}
+
+func TestRestoreDatabaseDumpRejectsNames(t *testing.T) {
+	for _, name := range []string{"../x", "a`b", "db; DROP", ""} {
+		err := restoreDatabaseDump(name, strings.NewReader(""))
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("restoreDatabaseDump(%q) = %v, want an invalid name error", name, err)
+		}
+	}
+}
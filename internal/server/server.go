@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Parts of a server archive that can be selected with --only
+const (
+	PartConfig    = "config"
+	PartSites     = "sites"
+	PartDatabases = "dbs"
+	PartPHP       = "php"
+	PartSchedules = "schedules"
+)
+
+// AllParts lists every part in the order it is imported
+var AllParts = []string{PartConfig, PartPHP, PartSites, PartDatabases, PartSchedules}
+
+// SystemCaddyfile is the Caddyfile loaded by the caddy service
+const SystemCaddyfile = "/etc/caddy/Caddyfile"
+
+// formatVersion is bumped whenever the archive layout changes
+const formatVersion = 1
+
+// Archive entry names
+const (
+	manifestEntry      = "manifest.json"
+	panelConfigEntry   = "panel/config.yml"
+	systemCaddyEntry   = "caddy/Caddyfile"
+	configPrefix       = "config/"
+	sitesPrefix        = "sites/"
+	databasesPrefix    = "databases/"
+	grantsEntry        = "databases/grants.sql"
+	databaseDumpSuffix = ".sql.gz"
+)
+
+// Manifest is the first entry of a server archive and describes everything
+// the archive contains
+type Manifest struct {
+	FormatVersion int          `json:"format_version"`
+	ToolVersion   string       `json:"tool_version"`
+	Host          string       `json:"host"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Parts         []string     `json:"parts"`
+	Sites         []string     `json:"sites,omitempty"`
+	Databases     []string     `json:"databases,omitempty"`
+	Users         []string     `json:"users,omitempty"`
+	PHP           []PHPVersion `json:"php,omitempty"`
+	Schedules     []Schedule   `json:"schedules,omitempty"`
+}
+
+// PHPVersion is an installed PHP version and its loaded extensions
+type PHPVersion struct {
+	Version    string   `json:"version"`
+	Extensions []string `json:"extensions"`
+}
+
+// Schedule is a scheduled site or database backup
+type Schedule struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// ParseParts parses a comma separated list of parts. An empty list selects
+// every part.
+func ParseParts(list string) (map[string]bool, error) {
+	parts := make(map[string]bool)
+	if strings.TrimSpace(list) == "" {
+		for _, p := range AllParts {
+			parts[p] = true
+		}
+		return parts, nil
+	}
+
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		valid := false
+		for _, known := range AllParts {
+			if p == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown part %q (must be one of %s)", p, strings.Join(AllParts, ", "))
+		}
+		parts[p] = true
+	}
+
+	return parts, nil
+}
+
+// partList returns the selected parts in import order
+func partList(parts map[string]bool) []string {
+	var list []string
+	for _, p := range AllParts {
+		if parts[p] {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// installedPHPVersions returns the PHP-FPM versions installed on this server
+func installedPHPVersions() []string {
+	matches, _ := filepath.Glob("/usr/sbin/php-fpm*")
+
+	var versions []string
+	for _, match := range matches {
+		version := strings.TrimPrefix(filepath.Base(match), "php-fpm")
+		if version != "" {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// phpExtensions returns the extensions loaded by a PHP version
+func phpExtensions(version string) []string {
+	output, err := exec.Command("php"+version, "-m").Output()
+	if err != nil {
+		return nil
+	}
+
+	var extensions []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		extensions = append(extensions, strings.ToLower(line))
+	}
+	sort.Strings(extensions)
+	return extensions
+}
@@ -0,0 +1,135 @@
+// Package service lists and controls the systemd units the panel depends
+// on
+package service
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/scheduler"
+)
+
+// Actions are the systemctl commands Control runs
+var Actions = []string{"start", "stop", "restart", "reload"}
+
+// Monitored returns the units the panel watches: the web and database
+// servers, monitoring.services and the PHP-FPM unit of every installed PHP
+// version
+func Monitored() []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	add(config.GetWebServerConfig().ServiceName)
+	add(config.GetDatabaseConfig().ServiceName)
+	for _, name := range config.GetMonitoringConfig().Services {
+		add(name)
+	}
+	for _, name := range PHPFPMUnits() {
+		add(name)
+	}
+	return names
+}
+
+// IsMonitored reports whether a unit is one of the monitored services
+func IsMonitored(name string) bool {
+	for _, monitored := range Monitored() {
+		if monitored == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PHPFPMUnits returns the phpX.Y-fpm units of the installed PHP versions
+func PHPFPMUnits() []string {
+	matches, _ := filepath.Glob("/usr/sbin/php-fpm*")
+
+	var units []string
+	for _, match := range matches {
+		version := strings.TrimPrefix(filepath.Base(match), "php-fpm")
+		if version != "" {
+			units = append(units, fmt.Sprintf("php%s-fpm", version))
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// Status holds the systemd details of a unit
+type Status struct {
+	Name        string
+	Description string
+	LoadState   string // loaded, not-found, masked
+	ActiveState string // active, failed, inactive, activating
+	SubState    string // running, exited, dead
+	Since       string // when the unit entered its current state
+	MainPID     int
+	Memory      uint64 // 0 when memory accounting is off
+	Restarts    int
+}
+
+// Failed reports whether the unit is in the failed state
+func (s *Status) Failed() bool {
+	return s.ActiveState == "failed"
+}
+
+// GetStatus returns the systemd details of a unit
+func GetStatus(name string) (*Status, error) {
+	values, err := scheduler.ShowUnit(name, "Description", "LoadState", "ActiveState", "SubState",
+		"StateChangeTimestamp", "MainPID", "MemoryCurrent", "NRestarts")
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Name:        name,
+		Description: values["Description"],
+		LoadState:   values["LoadState"],
+		ActiveState: values["ActiveState"],
+		SubState:    values["SubState"],
+		Since:       values["StateChangeTimestamp"],
+	}
+	status.MainPID, _ = strconv.Atoi(values["MainPID"])
+	status.Restarts, _ = strconv.Atoi(values["NRestarts"])
+
+	// Without memory accounting systemd reports "[not set]" or the maximum
+	// uint64
+	if memory, err := strconv.ParseUint(values["MemoryCurrent"], 10, 64); err == nil && memory != math.MaxUint64 {
+		status.Memory = memory
+	}
+	return status, nil
+}
+
+// Control runs start, stop, restart or reload on a unit
+func Control(action, name string) error {
+	valid := false
+	for _, a := range Actions {
+		valid = valid || a == action
+	}
+	if !valid {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	return scheduler.Systemctl(action, name)
+}
+
+// Journal returns the last lines a unit wrote to the journal
+func Journal(name string, lines int) (string, error) {
+	output, err := exec.Command("journalctl", "--unit", name, "--lines", strconv.Itoa(lines),
+		"--no-pager", "--output", "short-iso").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to read journal of %s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
@@ -0,0 +1,164 @@
+package site
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
+)
+
+// Config is the Caddy configuration of a site
+type Config struct {
+	Addresses []string // the domain, followed by its aliases
+	Root      string
+	Lines     []string // directives after the root, e.g. "import php_config"
+}
+
+// PublicDir returns the document root of a site
+func PublicDir(domain string) string {
+	return filepath.Join(config.GetSiteDirectory(domain), "public")
+}
+
+// DefaultConfig returns the configuration of a new PHP site with the
+// default modules
+func DefaultConfig(domain string) *Config {
+	return &Config{
+		Addresses: []string{domain},
+		Root:      PublicDir(domain),
+		Lines: []string{
+			"import access_log " + domain,
+			"import error_log " + domain,
+			"import header_config",
+			"import security_config",
+			"import php_config",
+		},
+	}
+}
+
+// String formats the configuration as a Caddyfile site block
+func (c *Config) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s {\n", strings.Join(c.Addresses, ", "))
+	if c.Root != "" {
+		fmt.Fprintf(&b, "    root * %s\n", c.Root)
+	}
+	for _, line := range c.Lines {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ParseConfig reads a site block as written by String. Blank lines are
+// dropped.
+func ParseConfig(data string) (*Config, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	header := strings.TrimSpace(lines[0])
+	if !strings.HasSuffix(header, "{") || strings.TrimSpace(lines[len(lines)-1]) != "}" || len(lines) < 2 {
+		return nil, fmt.Errorf("not a site block")
+	}
+
+	c := &Config{}
+	for _, address := range strings.Split(strings.TrimSuffix(header, "{"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			c.Addresses = append(c.Addresses, address)
+		}
+	}
+	if len(c.Addresses) == 0 {
+		return nil, fmt.Errorf("site block without address")
+	}
+
+	for _, line := range lines[1 : len(lines)-1] {
+		line = strings.TrimSpace(line)
+		if root, ok := strings.CutPrefix(line, "root * "); ok && c.Root == "" {
+			c.Root = strings.TrimSpace(root)
+		} else if line != "" {
+			c.Lines = append(c.Lines, line)
+		}
+	}
+	return c, nil
+}
+
+// LoadConfig reads the Caddy configuration of a site
+func LoadConfig(domain string) (*Config, error) {
+	data, err := os.ReadFile(config.GetSiteConfigPath(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read site configuration: %v", err)
+	}
+	c, err := ParseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid site configuration for %s: %v", domain, err)
+	}
+	return c, nil
+}
+
+// SaveConfig writes the Caddy configuration of a site
+func SaveConfig(domain string, c *Config) error {
+	if err := fileutil.WriteFile(config.GetSiteConfigPath(domain), []byte(c.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write Caddy configuration: %v", err)
+	}
+	return nil
+}
+
+// Create sets up the directories of a new site with a welcome page and
+// writes its Caddy configuration
+func Create(domain string, c *Config) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	publicDir := PublicDir(domain)
+	if err := os.MkdirAll(publicDir, 0755); err != nil {
+		return fmt.Errorf("failed to create public directory: %v", err)
+	}
+
+	if err := os.MkdirAll(config.GetSiteLogDirectory(domain), 0755); err != nil {
+		return fmt.Errorf("failed to create logs directory: %v", err)
+	}
+
+	indexContent := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Welcome to %s</title>
+</head>
+<body>
+    <h1>Welcome to %s</h1>
+    <p>Your website is now set up and running!</p>
+</body>
+</html>`, domain, domain)
+
+	if err := os.WriteFile(filepath.Join(publicDir, "index.html"), []byte(indexContent), 0644); err != nil {
+		return fmt.Errorf("failed to create index.html: %v", err)
+	}
+
+	return SaveConfig(domain, c)
+}
+
+// Remove deletes the directory, configuration, logs and metadata of a site
+func Remove(domain string) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	if err := os.RemoveAll(config.GetSiteDirectory(domain)); err != nil {
+		return fmt.Errorf("failed to remove site directory: %v", err)
+	}
+
+	if err := fileutil.Remove(config.GetSiteConfigPath(domain)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove configuration file: %v", err)
+	}
+
+	if err := os.RemoveAll(config.GetSiteLogDirectory(domain)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove log directory: %v", err)
+	}
+
+	return RemoveMetadata(domain)
+}
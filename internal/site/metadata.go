@@ -0,0 +1,120 @@
+package site
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/fileutil"
+	"github.com/doko/cli-webpanel/internal/lock"
+)
+
+// Metadata holds panel information about a site that is not part of its
+// Caddy configuration
+type Metadata struct {
+	Domain    string   `json:"domain"`
+	Databases []string `json:"databases,omitempty"`
+}
+
+// LoadMetadata reads the metadata of a site. A site without a metadata file
+// gets empty metadata.
+func LoadMetadata(domain string) (*Metadata, error) {
+	data, err := os.ReadFile(config.GetSiteMetaPath(domain))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Metadata{Domain: domain}, nil
+		}
+		return nil, fmt.Errorf("failed to read site metadata: %v", err)
+	}
+
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("invalid site metadata for %s: %v", domain, err)
+	}
+	meta.Domain = domain
+
+	return meta, nil
+}
+
+// SaveMetadata writes the metadata of a site
+func SaveMetadata(meta *Metadata) error {
+	path := config.GetSiteMetaPath(meta.Domain)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write site metadata: %v", err)
+	}
+
+	return nil
+}
+
+// LinkDatabase records that a database belongs to a site
+func LinkDatabase(domain, dbname string) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	meta, err := LoadMetadata(domain)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range meta.Databases {
+		if name == dbname {
+			return fmt.Errorf("database %s is already linked to %s", dbname, domain)
+		}
+	}
+
+	meta.Databases = append(meta.Databases, dbname)
+	sort.Strings(meta.Databases)
+
+	return SaveMetadata(meta)
+}
+
+// UnlinkDatabase removes a database from a site
+func UnlinkDatabase(domain, dbname string) error {
+	l, err := lock.Site(domain)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	meta, err := LoadMetadata(domain)
+	if err != nil {
+		return err
+	}
+
+	var databases []string
+	for _, name := range meta.Databases {
+		if name != dbname {
+			databases = append(databases, name)
+		}
+	}
+
+	if len(databases) == len(meta.Databases) {
+		return fmt.Errorf("database %s is not linked to %s", dbname, domain)
+	}
+
+	meta.Databases = databases
+	return SaveMetadata(meta)
+}
+
+// RemoveMetadata deletes the metadata of a site
+func RemoveMetadata(domain string) error {
+	if err := fileutil.Remove(config.GetSiteMetaPath(domain)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove site metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package site
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/doko/cli-webpanel/internal/config"
+)
+
+// List returns the domains of all sites in the web root
+func List() ([]string, error) {
+	entries, err := os.ReadDir(config.GetWebRoot())
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			domains = append(domains, entry.Name())
+		}
+	}
+
+	return domains, nil
+}
+
+// DiskUsage returns the total size of the files in a site's directory
+func DiskUsage(domain string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(config.GetSiteDirectory(domain), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				// Removed while walking
+				return nil
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
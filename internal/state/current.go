@@ -0,0 +1,158 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// Current reads the state of the server. Databases and users are only read
+// with withDatabase, which needs an initialized database connection.
+// Passwords are never read. The warnings name what the state cannot
+// express.
+func Current(withDatabase bool) (*State, []string, error) {
+	s := &State{}
+	var warnings []string
+
+	domains, err := site.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list sites: %v", err)
+	}
+	for _, domain := range domains {
+		c, err := site.LoadConfig(domain)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		st, unsupported := siteFromConfig(domain, c)
+		for _, line := range unsupported {
+			warnings = append(warnings, fmt.Sprintf("site %s: not part of the state: %s", domain, line))
+		}
+
+		meta, err := site.LoadMetadata(domain)
+		if err != nil {
+			return nil, nil, err
+		}
+		st.Databases = meta.Databases
+		s.Sites = append(s.Sites, st)
+	}
+
+	if withDatabase {
+		databases, err := database.ListDatabases()
+		if err != nil {
+			return nil, nil, err
+		}
+		s.Databases = databases
+
+		users, err := database.ListUsers()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range users {
+			grants, err := database.Grants(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			s.Users = append(s.Users, User{Name: name, Grants: grants})
+		}
+	}
+
+	schedules, err := backup.ListSchedules()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list backup schedules: %v", err)
+	}
+	byTarget := make(map[string]*Backup)
+	for _, info := range schedules {
+		key := info.Kind + " " + info.Target
+		b, ok := byTarget[key]
+		if !ok {
+			b = &Backup{}
+			if info.Kind == backup.KindDatabase {
+				b.Database = info.Target
+			} else {
+				b.Site = info.Target
+			}
+			byTarget[key] = b
+		}
+		b.Schedule = append(b.Schedule, info.Type)
+	}
+	keys := make([]string, 0, len(byTarget))
+	for key := range byTarget {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if byTarget[key].Database != "" && !withDatabase {
+			continue
+		}
+		s.Backups = append(s.Backups, *byTarget[key])
+	}
+
+	s.sort()
+	return s, warnings, nil
+}
+
+// findSite returns the site of a domain
+func (s *State) findSite(domain string) *Site {
+	for i := range s.Sites {
+		if s.Sites[i].Domain == domain {
+			return &s.Sites[i]
+		}
+	}
+	return nil
+}
+
+// findUser returns the user of a name
+func (s *State) findUser(name string) *User {
+	for i := range s.Users {
+		if s.Users[i].Name == name {
+			return &s.Users[i]
+		}
+	}
+	return nil
+}
+
+// findBackup returns the backup schedule of a site or database
+func (s *State) findBackup(kind, target string) *Backup {
+	for i := range s.Backups {
+		k, t := s.Backups[i].kind()
+		if k == kind && t == target {
+			return &s.Backups[i]
+		}
+	}
+	return nil
+}
+
+// hasDatabase reports whether a database is part of the state
+func (s *State) hasDatabase(name string) bool {
+	for _, d := range s.Databases {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsDatabase reports whether the state manages databases or users
+func (s *State) NeedsDatabase() bool {
+	if len(s.Databases) > 0 || len(s.Users) > 0 {
+		return true
+	}
+	for _, b := range s.Backups {
+		if b.Database != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func joinOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
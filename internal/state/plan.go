@@ -0,0 +1,299 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/caddy"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/database"
+	"github.com/doko/cli-webpanel/internal/history"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// Actions of a change
+const (
+	Create = "+"
+	Update = "~"
+	Delete = "-"
+)
+
+// Change is one step converging the server to a state
+type Change struct {
+	Action   string
+	Resource string   // e.g. "site example.com"
+	Details  []string // what changes, e.g. the diff of a site configuration
+
+	apply func() error
+	caddy bool // the change touches the Caddy configuration
+}
+
+// Plan is the ordered list of changes converging the server to a state:
+// databases, users and their grants, sites, backup schedules, and with
+// prune the removal of what the state does not mention
+type Plan struct {
+	Changes []*Change
+}
+
+// NewPlan compares the desired state with the current one. Resources of the
+// desired state are converged to exactly what it describes. Resources it
+// does not mention are only removed with prune.
+func NewPlan(desired, current *State, prune bool) (*Plan, error) {
+	p := &Plan{}
+
+	for _, name := range missing(desired.Databases, current.Databases) {
+		name := name
+		p.add(&Change{Action: Create, Resource: "database " + name, apply: func() error {
+			return database.CreateDatabase(name)
+		}})
+	}
+
+	for _, u := range desired.Users {
+		u := u
+		cur := current.findUser(u.Name)
+		if cur == nil {
+			if u.Password == "" {
+				return nil, fmt.Errorf("user %s: password or password_env is required to create the user", u.Name)
+			}
+			p.add(&Change{
+				Action:   Create,
+				Resource: "user " + u.Name,
+				Details:  []string{"grants: " + joinOrNone(u.Grants)},
+				apply: func() error {
+					if err := database.CreateUser(u.Name, u.Password); err != nil {
+						return err
+					}
+					for _, db := range u.Grants {
+						if err := database.GrantAccess(u.Name, db); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			})
+			continue
+		}
+
+		grant, revoke := missing(u.Grants, cur.Grants), missing(cur.Grants, u.Grants)
+		if len(grant) == 0 && len(revoke) == 0 {
+			continue
+		}
+		c := &Change{Action: Update, Resource: "user " + u.Name, apply: func() error {
+			for _, db := range grant {
+				if err := database.GrantAccess(u.Name, db); err != nil {
+					return err
+				}
+			}
+			for _, db := range revoke {
+				if err := database.RevokeAccess(u.Name, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		}}
+		for _, db := range grant {
+			c.Details = append(c.Details, "grant "+db)
+		}
+		for _, db := range revoke {
+			c.Details = append(c.Details, "revoke "+db)
+		}
+		p.add(c)
+	}
+
+	for _, s := range desired.Sites {
+		c, err := siteChange(s, current.findSite(s.Domain))
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			p.add(c)
+		}
+	}
+
+	for _, b := range desired.Backups {
+		kind, target := b.kind()
+		var schedule []string
+		if cur := current.findBackup(kind, target); cur != nil {
+			schedule = cur.Schedule
+		}
+		p.backupChanges(kind, target, missing(b.Schedule, schedule), missing(schedule, b.Schedule))
+	}
+
+	if !prune {
+		return p, nil
+	}
+
+	for _, b := range current.Backups {
+		kind, target := b.kind()
+		if desired.findBackup(kind, target) == nil {
+			p.backupChanges(kind, target, nil, b.Schedule)
+		}
+	}
+	for _, s := range current.Sites {
+		domain := s.Domain
+		if desired.findSite(domain) == nil {
+			p.add(&Change{Action: Delete, Resource: "site " + domain, caddy: true,
+				Details: []string{"removes the web root " + config.GetSiteDirectory(domain)},
+				apply:   func() error { return site.Remove(domain) }})
+		}
+	}
+	for _, u := range current.Users {
+		name := u.Name
+		if desired.findUser(name) == nil {
+			p.add(&Change{Action: Delete, Resource: "user " + name, apply: func() error {
+				return database.DeleteUser(name)
+			}})
+		}
+	}
+	for _, name := range missing(current.Databases, desired.Databases) {
+		name := name
+		p.add(&Change{Action: Delete, Resource: "database " + name, Details: []string{"drops all its tables"},
+			apply: func() error { return database.DeleteDatabase(name) }})
+	}
+
+	return p, nil
+}
+
+func (p *Plan) add(c *Change) {
+	p.Changes = append(p.Changes, c)
+}
+
+// siteChange returns the change creating or updating a site, or nil when
+// the site matches
+func siteChange(s Site, current *Site) (*Change, error) {
+	if !phpInstalled(s.PHP) {
+		return nil, fmt.Errorf("site %s: PHP %s is not installed, run \"webpanel php install %s\" first", s.Domain, s.PHP, s.PHP)
+	}
+
+	domain := s.Domain
+	cfg := s.render()
+	rendered := cfg.String()
+	path := config.GetSiteConfigPath(domain)
+	meta := &site.Metadata{Domain: domain, Databases: s.Databases}
+
+	if _, err := os.Stat(config.GetSiteDirectory(domain)); os.IsNotExist(err) {
+		c := &Change{Action: Create, Resource: "site " + domain, caddy: true, apply: func() error {
+			if err := site.Create(domain, cfg); err != nil {
+				return err
+			}
+			if len(meta.Databases) == 0 {
+				return nil
+			}
+			return site.SaveMetadata(meta)
+		}}
+		c.Details = append(diffLines(path, nil, &rendered), linkChanges(nil, s.Databases)...)
+		return c, nil
+	}
+
+	var before *string
+	if data, err := os.ReadFile(path); err == nil {
+		text := string(data)
+		before = &text
+	}
+	var links []string
+	if current != nil {
+		links = current.Databases
+	} else if m, err := site.LoadMetadata(domain); err == nil {
+		links = m.Databases
+	}
+
+	// Formatting differences alone, such as a missing final newline, are
+	// not a change
+	configChanged := true
+	if before != nil {
+		if parsed, err := site.ParseConfig(*before); err == nil {
+			configChanged = parsed.String() != rendered
+		}
+	}
+	linkDetails := linkChanges(links, s.Databases)
+	if !configChanged && len(linkDetails) == 0 {
+		return nil, nil
+	}
+
+	c := &Change{Action: Update, Resource: "site " + domain, caddy: configChanged, apply: func() error {
+		if configChanged {
+			if err := site.SaveConfig(domain, cfg); err != nil {
+				return err
+			}
+		}
+		if len(linkDetails) > 0 {
+			return site.SaveMetadata(meta)
+		}
+		return nil
+	}}
+	if configChanged {
+		c.Details = diffLines(path, before, &rendered)
+	}
+	c.Details = append(c.Details, linkDetails...)
+	return c, nil
+}
+
+// diffLines returns the diff of a configuration file as lines
+func diffLines(path string, before, after *string) []string {
+	diff := history.File{Path: path, Before: before, After: after}.Diff()
+	return strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+}
+
+// backupChanges adds the changes enabling and disabling the backup types
+// of a site or database
+func (p *Plan) backupChanges(kind, target string, enable, disable []string) {
+	for _, t := range enable {
+		t := t
+		p.add(&Change{Action: Create, Resource: fmt.Sprintf("%s backup of %s %s", t, kind, target), apply: func() error {
+			if kind == backup.KindDatabase {
+				return backup.EnableDatabaseBackup(target, t)
+			}
+			return backup.EnableSiteBackup(target, t)
+		}})
+	}
+	for _, t := range disable {
+		t := t
+		p.add(&Change{Action: Delete, Resource: fmt.Sprintf("%s backup of %s %s", t, kind, target), apply: func() error {
+			if kind == backup.KindDatabase {
+				return backup.DisableDatabaseBackup(target, t)
+			}
+			return backup.DisableSiteBackup(target, t)
+		}})
+	}
+}
+
+// Count returns the number of changes of each action
+func (p *Plan) Count() (create, update, remove int) {
+	for _, c := range p.Changes {
+		switch c.Action {
+		case Create:
+			create++
+		case Update:
+			update++
+		case Delete:
+			remove++
+		}
+	}
+	return create, update, remove
+}
+
+// Apply makes the changes in order and stops at the first failure. When
+// the Caddy configuration changed, it is validated and Caddy reloaded.
+// done is called after every change made.
+func (p *Plan) Apply(done func(*Change)) error {
+	reload := false
+	for _, c := range p.Changes {
+		if err := c.apply(); err != nil {
+			return fmt.Errorf("%s: %v", c.Resource, err)
+		}
+		reload = reload || c.caddy
+		if done != nil {
+			done(c)
+		}
+	}
+
+	if !reload {
+		return nil
+	}
+	if err := caddy.Validate(); err != nil {
+		return fmt.Errorf("%v\nCaddy was not reloaded, use \"webpanel rollback\" to undo the configuration changes", err)
+	}
+	return caddy.Reload()
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/module"
+	"github.com/doko/cli-webpanel/internal/site"
+)
+
+// phpSnippetPattern matches the snippets written by "php install", e.g.
+// php82_config
+var phpSnippetPattern = regexp.MustCompile(`^php([0-9])([0-9])_config$`)
+
+// phpSnippet returns the snippet serving a PHP version. The php module
+// serves the default version.
+func phpSnippet(version string) string {
+	if version == "" {
+		return "php_config"
+	}
+	return "php" + strings.ReplaceAll(version, ".", "") + "_config"
+}
+
+// phpInstalled reports whether the snippet of a PHP version exists
+func phpInstalled(version string) bool {
+	if version == "" {
+		return true
+	}
+	name := "php" + strings.ReplaceAll(version, ".", "") + ".conf"
+	_, err := os.Stat(filepath.Join(config.GetConfigDir(), "modules", name))
+	return err == nil
+}
+
+// render returns the Caddy configuration of a site
+func (s Site) render() *site.Config {
+	c := &site.Config{
+		Addresses: append([]string{s.Domain}, s.Aliases...),
+		Root:      site.PublicDir(s.Domain),
+		Lines: []string{
+			"import access_log " + s.Domain,
+			"import error_log " + s.Domain,
+		},
+	}
+
+	for _, m := range s.Modules {
+		mod, _ := module.GetModule(m.Name)
+		c.Lines = append(c.Lines, strings.Join(append([]string{"import", mod.Snippet()}, m.Params...), " "))
+	}
+
+	switch s.Type {
+	case TypePHP:
+		c.Lines = append(c.Lines, "import "+phpSnippet(s.PHP))
+	case TypeSPA:
+		spa, _ := module.GetModule("spa")
+		c.Lines = append(c.Lines, "import "+spa.Snippet())
+	case TypeStatic:
+		c.Lines = append(c.Lines, "file_server")
+	}
+	return c
+}
+
+// siteFromConfig describes a site by its Caddy configuration. Directives
+// the state cannot express are returned separately.
+func siteFromConfig(domain string, c *site.Config) (Site, []string) {
+	s := Site{Domain: domain}
+	for _, address := range c.Addresses {
+		if address != domain {
+			s.Aliases = append(s.Aliases, address)
+		}
+	}
+
+	var unsupported []string
+	for _, line := range c.Lines {
+		fields := strings.Fields(line)
+		if line == "file_server" {
+			s.Type = TypeStatic
+			continue
+		}
+		if len(fields) < 2 || fields[0] != "import" {
+			unsupported = append(unsupported, line)
+			continue
+		}
+
+		name, params := fields[1], fields[2:]
+		if name == "access_log" || name == "error_log" {
+			continue
+		}
+		if m := phpSnippetPattern.FindStringSubmatch(name); m != nil {
+			s.Type, s.PHP = TypePHP, m[1]+"."+m[2]
+			continue
+		}
+
+		mod, ok := module.ForImport(name)
+		switch {
+		case !ok:
+			unsupported = append(unsupported, line)
+		case mod.Name == "php":
+			s.Type = TypePHP
+		case mod.Name == "spa":
+			s.Type = TypeSPA
+		default:
+			s.Modules = append(s.Modules, Module{Name: mod.Name, Params: params})
+		}
+	}
+
+	if s.Type == "" {
+		s.Type = TypeStatic
+		unsupported = append(unsupported, "no php, spa or file_server directive")
+	}
+	return s, unsupported
+}
+
+// linkChanges describes how the linked databases of a site change
+func linkChanges(current, desired []string) []string {
+	var details []string
+	for _, name := range missing(desired, current) {
+		details = append(details, fmt.Sprintf("link database %s", name))
+	}
+	for _, name := range missing(current, desired) {
+		details = append(details, fmt.Sprintf("unlink database %s", name))
+	}
+	return details
+}
+
+// missing returns the names of a that are not in b
+func missing(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, name := range b {
+		in[name] = true
+	}
+	var result []string
+	for _, name := range a {
+		if !in[name] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,264 @@
+// Package state describes the sites, databases, users and backup schedules
+// of a server in a YAML file, and converges the server to such a file
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/doko/cli-webpanel/internal/backup"
+	"github.com/doko/cli-webpanel/internal/config"
+	"github.com/doko/cli-webpanel/internal/module"
+	"gopkg.in/yaml.v3"
+)
+
+// Site types
+const (
+	TypePHP    = "php"
+	TypeStatic = "static"
+	TypeSPA    = "spa"
+)
+
+// phpVersionPattern matches PHP versions as "php install" takes them
+var phpVersionPattern = regexp.MustCompile(`^[0-9]\.[0-9]$`)
+
+// State is the desired or current state of a server
+type State struct {
+	Sites     []Site   `yaml:"sites,omitempty"`
+	Databases []string `yaml:"databases,omitempty"`
+	Users     []User   `yaml:"users,omitempty"`
+	Backups   []Backup `yaml:"backups,omitempty"`
+}
+
+// Site is a website and its Caddy configuration
+type Site struct {
+	Domain    string   `yaml:"domain"`
+	Type      string   `yaml:"type,omitempty"` // php (default), static or spa
+	PHP       string   `yaml:"php,omitempty"`  // PHP version of a php site, e.g. "8.2"
+	Aliases   []string `yaml:"aliases,omitempty"`
+	Modules   []Module `yaml:"modules,omitempty"`
+	Databases []string `yaml:"databases,omitempty"` // linked databases
+}
+
+// Module is a module enabled for a site
+type Module struct {
+	Name   string   `yaml:"name"`
+	Params []string `yaml:"params,omitempty"`
+}
+
+// UnmarshalYAML accepts a module without parameters as a plain name
+func (m *Module) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&m.Name)
+	}
+	type plain Module
+	return node.Decode((*plain)(m))
+}
+
+// MarshalYAML writes a module without parameters as a plain name
+func (m Module) MarshalYAML() (interface{}, error) {
+	if len(m.Params) == 0 {
+		return m.Name, nil
+	}
+	type plain Module
+	return plain(m), nil
+}
+
+// User is a database user. The password is only used to create the user.
+type User struct {
+	Name        string   `yaml:"name"`
+	Password    string   `yaml:"password,omitempty"`
+	PasswordEnv string   `yaml:"password_env,omitempty"` // environment variable holding the password
+	Grants      []string `yaml:"grants,omitempty"`       // databases the user has access to
+}
+
+// Backup is the backup schedule of a site or a database
+type Backup struct {
+	Site     string   `yaml:"site,omitempty"`
+	Database string   `yaml:"database,omitempty"`
+	Schedule []string `yaml:"schedule"` // daily and/or weekly
+}
+
+// kind returns the backup kind and target
+func (b Backup) kind() (string, string) {
+	if b.Database != "" {
+		return backup.KindDatabase, b.Database
+	}
+	return backup.KindSite, b.Site
+}
+
+// Load reads and validates a state file
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	if err := s.normalize(); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// normalize checks the state and fills in defaults
+func (s *State) normalize() error {
+	sites := make(map[string]bool)
+	for i := range s.Sites {
+		site := &s.Sites[i]
+		if err := config.ValidateSiteName(site.Domain); err != nil {
+			return err
+		}
+		if sites[site.Domain] {
+			return fmt.Errorf("duplicate site: %s", site.Domain)
+		}
+		sites[site.Domain] = true
+
+		if site.Type == "" {
+			site.Type = TypePHP
+		}
+		switch site.Type {
+		case TypePHP:
+			if site.PHP != "" && !phpVersionPattern.MatchString(site.PHP) {
+				return fmt.Errorf("site %s: invalid PHP version %q (must be like '8.1')", site.Domain, site.PHP)
+			}
+		case TypeStatic, TypeSPA:
+			if site.PHP != "" {
+				return fmt.Errorf("site %s: php is only valid for php sites", site.Domain)
+			}
+		default:
+			return fmt.Errorf("site %s: invalid type %q (must be php, static or spa)", site.Domain, site.Type)
+		}
+
+		for _, alias := range site.Aliases {
+			if alias == "" || strings.ContainsAny(alias, " ,{}") {
+				return fmt.Errorf("site %s: invalid alias %q", site.Domain, alias)
+			}
+		}
+
+		for _, m := range site.Modules {
+			switch m.Name {
+			case "php", "spa":
+				return fmt.Errorf("site %s: use type instead of the %s module", site.Domain, m.Name)
+			case "access_log", "error_log":
+				return fmt.Errorf("site %s: the %s module is always enabled", site.Domain, m.Name)
+			}
+			if _, err := module.GetModule(m.Name); err != nil {
+				return fmt.Errorf("site %s: %v", site.Domain, err)
+			}
+		}
+
+		if err := validNames("site "+site.Domain+" database", site.Databases); err != nil {
+			return err
+		}
+	}
+
+	if err := validNames("database", s.Databases); err != nil {
+		return err
+	}
+
+	users := make(map[string]bool)
+	for i := range s.Users {
+		user := &s.Users[i]
+		if err := validNames("user", []string{user.Name}); err != nil {
+			return err
+		}
+		if users[user.Name] {
+			return fmt.Errorf("duplicate user: %s", user.Name)
+		}
+		users[user.Name] = true
+
+		if user.PasswordEnv != "" {
+			if user.Password != "" {
+				return fmt.Errorf("user %s: set either password or password_env", user.Name)
+			}
+			user.Password = os.Getenv(user.PasswordEnv)
+		}
+		// CreateUser quotes the password into SQL
+		if strings.ContainsAny(user.Password, `'\`) {
+			return fmt.Errorf("user %s: password must not contain quotes or backslashes", user.Name)
+		}
+		if err := validNames("user "+user.Name+" grant", user.Grants); err != nil {
+			return err
+		}
+	}
+
+	backups := make(map[string]bool)
+	for _, b := range s.Backups {
+		if (b.Site == "") == (b.Database == "") {
+			return fmt.Errorf("backup: set either site or database")
+		}
+		kind, target := b.kind()
+		if backups[kind+" "+target] {
+			return fmt.Errorf("duplicate backup of %s %s", kind, target)
+		}
+		backups[kind+" "+target] = true
+		for _, t := range b.Schedule {
+			if t != backup.DailyBackup && t != backup.WeeklyBackup {
+				return fmt.Errorf("backup of %s %s: invalid schedule %q (must be daily or weekly)", kind, target, t)
+			}
+		}
+	}
+
+	s.sort()
+	return nil
+}
+
+func validNames(what string, names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if config.ValidateDatabaseName(name) != nil {
+			return fmt.Errorf("invalid %s name %q", what, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate %s: %s", what, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// sort orders the lists that have no meaningful order, so states compare
+// and export stably
+func (s *State) sort() {
+	sort.Slice(s.Sites, func(i, j int) bool { return s.Sites[i].Domain < s.Sites[j].Domain })
+	for i := range s.Sites {
+		sort.Strings(s.Sites[i].Databases)
+	}
+	sort.Strings(s.Databases)
+	sort.Slice(s.Users, func(i, j int) bool { return s.Users[i].Name < s.Users[j].Name })
+	for i := range s.Users {
+		sort.Strings(s.Users[i].Grants)
+	}
+	sort.Slice(s.Backups, func(i, j int) bool {
+		ki, ti := s.Backups[i].kind()
+		kj, tj := s.Backups[j].kind()
+		return ki+" "+ti < kj+" "+tj
+	})
+	for i := range s.Backups {
+		sort.Strings(s.Backups[i].Schedule)
+	}
+}
+
+// Marshal formats the state as YAML
+func (s *State) Marshal() ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,5 @@
+package version
+
+// Version is the webpanel release version. It can be overridden at build time
+// with -ldflags "-X github.com/doko/cli-webpanel/internal/version.Version=..."
+var Version = "0.1.0"
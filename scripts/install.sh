@@ -220,24 +220,6 @@ cat > "$modules_dir/restrict.conf" <<EOF
 }
 EOF
 
-# Logging modules
-cat > "$modules_dir/logging.conf" <<EOF
-(access_log) {
-    log access {
-        output file /var/log/webpanel/caddy/{args.0}/access.log
-        format json
-    }
-}
-
-(error_log) {
-    log error {
-        output file /var/log/webpanel/caddy/{args.0}/error.log
-        format json
-        level ERROR
-    }
-}
-EOF
-
 # Link Caddy configuration
 ln -sf /usr/local/webpanel/config/global/Caddyfile /etc/caddy/Caddyfile
 
@@ -252,39 +234,10 @@ chmod -R 755 /var/log/webpanel
 chown -R www-data:www-data /backup
 chmod -R 755 /backup
 
-# Set up logrotate
+# Logging modules with Caddy log rolling and logrotate for the panel and
+# MariaDB logs, both from the logging settings
 echo -e "\n${YELLOW}Configuring log rotation...${NC}"
-cat > /etc/logrotate.d/webpanel <<EOF
-/var/log/webpanel/caddy/*/*.log {
-    daily
-    rotate 14
-    compress
-    delaycompress
-    missingok
-    notifempty
-    create 0644 www-data www-data
-}
-
-/var/log/webpanel/mariadb/*.log {
-    daily
-    rotate 14
-    compress
-    delaycompress
-    missingok
-    notifempty
-    create 0644 mysql mysql
-}
-
-/var/log/webpanel/webpanel/*.log {
-    daily
-    rotate 14
-    compress
-    delaycompress
-    missingok
-    notifempty
-    create 0644 root root
-}
-EOF
+/usr/local/bin/webpanel logs setup --no-reload
 
 # Create cron directory structure
 mkdir -p /etc/cron.daily /etc/cron.weekly